@@ -0,0 +1,407 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conformance checks that a virtual_disks.DiskReaderWriter behaves like a plain byte array of
+// Capacity() bytes. Run makes random reads, writes and seeks on the disk and compares every result with an
+// in-memory reference disk:
+//
+//   - ReadAt and WriteAt at aligned and misaligned offsets, so that requests are split into a partial head
+//     sector, aligned middle sectors and a partial tail sector, return the same data as the reference and
+//     leave the bytes around the request alone.
+//   - ReadAt starting before Capacity() but ending after it is cut at the end of the disk and returns the
+//     bytes read with a nil error; starting at or after Capacity() it returns io.EOF.
+//   - WriteAt ending after Capacity() writes nothing and returns io.ErrShortWrite.
+//   - Read, Write and Seek share one offset, which Read and Write advance by the bytes transferred and
+//     ReadAt and WriteAt leave alone. Seeking past the end is allowed, to a negative offset it is not.
+//
+// The checks work with any Backend and DiskOption, since they only use the DiskReaderWriter. The disk is
+// read in full to initialize the reference, so it should be small, e.g. 1 MiB. A failing run logs its seed,
+// which WithSeed replays.
+//
+// MemBackend is an in-memory Backend the checks pass on, for tests that need a disk without VDDK or files.
+package conformance
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+// Opener returns a writable disk for one check. Run closes it when the check is done. The disk may hold
+// any data; the reference is read from it.
+type Opener func(t *testing.T) virtual_disks.DiskReaderWriter
+
+// Option configures Run.
+type Option func(*config)
+
+type config struct {
+	seed       int64
+	iterations int
+}
+
+// defaultIterations is the number of random operations per check if WithIterations is not given.
+const defaultIterations = 500
+
+// WithSeed seeds the random operations, e.g. with the seed logged by a failing run. By default the seed is
+// taken from the clock.
+func WithSeed(seed int64) Option {
+	return func(config *config) {
+		config.seed = seed
+	}
+}
+
+// WithIterations sets the number of random operations of each check.
+func WithIterations(iterations int) Option {
+	return func(config *config) {
+		config.iterations = iterations
+	}
+}
+
+// BackendOpener returns an Opener opening the backend returned by newBackend with opts, see
+// virtual_disks.OpenBackend.
+func BackendOpener(newBackend func(t *testing.T) virtual_disks.Backend, opts ...virtual_disks.DiskOption) Opener {
+	return func(t *testing.T) virtual_disks.DiskReaderWriter {
+		logger := logrus.New()
+		logger.SetLevel(logrus.WarnLevel)
+		diskReaderWriter, err := virtual_disks.OpenBackend(newBackend(t), logger, opts...)
+		if err != nil {
+			t.Fatalf("OpenBackend failed: %v", err)
+		}
+		return diskReaderWriter
+	}
+}
+
+// Run runs the checks as subtests of t, each on a disk returned by open.
+func Run(t *testing.T, open Opener, opts ...Option) {
+	config := config{
+		seed:       time.Now().UnixNano(),
+		iterations: defaultIterations,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	checks := []struct {
+		name  string
+		check func(*checker)
+	}{
+		{"ReadAt", (*checker).checkReadAt},
+		{"WriteAt", (*checker).checkWriteAt},
+		{"Offset", (*checker).checkOffset},
+		{"EndOfDisk", (*checker).checkEndOfDisk},
+	}
+	for _, check := range checks {
+		check := check
+		t.Run(check.name, func(t *testing.T) {
+			t.Cleanup(func() {
+				if t.Failed() {
+					t.Logf("Replay with conformance.WithSeed(%d)", config.seed)
+				}
+			})
+			checker := newChecker(t, open(t), config)
+			defer checker.close()
+			check.check(checker)
+			checker.verifyDisk()
+		})
+	}
+}
+
+// checker runs operations on a disk and on the reference, failing the test where they differ.
+type checker struct {
+	t          *testing.T
+	disk       virtual_disks.DiskReaderWriter
+	random     *rand.Rand
+	iterations int
+	reference  []byte
+	offset     int64 // expected offset of Read, Write and Seek
+	sectorSize int64
+}
+
+func newChecker(t *testing.T, disk virtual_disks.DiskReaderWriter, config config) *checker {
+	capacity := disk.Disk().Capacity()
+	checker := &checker{
+		t:          t,
+		disk:       disk,
+		random:     rand.New(rand.NewSource(config.seed)),
+		iterations: config.iterations,
+		reference:  make([]byte, capacity),
+		sectorSize: disk.Disk().SectorSize(),
+	}
+	if capacity < 4*checker.sectorSize {
+		disk.Close()
+		t.Fatalf("The disk holds %d bytes, at least 4 sectors of %d bytes are needed", capacity, checker.sectorSize)
+	}
+	if n, err := disk.ReadAt(checker.reference, 0); n != len(checker.reference) || err != nil {
+		disk.Close()
+		t.Fatalf("Reading the disk to initialize the reference returned %d, %v", n, err)
+	}
+	return checker
+}
+
+func (this *checker) close() {
+	if err := this.disk.Close(); err != nil {
+		this.t.Errorf("Close failed: %v", err)
+	}
+}
+
+func (this *checker) capacity() int64 {
+	return int64(len(this.reference))
+}
+
+// randomOffset returns an offset biased towards sector boundaries and the end of the disk, including a
+// little past it.
+func (this *checker) randomOffset() int64 {
+	numSectors := this.capacity() / this.sectorSize
+	switch this.random.Intn(8) {
+	case 0, 1:
+		return this.random.Int63n(numSectors) * this.sectorSize
+	case 2:
+		// Next to a sector boundary
+		off := this.random.Int63n(numSectors)*this.sectorSize + int64(this.random.Intn(3)) - 1
+		if off < 0 {
+			return 0
+		}
+		return off
+	case 3, 4:
+		return this.random.Int63n(this.capacity())
+	case 5, 6:
+		return this.capacity() - this.random.Int63n(3*this.sectorSize) - 1
+	default:
+		return this.capacity() + this.random.Int63n(this.sectorSize)
+	}
+}
+
+// randomLength returns a length of up to 16 sectors, biased towards whole sectors and less than a sector.
+func (this *checker) randomLength() int {
+	sectorSize := int(this.sectorSize)
+	switch this.random.Intn(6) {
+	case 0:
+		return this.random.Intn(sectorSize)
+	case 1:
+		return (this.random.Intn(8) + 1) * sectorSize
+	case 2:
+		return (this.random.Intn(8)+1)*sectorSize + this.random.Intn(3) - 1
+	default:
+		return this.random.Intn(16*sectorSize) + 1
+	}
+}
+
+func (this *checker) randomData(length int) []byte {
+	data := make([]byte, length)
+	this.random.Read(data)
+	return data
+}
+
+// expectRead returns the result of reading length bytes at off from the reference.
+func (this *checker) expectRead(off int64, length int) (int, error) {
+	if off >= this.capacity() {
+		return 0, io.EOF
+	}
+	if off+int64(length) > this.capacity() {
+		return int(this.capacity() - off), nil
+	}
+	return length, nil
+}
+
+// expectWrite returns the result of writing data at off to the reference and applies the write.
+func (this *checker) expectWrite(data []byte, off int64) (int, error) {
+	if off+int64(len(data)) > this.capacity() {
+		return 0, io.ErrShortWrite
+	}
+	copy(this.reference[off:], data)
+	return len(data), nil
+}
+
+// checkResult fails the test if n and err differ from the expected result of op.
+func (this *checker) checkResult(op string, n int, err error, expectedN int, expectedErr error) {
+	this.t.Helper()
+	if n != expectedN || !sameError(err, expectedErr) {
+		this.t.Fatalf("%s returned %d, %v, expected %d, %v", op, n, err, expectedN, expectedErr)
+	}
+}
+
+func sameError(err error, expected error) bool {
+	if expected == nil {
+		return err == nil
+	}
+	return errors.Is(err, expected)
+}
+
+// checkData fails the test unless buf holds the first n bytes of the reference at off, followed by the
+// untouched fill byte.
+func (this *checker) checkData(op string, buf []byte, n int, off int64, fill byte) {
+	this.t.Helper()
+	if n > 0 && !bytes.Equal(buf[:n], this.reference[off:off+int64(n)]) {
+		this.t.Fatalf("%s returned data differing from the reference at byte %d", op, off+int64(firstDifference(buf[:n], this.reference[off:])))
+	}
+	for i := n; i < len(buf); i++ {
+		if buf[i] != fill {
+			this.t.Fatalf("%s changed byte %d of the buffer, beyond the %d bytes read", op, i, n)
+		}
+	}
+}
+
+func firstDifference(a []byte, b []byte) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return len(a)
+}
+
+func (this *checker) readAt(off int64, length int) {
+	this.t.Helper()
+	op := fmt.Sprintf("ReadAt(%d bytes, %d)", length, off)
+	buf := bytes.Repeat([]byte{0xEE}, length)
+	n, err := this.disk.ReadAt(buf, off)
+	expectedN, expectedErr := this.expectRead(off, length)
+	this.checkResult(op, n, err, expectedN, expectedErr)
+	this.checkData(op, buf, n, off, 0xEE)
+}
+
+func (this *checker) writeAt(off int64, length int) {
+	this.t.Helper()
+	data := this.randomData(length)
+	n, err := this.disk.WriteAt(data, off)
+	expectedN, expectedErr := this.expectWrite(data, off)
+	this.checkResult(fmt.Sprintf("WriteAt(%d bytes, %d)", length, off), n, err, expectedN, expectedErr)
+}
+
+func (this *checker) read(length int) {
+	this.t.Helper()
+	op := fmt.Sprintf("Read(%d bytes) at offset %d", length, this.offset)
+	buf := bytes.Repeat([]byte{0xEE}, length)
+	n, err := this.disk.Read(buf)
+	expectedN, expectedErr := this.expectRead(this.offset, length)
+	this.checkResult(op, n, err, expectedN, expectedErr)
+	this.checkData(op, buf, n, this.offset, 0xEE)
+	this.offset += int64(n)
+}
+
+func (this *checker) write(length int) {
+	this.t.Helper()
+	data := this.randomData(length)
+	n, err := this.disk.Write(data)
+	expectedN, expectedErr := this.expectWrite(data, this.offset)
+	this.checkResult(fmt.Sprintf("Write(%d bytes) at offset %d", length, this.offset), n, err, expectedN, expectedErr)
+	this.offset += int64(n)
+}
+
+func (this *checker) seek(offset int64, whence int) {
+	this.t.Helper()
+	expected := offset
+	switch whence {
+	case io.SeekCurrent:
+		expected += this.offset
+	case io.SeekEnd:
+		expected += this.capacity()
+	}
+	pos, err := this.disk.Seek(offset, whence)
+	if expected < 0 {
+		if err == nil {
+			this.t.Fatalf("Seek(%d, %d) to a negative offset succeeded", offset, whence)
+		}
+		return
+	}
+	if pos != expected || err != nil {
+		this.t.Fatalf("Seek(%d, %d) returned %d, %v, expected %d", offset, whence, pos, err, expected)
+	}
+	this.offset = expected
+}
+
+// checkReadAt reads at random offsets.
+func (this *checker) checkReadAt() {
+	for i := 0; i < this.iterations; i++ {
+		this.readAt(this.randomOffset(), this.randomLength())
+	}
+}
+
+// checkWriteAt writes at random offsets, reading the written range and a sector on either side back after
+// every write to catch damage to the head and tail sectors.
+func (this *checker) checkWriteAt() {
+	for i := 0; i < this.iterations; i++ {
+		off, length := this.randomOffset(), this.randomLength()
+		this.writeAt(off, length)
+		start := off - this.sectorSize
+		if start < 0 {
+			start = 0
+		}
+		this.readAt(start, int(off-start)+length+int(this.sectorSize))
+	}
+}
+
+// checkOffset mixes Read, Write and Seek with ReadAt and WriteAt, which must not move the offset.
+func (this *checker) checkOffset() {
+	for i := 0; i < this.iterations; i++ {
+		switch this.random.Intn(7) {
+		case 0:
+			this.read(this.randomLength())
+		case 1:
+			this.write(this.randomLength())
+		case 2:
+			this.seek(this.randomOffset(), io.SeekStart)
+		case 3:
+			this.seek(this.randomOffset()-this.offset, io.SeekCurrent)
+		case 4:
+			this.seek(this.randomOffset()-this.capacity(), io.SeekEnd)
+		case 5:
+			this.readAt(this.randomOffset(), this.randomLength())
+		default:
+			this.writeAt(this.randomOffset(), this.randomLength())
+		}
+	}
+}
+
+// checkEndOfDisk makes the requests next to the end of the disk that the random ones may miss.
+func (this *checker) checkEndOfDisk() {
+	capacity := this.capacity()
+	this.readAt(capacity-1, 1)
+	this.readAt(capacity-1, 2)
+	this.readAt(capacity-this.sectorSize-1, int(2*this.sectorSize))
+	this.readAt(capacity, 1)
+	this.readAt(capacity+this.sectorSize, 1)
+	this.writeAt(capacity-1, 1)
+	this.writeAt(capacity-1, 2)
+	this.writeAt(capacity-this.sectorSize+1, int(this.sectorSize))
+	this.writeAt(capacity, 1)
+	this.writeAt(capacity+1, 0)
+
+	this.seek(-1, io.SeekStart)
+	this.seek(-this.sectorSize-1, io.SeekEnd)
+	this.read(int(2 * this.sectorSize))
+	this.read(1)
+	this.seek(-2, io.SeekCurrent)
+	this.write(3)
+	this.write(2)
+	this.seek(1, io.SeekEnd)
+	this.read(1)
+	this.write(0)
+}
+
+// verifyDisk syncs the disk and compares it with the reference in full.
+func (this *checker) verifyDisk() {
+	if err := this.disk.Sync(); err != nil {
+		this.t.Fatalf("Sync failed: %v", err)
+	}
+	this.readAt(0, len(this.reference))
+}
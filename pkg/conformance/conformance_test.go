@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conformance
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/virtual-disks/pkg/disklib"
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+// newMemBackend returns a MemBackend of size bytes holding random data, with sectors of sectorSize bytes.
+func newMemBackend(size int, sectorSize uint32) func(t *testing.T) virtual_disks.Backend {
+	return func(t *testing.T) virtual_disks.Backend {
+		backend := NewMemBackend(size, sectorSize)
+		rand.New(rand.NewSource(1)).Read(backend.Bytes())
+		return backend
+	}
+}
+
+// diskOptions are the option sets the checks are run with, since each of them changes how requests are split.
+var diskOptions = []struct {
+	name string
+	opts []virtual_disks.DiskOption
+}{
+	{"Plain", nil},
+	{"BlockCache", []virtual_disks.DiskOption{virtual_disks.WithBlockCache(4096, 8)}},
+	{"ReadAhead", []virtual_disks.DiskOption{virtual_disks.WithReadAhead(8192, 2)}},
+	{"WriteBack", []virtual_disks.DiskOption{virtual_disks.WithWriteBack(4096)}},
+	{"All", []virtual_disks.DiskOption{
+		virtual_disks.WithBlockCache(4096, 8),
+		virtual_disks.WithReadAhead(8192, 2),
+		virtual_disks.WithWriteBack(4096),
+	}},
+}
+
+func TestMemBackend(t *testing.T) {
+	for _, options := range diskOptions {
+		t.Run(options.name, func(t *testing.T) {
+			Run(t, BackendOpener(newMemBackend(256*1024, disklib.VIXDISKLIB_SECTOR_SIZE), options.opts...))
+		})
+	}
+}
+
+func TestMemBackend4Kn(t *testing.T) {
+	for _, options := range diskOptions {
+		t.Run(options.name, func(t *testing.T) {
+			Run(t, BackendOpener(newMemBackend(256*1024, 4096), options.opts...))
+		})
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	for _, options := range diskOptions {
+		t.Run(options.name, func(t *testing.T) {
+			Run(t, func(t *testing.T) virtual_disks.DiskReaderWriter {
+				path := filepath.Join(t.TempDir(), "disk.img")
+				if err := os.WriteFile(path, make([]byte, 256*1024), 0644); err != nil {
+					t.Fatal(err)
+				}
+				logger := logrus.New()
+				logger.SetLevel(logrus.WarnLevel)
+				diskReaderWriter, err := virtual_disks.OpenFile(path, false, logger, options.opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return diskReaderWriter
+			}, WithIterations(200))
+		})
+	}
+}
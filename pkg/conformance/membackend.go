@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conformance
+
+import (
+	"sync"
+
+	"github.com/vmware/virtual-disks/pkg/disklib"
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+// MemBackend is a virtual_disks.Backend keeping the disk in memory. It is the reference backend the checks
+// are validated against, and can be used by tests of code built on virtual_disks. Allocation is not tracked,
+// so QueryAllocatedBlocks returns ErrNotSupported.
+type MemBackend struct {
+	mutex      sync.Mutex
+	data       []byte
+	sectorSize uint32
+	metadata   map[string]string
+	readOnly   bool
+	calls      int
+}
+
+var _ virtual_disks.Backend = (*MemBackend)(nil)
+
+// NewMemBackend returns a MemBackend of size bytes, all zero, with logical sectors of sectorSize bytes. size
+// should be a multiple of sectorSize.
+func NewMemBackend(size int, sectorSize uint32) *MemBackend {
+	return &MemBackend{
+		data:       make([]byte, size),
+		sectorSize: sectorSize,
+		metadata:   make(map[string]string),
+	}
+}
+
+// Bytes returns the content of the disk. It is not a copy, changes to it change the disk.
+func (this *MemBackend) Bytes() []byte {
+	return this.data
+}
+
+// SetReadOnly sets whether the backend reports itself as read only. Writes are accepted either way, it is up
+// to the Disk to refuse them.
+func (this *MemBackend) SetReadOnly(readOnly bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.readOnly = readOnly
+}
+
+// Calls returns the number of ReadSectors and WriteSectors calls so far.
+func (this *MemBackend) Calls() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.calls
+}
+
+func (this *MemBackend) sectors(startSector uint64, numSectors uint64) ([]byte, error) {
+	this.calls++
+	start := startSector * disklib.VIXDISKLIB_SECTOR_SIZE
+	end := (startSector + numSectors) * disklib.VIXDISKLIB_SECTOR_SIZE
+	// Like VDDK on a 4Kn disk, only whole logical sectors can be accessed
+	if start%uint64(this.sectorSize) != 0 || end%uint64(this.sectorSize) != 0 {
+		return nil, disklib.ErrInvalidArg
+	}
+	if end > uint64(len(this.data)) {
+		return nil, disklib.ErrOutOfRange
+	}
+	return this.data[start:end], nil
+}
+
+func (this *MemBackend) ReadSectors(startSector uint64, numSectors uint64, buf []byte) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	data, err := this.sectors(startSector, numSectors)
+	if err == nil {
+		copy(buf, data)
+	}
+	return err
+}
+
+func (this *MemBackend) WriteSectors(startSector uint64, numSectors uint64, buf []byte) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	data, err := this.sectors(startSector, numSectors)
+	if err == nil {
+		copy(data, buf)
+	}
+	return err
+}
+
+func (this *MemBackend) Info() (disklib.VixDiskLibInfo, error) {
+	return disklib.VixDiskLibInfo{
+		Capacity:          disklib.VixDiskLibSectorType(len(this.data) / disklib.VIXDISKLIB_SECTOR_SIZE),
+		LogicalSectorSize: this.sectorSize,
+	}, nil
+}
+
+func (this *MemBackend) QueryAllocatedBlocks(startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, error) {
+	return nil, disklib.ErrNotSupported
+}
+
+func (this *MemBackend) ReadMetadata(key string) (string, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	val, ok := this.metadata[key]
+	if !ok {
+		return "", disklib.ErrKeyNotFound
+	}
+	return val, nil
+}
+
+func (this *MemBackend) WriteMetadata(key string, val string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.metadata[key] = val
+	return nil
+}
+
+func (this *MemBackend) GetMetadataKeys() ([]string, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var keys []string
+	for key := range this.metadata {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (this *MemBackend) ReadOnly() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.readOnly
+}
+
+func (this *MemBackend) Flush() error {
+	return nil
+}
+
+func (this *MemBackend) Close() error {
+	return nil
+}
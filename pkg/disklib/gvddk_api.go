@@ -16,49 +16,67 @@ limitations under the License.
 
 package disklib
 
-// #cgo LDFLAGS: -L/usr/local/vmware-vix-disklib-distrib/lib64 -lvixDiskLib
-// #cgo CFLAGS: -I/usr/local/vmware-vix-disklib-distrib/include
+// #cgo LDFLAGS: -ldl
+// #cgo CFLAGS: -I${SRCDIR}/include
 // #include "gvddk_c.h"
 import "C"
 import (
+	"context"
 	"fmt"
+	"strings"
 	"unsafe"
 )
 
-//export GoLogWarn
-func GoLogWarn(buf *C.char) {
-	fmt.Println(C.GoString(buf))
-}
-
-func Init(majorVersion uint32, minorVersion uint32, dir string) VddkError {
+// Init initializes VDDK, loading the library from dir first if Load has not been called. Without a
+// WithLogger or WithLogFunc option VDDK's own log, warning and panic handlers are used.
+func Init(majorVersion uint32, minorVersion uint32, dir string, opts ...InitOption) VddkError {
+	if !Loaded() {
+		if err := Load(dir); err != nil {
+			return err
+		}
+	}
 	libDir := C.CString(dir)
 	defer C.free(unsafe.Pointer(libDir))
-	result := C.Init(C.uint32(majorVersion), C.uint32(minorVersion), libDir)
+	logToGo := setLogFunc(opts)
+	result := C.Init(C.uint32(majorVersion), C.uint32(minorVersion), libDir, C._Bool(logToGo))
 	if result != 0 {
-		return NewVddkError(uint64(result), fmt.Sprintf("Initialize failed. The error code is %d.", result))
+		return newVixError(result, "Initialize failed", "Init", fmt.Sprintf("%d, %d, libDir=%q", majorVersion, minorVersion, dir))
 	}
 	return nil
 }
 
-func InitEx(majorVersion uint32, minorVersion uint32, dir string, configFile string) VddkError {
+// InitEx initializes VDDK with a configuration file. Options are the same as for Init.
+func InitEx(majorVersion uint32, minorVersion uint32, dir string, configFile string, opts ...InitOption) VddkError {
+	if !Loaded() {
+		if err := Load(dir); err != nil {
+			return err
+		}
+	}
 	var result C.VixError
 	libDir := C.CString(dir)
 	defer C.free(unsafe.Pointer(libDir))
+	logToGo := setLogFunc(opts)
 	if configFile == "" {
-		result = C.Init(C.uint32(majorVersion), C.uint32(minorVersion), libDir)
+		result = C.Init(C.uint32(majorVersion), C.uint32(minorVersion), libDir, C._Bool(logToGo))
 	} else {
 		config := C.CString(configFile)
 		defer C.free(unsafe.Pointer(config))
-		result = C.InitEx(C.uint32(majorVersion), C.uint32(minorVersion), libDir, config)
+		result = C.InitEx(C.uint32(majorVersion), C.uint32(minorVersion), libDir, config, C._Bool(logToGo))
 	}
 
 	if result != 0 {
-		return NewVddkError(uint64(result), fmt.Sprintf("Initialize failed. The error code is %d.", result))
+		return newVixError(result, "Initialize failed", "Init", fmt.Sprintf("%d, %d, libDir=%q", majorVersion, minorVersion, dir))
 	}
 	return nil
 }
 
-func prepareConnectParams(appGlobal ConnectParams) (*C.VixDiskLibConnectParams, []*C.char) {
+// prepareConnectParams builds the VDDK connection parameters for appGlobal. The returned function
+// releases them along with the strings they point to and must be called once VDDK is done with them.
+func prepareConnectParams(appGlobal ConnectParams) (*C.VixDiskLibConnectParams, func(), VddkError) {
+	var cnxParams *C.VixDiskLibConnectParams = C.AllocateConnectParams()
+	if cnxParams == nil {
+		return nil, nil, NewVddkError(VIX_E_OUT_OF_MEMORY, "Allocate connect params failed")
+	}
 	// Trans string to CString
 	vmxSpec := C.CString(appGlobal.vmxSpec)
 	serverName := C.CString(appGlobal.serverName)
@@ -70,8 +88,6 @@ func prepareConnectParams(appGlobal ConnectParams) (*C.VixDiskLibConnectParams,
 	fcdssId := C.CString(appGlobal.fcdssId)
 	cookie := C.CString(appGlobal.cookie)
 	var cParams = []*C.char{vmxSpec, serverName, thumbPrint, userName, password, fcdId, ds, fcdssId, cookie}
-	// Construct connparams which can be c wrapper used directly
-	var cnxParams *C.VixDiskLibConnectParams = C.VixDiskLib_AllocateConnectParams()
 	if appGlobal.fcdId != "" {
 		cnxParams.specType = C.VIXDISKLIB_SPEC_VSTORAGE_OBJECT
 		C.Params_helper(cnxParams, fcdId, ds, fcdssId, true, false)
@@ -88,136 +104,216 @@ func prepareConnectParams(appGlobal ConnectParams) (*C.VixDiskLibConnectParams,
 		cnxParams.credType = C.VIXDISKLIB_CRED_SESSIONID
 		C.Params_helper(cnxParams, cookie, userName, password, false, true)
 	}
-	return cnxParams, cParams
+	return cnxParams, func() {
+		C.FreeConnectParams(cnxParams)
+		freeParams(cParams)
+	}, nil
+}
+
+// connectArgs describes the connection parameters for error messages, leaving out credentials.
+func connectArgs(appGlobal ConnectParams) string {
+	return fmt.Sprintf("serverName=%q, vmxSpec=%q, fcdId=%q, datastore=%q", appGlobal.serverName, appGlobal.vmxSpec, appGlobal.fcdId, appGlobal.ds)
 }
 
 func freeParams(params []*C.char) {
-	for i, _ := range params {
-		C.free(unsafe.Pointer(params[i]))
+	for _, param := range params {
+		C.free(unsafe.Pointer(param))
 	}
-	return
 }
 
 func Connect(appGlobal ConnectParams) (VixDiskLibConnection, VddkError) {
+	if err := appGlobal.validate(); err != nil {
+		return VixDiskLibConnection{}, err
+	}
+	if err := checkSymbol("VixDiskLib_Connect", "Connect"); err != nil {
+		return VixDiskLibConnection{}, err
+	}
 	var connection VixDiskLibConnection
-	cnxParams, toFree := prepareConnectParams(appGlobal)
-	defer freeParams(toFree)
-	err := C.Connect(cnxParams, &connection.conn)
-	if err != 0 {
-		return VixDiskLibConnection{}, NewVddkError(uint64(err), fmt.Sprintf("Connect failed. The error code is %d.", err))
+	cnxParams, freeCnxParams, err := prepareConnectParams(appGlobal)
+	if err != nil {
+		return VixDiskLibConnection{}, err
+	}
+	defer freeCnxParams()
+	res := C.Connect(cnxParams, &connection.conn)
+	if res != 0 {
+		return VixDiskLibConnection{}, newVixError(res, "Connect failed", "Connect", connectArgs(appGlobal))
 	}
 
 	return connection, nil
 }
 
 func ConnectEx(appGlobal ConnectParams) (VixDiskLibConnection, VddkError) {
+	if err := appGlobal.validate(); err != nil {
+		return VixDiskLibConnection{}, err
+	}
+	if err := checkSymbol("VixDiskLib_ConnectEx", "ConnectEx"); err != nil {
+		return VixDiskLibConnection{}, err
+	}
 	var connection VixDiskLibConnection
-	cnxParams, toFree := prepareConnectParams(appGlobal)
-	defer freeParams(toFree)
+	cnxParams, freeCnxParams, err := prepareConnectParams(appGlobal)
+	if err != nil {
+		return VixDiskLibConnection{}, err
+	}
+	defer freeCnxParams()
 	modes := C.CString(appGlobal.mode)
 	defer C.free(unsafe.Pointer(modes))
-	err := C.ConnectEx(cnxParams, C._Bool(appGlobal.readOnly), modes, &connection.conn)
-	if err != 0 {
-		return VixDiskLibConnection{}, NewVddkError(uint64(err), fmt.Sprintf("ConnectEx failed. The error code is %d.", err))
+	res := C.ConnectEx(cnxParams, C._Bool(appGlobal.readOnly), modes, &connection.conn)
+	if res != 0 {
+		return VixDiskLibConnection{}, newVixError(res, "ConnectEx failed", "ConnectEx", connectArgs(appGlobal)+fmt.Sprintf(", readOnly=%t, modes=%q", appGlobal.readOnly, appGlobal.mode))
 	}
 
 	return connection, nil
 }
 
 func PrepareForAccess(appGlobal ConnectParams) VddkError {
+	if err := appGlobal.validate(); err != nil {
+		return err
+	}
+	if err := checkSymbol("VixDiskLib_PrepareForAccess", "PrepareForAccess"); err != nil {
+		return err
+	}
 	name := C.CString(appGlobal.identity)
 	defer C.free(unsafe.Pointer(name))
-	cnxParams, toFree := prepareConnectParams(appGlobal)
-	defer freeParams(toFree)
+	cnxParams, freeCnxParams, err := prepareConnectParams(appGlobal)
+	if err != nil {
+		return err
+	}
+	defer freeCnxParams()
 	result := C.PrepareForAccess(cnxParams, name)
 	if result != 0 {
-		return NewVddkError(uint64(result), fmt.Sprintf("Prepare for access failed. The error code is %d.", result))
+		return newVixError(result, "Prepare for access failed", "PrepareForAccess", connectArgs(appGlobal)+fmt.Sprintf(", identity=%q", appGlobal.identity))
 	}
 	return nil
 }
 
 func Open(conn VixDiskLibConnection, params ConnectParams) (VixDiskLibHandle, VddkError) {
 	var dli VixDiskLibHandle
+	if err := checkLoaded("Open"); err != nil {
+		return dli, err
+	}
 	filePath := C.CString(params.path)
 	defer C.free(unsafe.Pointer(filePath))
 	res := C.Open(conn.conn, filePath, C.uint32(params.flag))
 	dli.dli = res.dli
+	if params.serverName == "" {
+		dli.localPath = params.path
+	}
 	if res.err != 0 {
-		return dli, NewVddkError(uint64(res.err), fmt.Sprintf("Open virtual disk file failed. The error code is %d.", res.err))
+		return dli, newVixError(res.err, "Open virtual disk file failed", "Open", fmt.Sprintf("path=%q, flags=%#x", params.path, params.flag))
 	}
 	return dli, nil
 }
 
 func EndAccess(appGlobal ConnectParams) VddkError {
+	if err := appGlobal.validate(); err != nil {
+		return err
+	}
+	if err := checkSymbol("VixDiskLib_EndAccess", "EndAccess"); err != nil {
+		return err
+	}
 	name := C.CString(appGlobal.identity)
 	defer C.free(unsafe.Pointer(name))
-	cnxParams, toFree := prepareConnectParams(appGlobal)
+	cnxParams, freeCnxParams, err := prepareConnectParams(appGlobal)
+	if err != nil {
+		return err
+	}
+	defer freeCnxParams()
 	result := C.VixDiskLib_EndAccess(cnxParams, name)
-	freeParams(toFree)
 	if result != 0 {
-		return NewVddkError(uint64(result), fmt.Sprintf("End access failed. The error code is %d.", result))
+		return newVixError(result, "End access failed", "EndAccess", connectArgs(appGlobal)+fmt.Sprintf(", identity=%q", appGlobal.identity))
 	}
 	return nil
 }
 
 func Disconnect(connection VixDiskLibConnection) VddkError {
+	if err := checkLoaded("Disconnect"); err != nil {
+		return err
+	}
 	res := C.VixDiskLib_Disconnect(connection.conn)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Disconnect failed. The error code is %d.", res))
+		return newVixError(res, "Disconnect failed", "Disconnect", "")
 	}
 	return nil
 }
 
 func Exit() {
+	if !Loaded() {
+		return
+	}
 	C.VixDiskLib_Exit()
 }
 
 func Attach(childHandle VixDiskLibHandle, parentHandle VixDiskLibHandle) VddkError {
+	if err := checkLoaded("Attach"); err != nil {
+		return err
+	}
 	res := C.VixDiskLib_Attach(childHandle.dli, parentHandle.dli)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Attach child disk chain to the parent disk chain failed. The error code is %d.", res))
+		return newVixError(res, "Attach child disk chain to the parent disk chain failed", "Attach", "")
 	}
 	return nil
 }
 
 func CheckRepair(connection VixDiskLibConnection, filename string, repair bool) VddkError {
+	if err := checkLoaded("CheckRepair"); err != nil {
+		return err
+	}
 	file := C.CString(filename)
 	defer C.free(unsafe.Pointer(file))
 	res := C.CheckRepair(connection.conn, file, C._Bool(repair))
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Check repair failed. The error code is %d.", res))
+		return newVixError(res, "Check repair failed", "CheckRepair", fmt.Sprintf("file=%q, repair=%t", filename, repair))
 	}
 	return nil
 }
 
-func Cleanup(appGlobal ConnectParams, numCleanUp uint32, numRemaining uint32) VddkError {
-	cnxParams, toFree := prepareConnectParams(appGlobal)
-	defer freeParams(toFree)
-	res := C.Cleanup(cnxParams, C.uint32(numCleanUp), C.uint32(numRemaining))
+// Cleanup removes leftover state from aborted transport sessions and returns how many sessions were
+// cleaned up and how many remain.
+func Cleanup(appGlobal ConnectParams) (uint32, uint32, VddkError) {
+	if err := appGlobal.validate(); err != nil {
+		return 0, 0, err
+	}
+	if err := checkSymbol("VixDiskLib_Cleanup", "Cleanup"); err != nil {
+		return 0, 0, err
+	}
+	var numCleanedUp, numRemaining C.uint32
+	cnxParams, freeCnxParams, err := prepareConnectParams(appGlobal)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer freeCnxParams()
+	res := C.Cleanup(cnxParams, &numCleanedUp, &numRemaining)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Clean up failed. The error code is %d.", res))
+		return 0, 0, newVixError(res, "Clean up failed", "Cleanup", connectArgs(appGlobal))
 	}
-	return nil
+	return uint32(numCleanedUp), uint32(numRemaining), nil
 }
 
-func Clone(dstConnection VixDiskLibConnection, dstPath string, srcConnection VixDiskLibConnection, srcPath string,
-	params VixDiskLibCreateParams, progressCallbackData string, overWrite bool) VddkError {
+// Clone copies a virtual disk, reporting progress to progress (which may be nil). Cancelling ctx aborts
+// the clone at the next progress report.
+func Clone(ctx context.Context, dstConnection VixDiskLibConnection, dstPath string, srcConnection VixDiskLibConnection, srcPath string,
+	params VixDiskLibCreateParams, progress ProgressFunc, overWrite bool) VddkError {
+	if err := checkLoaded("Clone"); err != nil {
+		return err
+	}
 	dst := C.CString(dstPath)
 	defer C.free(unsafe.Pointer(dst))
 	src := C.CString(srcPath)
 	defer C.free(unsafe.Pointer(src))
 	createParams := prepareCreateParams(params)
-	cstr := C.CString(progressCallbackData)
-	defer C.free(unsafe.Pointer(cstr))
-	res := C.Clone(dstConnection.conn, dst, srcConnection.conn, src, createParams, unsafe.Pointer(&cstr), C._Bool(overWrite))
+	progressHandle := newProgressHandle(ctx, progress)
+	defer deleteProgressHandle(progressHandle)
+	res := C.Clone(dstConnection.conn, dst, srcConnection.conn, src, &createParams, C.uintptr_t(progressHandle), C._Bool(overWrite))
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Clone a virtual disk failed. The error code is %d.", res))
+		return progressError(ctx, res, "Clone a virtual disk failed", "Clone", fmt.Sprintf("dstPath=%q, srcPath=%q, overWrite=%t", dstPath, srcPath, overWrite))
 	}
 	return nil
 }
 
-func prepareCreateParams(createSpec VixDiskLibCreateParams) *C.VixDiskLibCreateParams {
-	var createParams *C.VixDiskLibCreateParams
+// prepareCreateParams converts createSpec to its C form. The result holds no pointers, so it can live in Go
+// memory and be passed to VDDK by address.
+func prepareCreateParams(createSpec VixDiskLibCreateParams) C.VixDiskLibCreateParams {
+	var createParams C.VixDiskLibCreateParams
 	createParams.diskType = C.VixDiskLibDiskType(createSpec.diskType)
 	createParams.adapterType = C.VixDiskLibAdapterType(createSpec.adapterType)
 	createParams.hwVersion = C.uint16(createSpec.hwVersion)
@@ -225,216 +321,340 @@ func prepareCreateParams(createSpec VixDiskLibCreateParams) *C.VixDiskLibCreateP
 	return createParams
 }
 
-func Create(connection VixDiskLibConnection, path string, createParams VixDiskLibCreateParams, progressCallbackData string) VddkError {
+// Create creates a virtual disk, reporting progress to progress (which may be nil). Cancelling ctx aborts
+// the operation at the next progress report.
+func Create(ctx context.Context, connection VixDiskLibConnection, path string, createParams VixDiskLibCreateParams, progress ProgressFunc) VddkError {
+	if err := checkLoaded("Create"); err != nil {
+		return err
+	}
 	pathName := C.CString(path)
 	defer C.free(unsafe.Pointer(pathName))
 	createSpec := prepareCreateParams(createParams)
-	cstr := C.CString(progressCallbackData)
-	defer C.free(unsafe.Pointer(cstr))
-	res := C.Create(connection.conn, pathName, createSpec, unsafe.Pointer(&cstr))
+	progressHandle := newProgressHandle(ctx, progress)
+	defer deleteProgressHandle(progressHandle)
+	res := C.Create(connection.conn, pathName, &createSpec, C.uintptr_t(progressHandle))
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Create a virtual disk failed. The error code is %d.", res))
+		return progressError(ctx, res, "Create a virtual disk failed", "Create", fmt.Sprintf("path=%q", path))
 	}
 	return nil
 }
 
-func CreateChild(diskHandle VixDiskLibHandle, childPath string, diskType VixDiskLibDiskType, progressCallbackData string) VddkError {
+// CreateChild creates a child disk of diskHandle, reporting progress to progress (which may be nil).
+// Cancelling ctx aborts the operation at the next progress report.
+func CreateChild(ctx context.Context, diskHandle VixDiskLibHandle, childPath string, diskType VixDiskLibDiskType, progress ProgressFunc) VddkError {
+	if err := checkLoaded("CreateChild"); err != nil {
+		return err
+	}
 	child := C.CString(childPath)
 	defer C.free(unsafe.Pointer(child))
-	cstr := C.CString(progressCallbackData)
-	defer C.free(unsafe.Pointer(cstr))
-	res := C.CreateChild(diskHandle.dli, child, C.VixDiskLibDiskType(diskType), unsafe.Pointer(&cstr))
+	progressHandle := newProgressHandle(ctx, progress)
+	defer deleteProgressHandle(progressHandle)
+	res := C.CreateChild(diskHandle.dli, child, C.VixDiskLibDiskType(diskType), C.uintptr_t(progressHandle))
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Create child virtual disk failed. The error code is %d.", res))
+		return progressError(ctx, res, "Create child virtual disk failed", "CreateChild", fmt.Sprintf("childPath=%q, diskType=%d", childPath, diskType))
 	}
 	return nil
 }
 
-func createDiskInfo(diskInfo *VixDiskLibInfo) (*C.VixDiskLibInfo, []*C.char) {
-	var dliInfo *C.VixDiskLibInfo
-	var bios C.VixDiskLibGeometry
-	var phys C.VixDiskLibGeometry
-	bios.cylinders = C.uint32(diskInfo.BiosGeo.Cylinders)
-	bios.heads = C.uint32(diskInfo.BiosGeo.Heads)
-	bios.sectors = C.uint32(diskInfo.BiosGeo.Sectors)
-	phys.cylinders = C.uint32(diskInfo.PhysGeo.Cylinders)
-	phys.heads = C.uint32(diskInfo.PhysGeo.Heads)
-	phys.sectors = C.uint32(diskInfo.PhysGeo.Sectors)
-	dliInfo.biosGeo = bios
-	dliInfo.physGeo = phys
-	dliInfo.capacity = C.VixDiskLibSectorType(diskInfo.Capacity)
-	dliInfo.adapterType = C.VixDiskLibAdapterType(diskInfo.AdapterType)
-	dliInfo.numLinks = C.int(diskInfo.NumLinks)
-	dliInfo.parentFileNameHint = C.CString(diskInfo.ParentFileNameHint)
-	dliInfo.uuid = C.CString(diskInfo.Uuid)
-	var cParams = []*C.char{dliInfo.parentFileNameHint, dliInfo.uuid}
-	return dliInfo, cParams
-}
-
-func Grow(connection VixDiskLibConnection, path string, capacity VixDiskLibSectorType, updateGeometry bool, callbackData string) VddkError {
+// Grow extends the disk at path to capacity sectors, reporting progress to progress (which may be nil).
+// Cancelling ctx aborts the operation at the next progress report.
+func Grow(ctx context.Context, connection VixDiskLibConnection, path string, capacity VixDiskLibSectorType, updateGeometry bool, progress ProgressFunc) VddkError {
+	if err := checkLoaded("Grow"); err != nil {
+		return err
+	}
 	filePath := C.CString(path)
 	defer C.free(unsafe.Pointer(filePath))
-	cstr := C.CString(callbackData)
-	defer C.free(unsafe.Pointer(cstr))
-	res := C.Grow(connection.conn, filePath, C.VixDiskLibSectorType(capacity), C._Bool(updateGeometry), unsafe.Pointer(&cstr))
+	progressHandle := newProgressHandle(ctx, progress)
+	defer deleteProgressHandle(progressHandle)
+	res := C.Grow(connection.conn, filePath, C.VixDiskLibSectorType(capacity), C._Bool(updateGeometry), C.uintptr_t(progressHandle))
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Grow failed. The error code is %d.", res))
+		return progressError(ctx, res, "Grow failed", "Grow", fmt.Sprintf("path=%q, capacity=%d", path, capacity))
 	}
 	return nil
 }
 
 func ListTransportModes() string {
+	if !Loaded() {
+		return ""
+	}
 	res := C.VixDiskLib_ListTransportModes()
 	modes := C.GoString(res)
 	return modes
 }
 
 func Rename(srcFileName string, dstFileName string) VddkError {
+	if err := checkSymbol("VixDiskLib_Rename", "Rename"); err != nil {
+		return err
+	}
 	src := C.CString(srcFileName)
 	defer C.free(unsafe.Pointer(src))
 	dst := C.CString(dstFileName)
 	defer C.free(unsafe.Pointer(dst))
 	res := C.VixDiskLib_Rename(src, dst)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Rename failed. The error code is %d.", res))
+		return newVixError(res, "Rename failed", "Rename", fmt.Sprintf("src=%q, dst=%q", srcFileName, dstFileName))
 	}
 	return nil
 }
 
-func SpaceNeededForClone(srcHandle VixDiskLibHandle, diskType VixDiskLibDiskType, spaceNeeded uint64) VddkError {
-	space := C.uint64(spaceNeeded)
+// SpaceNeededForClone returns the number of bytes needed to clone srcHandle to a disk of diskType.
+func SpaceNeededForClone(srcHandle VixDiskLibHandle, diskType VixDiskLibDiskType) (uint64, VddkError) {
+	if err := checkLoaded("SpaceNeededForClone"); err != nil {
+		return 0, err
+	}
+	var space C.uint64
 	res := C.VixDiskLib_SpaceNeededForClone(srcHandle.dli, C.VixDiskLibDiskType(diskType), &space)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Get space needed for clone failed. The error code is %d.", res))
+		return 0, newVixError(res, "Get space needed for clone failed", "SpaceNeededForClone", fmt.Sprintf("diskType=%d", diskType))
 	}
-	return nil
+	return uint64(space), nil
 }
 
 func Unlink(connection VixDiskLibConnection, path string) VddkError {
+	if err := checkLoaded("Unlink"); err != nil {
+		return err
+	}
 	delete := C.CString(path)
 	defer C.free(unsafe.Pointer(delete))
 	res := C.VixDiskLib_Unlink(connection.conn, delete)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Delete the virtual disk including all the extents failed. The error code is %d.", res))
+		return newVixError(res, "Delete the virtual disk including all the extents failed", "Unlink", fmt.Sprintf("path=%q", path))
 	}
 	return nil
 }
 
-func Shrink(diskHandle VixDiskLibHandle, progressCallbackData string) VddkError {
-	cstr := C.CString(progressCallbackData)
-	defer C.free(unsafe.Pointer(cstr))
-	res := C.Shrink(diskHandle.dli, unsafe.Pointer(&cstr))
+// Shrink reclaims unused space in the disk, reporting progress to progress (which may be nil).
+// Cancelling ctx aborts the operation at the next progress report.
+func Shrink(ctx context.Context, diskHandle VixDiskLibHandle, progress ProgressFunc) VddkError {
+	if err := checkLoaded("Shrink"); err != nil {
+		return err
+	}
+	progressHandle := newProgressHandle(ctx, progress)
+	defer deleteProgressHandle(progressHandle)
+	res := C.Shrink(diskHandle.dli, C.uintptr_t(progressHandle))
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Shrink failed. The error code is %d.", res))
+		return progressError(ctx, res, "Shrink failed", "Shrink", "")
 	}
 	return nil
 }
 
-func Defragment(diskHandle VixDiskLibHandle, progressCallbackData string) VddkError {
-	cstr := C.CString(progressCallbackData)
-	defer C.free(unsafe.Pointer(cstr))
-	res := C.Defragment(diskHandle.dli, unsafe.Pointer(&cstr))
+// Defragment defragments the disk, reporting progress to progress (which may be nil).
+// Cancelling ctx aborts the operation at the next progress report.
+func Defragment(ctx context.Context, diskHandle VixDiskLibHandle, progress ProgressFunc) VddkError {
+	if err := checkLoaded("Defragment"); err != nil {
+		return err
+	}
+	progressHandle := newProgressHandle(ctx, progress)
+	defer deleteProgressHandle(progressHandle)
+	res := C.Defragment(diskHandle.dli, C.uintptr_t(progressHandle))
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Defragment failed. The error code is %d.", res))
+		return progressError(ctx, res, "Defragment failed", "Defragment", "")
 	}
 	return nil
 }
 
 func GetTransportMode(diskHandle VixDiskLibHandle) string {
+	if !Loaded() {
+		return ""
+	}
 	res := C.VixDiskLib_GetTransportMode(diskHandle.dli)
 	mode := C.GoString(res)
 	return mode
 }
 
-func GetMetadataKeys(diskHandle VixDiskLibHandle, buf []byte, bufLen uint, requireLen uint) VddkError {
-	cbuf := ((*C.char)(unsafe.Pointer(&buf[0])))
-	res := C.GetMetadataKeys(diskHandle.dli, cbuf, C.size_t(bufLen), C.size_t(requireLen))
+// GetMetadataKeys returns the keys of all metadata entries of the disk.
+func GetMetadataKeys(diskHandle VixDiskLibHandle) ([]string, VddkError) {
+	if err := checkLoaded("GetMetadataKeys"); err != nil {
+		return nil, err
+	}
+	var required C.size_t
+	// VDDK reports the size needed for the key list when called without a buffer
+	res := C.GetMetadataKeys(diskHandle.dli, nil, 0, &required)
+	if res != 0 && res != C.VIX_E_BUFFER_TOOSMALL {
+		return nil, newVixError(res, "GetMetadataKeys failed", "GetMetadataKeys", "")
+	}
+	if required == 0 {
+		return []string{}, nil
+	}
+	buf := make([]byte, required)
+	res = C.GetMetadataKeys(diskHandle.dli, (*C.char)(unsafe.Pointer(&buf[0])), required, &required)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("GetMetadataKeys failed. The error code is %d.", res))
+		return nil, newVixError(res, "GetMetadataKeys failed", "GetMetadataKeys", "")
 	}
-	return nil
+	// The keys are NUL terminated strings, with an empty string marking the end of the list
+	keys := []string{}
+	for _, key := range strings.Split(string(buf), "\x00") {
+		if key == "" {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
 }
 
 func Close(diskHandle VixDiskLibHandle) VddkError {
+	if err := checkLoaded("Close"); err != nil {
+		return err
+	}
 	res := C.VixDiskLib_Close(diskHandle.dli)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Close virtual disk failed. The error code is %d.", res))
+		return newVixError(res, "Close virtual disk failed", "Close", "")
 	}
 	return nil
 }
 
 func WriteMetadata(diskHandle VixDiskLibHandle, key string, val string) VddkError {
+	if err := checkLoaded("WriteMetadata"); err != nil {
+		return err
+	}
 	w_key := C.CString(key)
 	defer C.free(unsafe.Pointer(w_key))
 	w_val := C.CString(val)
 	defer C.free(unsafe.Pointer(w_val))
 	res := C.VixDiskLib_WriteMetadata(diskHandle.dli, w_key, w_val)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Write meta data failed. The error code is %d.", res))
+		return newVixError(res, "Write meta data failed", "WriteMetadata", fmt.Sprintf("key=%q", key))
 	}
 	return nil
 }
 
-func ReadMetadata(diskHandle VixDiskLibHandle, key string, buf []byte, bufLen uint, requiredLen uint) VddkError {
+// ReadMetadata returns the value of the metadata entry key.
+func ReadMetadata(diskHandle VixDiskLibHandle, key string) (string, VddkError) {
+	if err := checkLoaded("ReadMetadata"); err != nil {
+		return "", err
+	}
 	readKey := C.CString(key)
 	defer C.free(unsafe.Pointer(readKey))
-	cbuf := ((*C.char)(unsafe.Pointer(&buf[0])))
-	required := C.size_t(requiredLen)
-	res := C.VixDiskLib_ReadMetadata(diskHandle.dli, readKey, cbuf, C.size_t(bufLen), &required)
+	var required C.size_t
+	res := C.VixDiskLib_ReadMetadata(diskHandle.dli, readKey, nil, 0, &required)
+	if res != 0 && res != C.VIX_E_BUFFER_TOOSMALL {
+		return "", newVixError(res, "Read meta data from virtual disk file failed", "ReadMetadata", fmt.Sprintf("key=%q", key))
+	}
+	if required == 0 {
+		return "", nil
+	}
+	buf := make([]byte, required)
+	res = C.VixDiskLib_ReadMetadata(diskHandle.dli, readKey, (*C.char)(unsafe.Pointer(&buf[0])), required, &required)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Read meta data from virtual disk file failed. The error code is %d.", res))
+		return "", newVixError(res, "Read meta data from virtual disk file failed", "ReadMetadata", fmt.Sprintf("key=%q", key))
 	}
-	return nil
+	return strings.SplitN(string(buf), "\x00", 2)[0], nil
 }
 
+// Read reads numSectors sectors at startSector into buf, which must hold at least numSectors sectors.
 func Read(diskHandle VixDiskLibHandle, startSector uint64, numSectors uint64, buf []byte) VddkError {
+	if err := checkLoaded("Read"); err != nil {
+		return err
+	}
+	msg := "Read from virtual disk file failed"
+	args := fmt.Sprintf("startSector=%d, numSectors=%d", startSector, numSectors)
+	if err := checkSectorBuffer(buf, numSectors, msg, "Read", args); err != nil {
+		return err
+	}
 	cbuf := ((*C.uint8)(unsafe.Pointer(&buf[0])))
 	res := C.VixDiskLib_Read(diskHandle.dli, C.VixDiskLibSectorType(startSector), C.VixDiskLibSectorType(numSectors), cbuf)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Read from virtual disk file failed. The error code is %d.", res))
+		return newVixError(res, msg, "Read", args)
 	}
 	return nil
 }
 
+// Write writes numSectors sectors from buf at startSector. buf must hold at least numSectors sectors.
 func Write(diskHandle VixDiskLibHandle, startSector uint64, numSectors uint64, buf []byte) VddkError {
+	if err := checkLoaded("Write"); err != nil {
+		return err
+	}
+	msg := "Write to virtual disk file failed"
+	args := fmt.Sprintf("startSector=%d, numSectors=%d", startSector, numSectors)
+	if err := checkSectorBuffer(buf, numSectors, msg, "Write", args); err != nil {
+		return err
+	}
 	cbuf := ((*C.uint8)(unsafe.Pointer(&buf[0])))
 	res := C.VixDiskLib_Write(diskHandle.dli, C.VixDiskLibSectorType(startSector), C.VixDiskLibSectorType(numSectors), cbuf)
 	if res != 0 {
-		return NewVddkError(uint64(res), fmt.Sprintf("Write to virtual disk file failed. The error code is %d.", res))
+		return newVixError(res, msg, "Write", args)
+	}
+	return nil
+}
+
+// Flush writes any data VDDK has buffered for diskHandle through to the disk.
+func Flush(diskHandle VixDiskLibHandle) VddkError {
+	if err := checkSymbol("VixDiskLib_Flush", "Flush"); err != nil {
+		return err
+	}
+	res := C.VixDiskLib_Flush(diskHandle.dli)
+	if res != 0 {
+		return newVixError(res, "Flush virtual disk failed", "Flush", "")
 	}
 	return nil
 }
 
+// GetInfo returns the geometry, capacity, sector sizes and identity of the disk, and its type if it was opened
+// from a local file. It fails with ErrNotSupported if the sector sizes cannot be determined from the loaded
+// library, see LibraryMajorVersion.
 func GetInfo(diskHandle VixDiskLibHandle) (VixDiskLibInfo, VddkError) {
+	if err := checkLoaded("GetInfo"); err != nil {
+		return VixDiskLibInfo{}, err
+	}
 	var dliInfoPtr *C.VixDiskLibInfo
-	res := C.VixDiskLib_GetInfo(diskHandle.dli, &dliInfoPtr)
+	res := C.GetInfo(diskHandle.dli, &dliInfoPtr)
 	if res != 0 {
-		return VixDiskLibInfo{}, NewVddkError(uint64(res), fmt.Sprintf("GetInfo failed. The error code is %d.", res))
+		return VixDiskLibInfo{}, newVixError(res, "GetInfo failed", "GetInfo", "")
+	}
+	if dliInfoPtr == nil {
+		return VixDiskLibInfo{}, NewVddkError(VIX_E_FAIL, "GetInfo failed. No disk info was returned.")
 	}
-	dliInfo := *dliInfoPtr
+	defer C.VixDiskLib_FreeInfo(dliInfoPtr)
+	// Fields are read through the pointer, libraries before 8.0 allocate the info without the sector sizes
 	retInfo := VixDiskLibInfo{
 		BiosGeo: VixDiskLibGeometry{
-			Cylinders: uint32(dliInfo.biosGeo.cylinders),
-			Heads:     uint32(dliInfo.biosGeo.heads),
-			Sectors:   uint32(dliInfo.biosGeo.sectors),
+			Cylinders: uint32(dliInfoPtr.biosGeo.cylinders),
+			Heads:     uint32(dliInfoPtr.biosGeo.heads),
+			Sectors:   uint32(dliInfoPtr.biosGeo.sectors),
 		},
 		PhysGeo: VixDiskLibGeometry{
-			Cylinders: uint32(dliInfo.physGeo.cylinders),
-			Heads:     uint32(dliInfo.physGeo.heads),
-			Sectors:   uint32(dliInfo.physGeo.sectors),
+			Cylinders: uint32(dliInfoPtr.physGeo.cylinders),
+			Heads:     uint32(dliInfoPtr.physGeo.heads),
+			Sectors:   uint32(dliInfoPtr.physGeo.sectors),
 		},
-		Capacity:           VixDiskLibSectorType(dliInfo.capacity),
-		AdapterType:        VixDiskLibAdapterType(dliInfo.adapterType),
-		NumLinks:           int(dliInfo.numLinks),
-		ParentFileNameHint: C.GoString(dliInfo.parentFileNameHint),
-		Uuid:               C.GoString(dliInfo.uuid),
-	}
-	C.VixDiskLib_FreeInfo(dliInfoPtr)
+		Capacity:           VixDiskLibSectorType(dliInfoPtr.capacity),
+		AdapterType:        VixDiskLibAdapterType(dliInfoPtr.adapterType),
+		NumLinks:           int(dliInfoPtr.numLinks),
+		ParentFileNameHint: C.GoString(dliInfoPtr.parentFileNameHint),
+		Uuid:               C.GoString(dliInfoPtr.uuid),
+		DiskType:           VIXDISKLIB_DISK_UNKNOWN,
+	}
+	// A UUID VDDK reports in an unexpected format is still available in Uuid
+	retInfo.UUID, _ = ParseDiskUUID(retInfo.Uuid)
+	if diskHandle.localPath != "" {
+		retInfo.DiskType = ReadDiskType(diskHandle.localPath)
+	}
+	var logical, physical C.uint32
+	major := LibraryMajorVersion()
+	if !C.GetInfoSectorSizes(dliInfoPtr, C.int(major), &logical, &physical) {
+		msg := fmt.Sprintf("GetInfo failed. VDDK %d did not report the sector size of the disk.", major)
+		if major == 0 {
+			msg = "GetInfo failed. The version of the loaded VDDK library is unknown, so the sector size of the disk cannot be determined."
+		} else if C.VIXDISKLIB_VERSION_MAJOR < 8 {
+			msg = fmt.Sprintf("GetInfo failed. VDDK %d reports the sector size of the disk, but the package was built with the VixDiskLib "+
+				"header of version %d, which does not declare it.", major, C.VIXDISKLIB_VERSION_MAJOR)
+		}
+		return VixDiskLibInfo{}, &vddkErrorImpl{
+			err_code: VIX_E_NOT_SUPPORTED,
+			err_msg:  msg,
+			op:       "GetInfo",
+		}
+	}
+	retInfo.LogicalSectorSize = uint32(logical)
+	retInfo.PhysicalSectorSize = uint32(physical)
 	return retInfo, nil
 }
 
 // QueryAllocatedBlocks invokes the related VDDK function.
 func QueryAllocatedBlocks(diskHandle VixDiskLibHandle, startSector VixDiskLibSectorType, numSectors VixDiskLibSectorType, chunkSize VixDiskLibSectorType) ([]VixDiskLibBlock, VddkError) {
+	if err := checkSymbol("VixDiskLib_QueryAllocatedBlocks", "QueryAllocatedBlocks"); err != nil {
+		return nil, err
+	}
 	ss := C.VixDiskLibSectorType(startSector)
 	ns := C.VixDiskLibSectorType(numSectors)
 	cs := C.VixDiskLibSectorType(chunkSize)
@@ -442,11 +662,18 @@ func QueryAllocatedBlocks(diskHandle VixDiskLibHandle, startSector VixDiskLibSec
 
 	res := C.QueryAllocatedBlocks(diskHandle.dli, ss, ns, cs, &bld)
 	if res != 0 {
-		return nil, NewVddkError(uint64(res), fmt.Sprintf("QueryAllocatedBlocks(%d, %d, %d) error: %d.", startSector, numSectors, chunkSize, res))
+		return nil, newVixError(res, "Query allocated blocks failed", "QueryAllocatedBlocks", fmt.Sprintf("startSector=%d, numSectors=%d, chunkSize=%d", startSector, numSectors, chunkSize))
 	}
 
 	retList := make([]VixDiskLibBlock, bld.numBlocks)
-	C.BlockListCopyAndFree(&bld, (*C.VixDiskLibBlock)(unsafe.Pointer(&retList[0])))
-
+	var blocks *C.VixDiskLibBlock
+	if len(retList) > 0 {
+		blocks = (*C.VixDiskLibBlock)(unsafe.Pointer(&retList[0]))
+	}
+	// An empty list still has to be freed
+	res = C.BlockListCopyAndFree(&bld, blocks)
+	if res != 0 {
+		return nil, newVixError(res, "Free block list failed", "FreeBlockList", "")
+	}
 	return retList, nil
 }
@@ -0,0 +1,178 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+// #include "gvddk_c.h"
+import "C"
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// AsyncOp is the future for an asynchronous read or write started by ReadAsync or WriteAsync.
+// VDDK reads and writes the data through a C buffer owned by the operation, since cgo does not allow C
+// to keep a Go pointer after the call returns; for reads the data is copied into the caller's buffer
+// before the operation is marked done.
+type AsyncOp struct {
+	id   uintptr
+	read bool
+	buf  []byte
+	cbuf unsafe.Pointer
+	desc string
+	op   string
+	args string
+	once sync.Once
+	done chan struct{}
+	err  VddkError
+}
+
+var (
+	asyncMutex  sync.Mutex
+	asyncNextId uintptr
+	asyncOps    = make(map[uintptr]*AsyncOp)
+)
+
+// Done returns a channel that is closed once the operation has completed.
+func (op *AsyncOp) Done() <-chan struct{} {
+	return op.done
+}
+
+// Err returns the result of a completed operation. It returns nil while the operation is in flight.
+func (op *AsyncOp) Err() VddkError {
+	select {
+	case <-op.done:
+		return op.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the operation completes and returns its result. VDDK may only deliver completions
+// while Wait(diskHandle) or further I/O is running on the handle, so callers draining many operations
+// should call the package level Wait first.
+func (op *AsyncOp) Wait() VddkError {
+	<-op.done
+	return op.err
+}
+
+func newAsyncOp(read bool, startSector uint64, numSectors uint64, buf []byte, desc string, op string) (*AsyncOp, VddkError) {
+	args := fmt.Sprintf("startSector=%d, numSectors=%d", startSector, numSectors)
+	if err := checkSectorBuffer(buf, numSectors, desc+" failed", op, args); err != nil {
+		return nil, err
+	}
+	length := numSectors * VIXDISKLIB_SECTOR_SIZE
+	asyncOp := &AsyncOp{
+		read: read,
+		buf:  buf[:length],
+		cbuf: C.malloc(C.size_t(length)),
+		desc: desc,
+		op:   op,
+		args: args,
+		done: make(chan struct{}),
+	}
+	if !read {
+		copy(unsafe.Slice((*byte)(asyncOp.cbuf), length), asyncOp.buf)
+	}
+	asyncMutex.Lock()
+	asyncNextId++
+	asyncOp.id = asyncNextId
+	asyncOps[asyncOp.id] = asyncOp
+	asyncMutex.Unlock()
+	return asyncOp, nil
+}
+
+// complete records the result, copies read data out of the C buffer and releases it. Only the first
+// completion counts, in case VDDK both fails the call and invokes the callback.
+func (op *AsyncOp) complete(res C.VixError) {
+	op.once.Do(func() {
+		asyncMutex.Lock()
+		delete(asyncOps, op.id)
+		asyncMutex.Unlock()
+		if res != 0 {
+			op.err = newVixError(res, op.desc+" failed", op.op, op.args)
+		} else if op.read {
+			copy(op.buf, unsafe.Slice((*byte)(op.cbuf), len(op.buf)))
+		}
+		C.free(op.cbuf)
+		op.cbuf = nil
+		close(op.done)
+	})
+}
+
+// start hands the result of the ReadAsync/WriteAsync shim to the operation. VIX_ASYNC means the
+// operation was queued and completes through the callback; anything else completes it now.
+func (op *AsyncOp) start(res C.VixError) (*AsyncOp, VddkError) {
+	if res == C.VIX_ASYNC {
+		return op, nil
+	}
+	op.complete(res)
+	if op.err != nil {
+		return nil, op.err
+	}
+	return op, nil
+}
+
+//export GoAsyncCompletion
+func GoAsyncCompletion(opId C.uintptr_t, result C.VixError) {
+	asyncMutex.Lock()
+	op := asyncOps[uintptr(opId)]
+	asyncMutex.Unlock()
+	if op != nil {
+		op.complete(result)
+	}
+}
+
+// ReadAsync starts reading numSectors sectors at startSector into buf, which must not be accessed until
+// the returned operation is done.
+func ReadAsync(diskHandle VixDiskLibHandle, startSector uint64, numSectors uint64, buf []byte) (*AsyncOp, VddkError) {
+	if err := checkSymbol("VixDiskLib_ReadAsync", "ReadAsync"); err != nil {
+		return nil, err
+	}
+	op, err := newAsyncOp(true, startSector, numSectors, buf, "Asynchronous read from virtual disk file", "ReadAsync")
+	if err != nil {
+		return nil, err
+	}
+	res := C.ReadAsync(diskHandle.dli, C.VixDiskLibSectorType(startSector), C.VixDiskLibSectorType(numSectors), (*C.uint8)(op.cbuf), C.uintptr_t(op.id))
+	return op.start(res)
+}
+
+// WriteAsync starts writing numSectors sectors from buf at startSector. The data is copied before
+// WriteAsync returns, so buf may be reused immediately.
+func WriteAsync(diskHandle VixDiskLibHandle, startSector uint64, numSectors uint64, buf []byte) (*AsyncOp, VddkError) {
+	if err := checkSymbol("VixDiskLib_WriteAsync", "WriteAsync"); err != nil {
+		return nil, err
+	}
+	op, err := newAsyncOp(false, startSector, numSectors, buf, "Asynchronous write to virtual disk file", "WriteAsync")
+	if err != nil {
+		return nil, err
+	}
+	res := C.WriteAsync(diskHandle.dli, C.VixDiskLibSectorType(startSector), C.VixDiskLibSectorType(numSectors), (*C.uint8)(op.cbuf), C.uintptr_t(op.id))
+	return op.start(res)
+}
+
+// Wait blocks until all asynchronous operations on diskHandle have completed.
+func Wait(diskHandle VixDiskLibHandle) VddkError {
+	if err := checkSymbol("VixDiskLib_Wait", "Wait"); err != nil {
+		return err
+	}
+	res := C.VixDiskLib_Wait(diskHandle.dli)
+	if res != 0 {
+		return newVixError(res, "Wait for asynchronous operations failed", "Wait", "")
+	}
+	return nil
+}
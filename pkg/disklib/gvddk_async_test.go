@@ -0,0 +1,169 @@
+//go:build vddkstub
+
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"unsafe"
+)
+
+// cbuf returns the C buffer VDDK reads into and writes from.
+func cbuf(op *AsyncOp) []byte {
+	return unsafe.Slice((*byte)(op.cbuf), len(op.buf))
+}
+
+// checkAsyncDone fails the test unless op has completed and released its C buffer.
+func checkAsyncDone(t *testing.T, op *AsyncOp) {
+	t.Helper()
+	select {
+	case <-op.Done():
+	default:
+		t.Fatal("operation is not done")
+	}
+	if op.cbuf != nil {
+		t.Error("C buffer of a completed operation was not freed")
+	}
+	asyncMutex.Lock()
+	defer asyncMutex.Unlock()
+	if _, ok := asyncOps[op.id]; ok {
+		t.Error("completed operation is still registered")
+	}
+}
+
+func TestAsyncOpComplete(t *testing.T) {
+	buf := make([]byte, VIXDISKLIB_SECTOR_SIZE)
+	op, vErr := newAsyncOp(true, 0, 1, buf, "Asynchronous read", "ReadAsync")
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	if op.Err() != nil {
+		t.Error("Err of an operation in flight is not nil")
+	}
+	select {
+	case <-op.Done():
+		t.Fatal("operation in flight is done")
+	default:
+	}
+
+	copy(cbuf(op), bytes.Repeat([]byte{0x5A}, VIXDISKLIB_SECTOR_SIZE))
+	op.complete(VIX_OK)
+	checkAsyncDone(t, op)
+	if !bytes.Equal(buf, bytes.Repeat([]byte{0x5A}, VIXDISKLIB_SECTOR_SIZE)) {
+		t.Error("read data was not copied out of the C buffer")
+	}
+	// Only the first completion counts
+	op.complete(VIX_E_FAIL)
+	if vErr := op.Wait(); vErr != nil {
+		t.Errorf("second completion changed the result to %v", vErr)
+	}
+
+	// A failed read leaves the caller's buffer alone
+	buf = make([]byte, VIXDISKLIB_SECTOR_SIZE)
+	op, vErr = newAsyncOp(true, 0, 1, buf, "Asynchronous read", "ReadAsync")
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	copy(cbuf(op), bytes.Repeat([]byte{0x5A}, VIXDISKLIB_SECTOR_SIZE))
+	op.complete(VIX_E_DISK_OUTOFRANGE)
+	checkAsyncDone(t, op)
+	if vErr := op.Wait(); !errors.Is(vErr, ErrOutOfRange) || !errors.Is(op.Err(), ErrOutOfRange) {
+		t.Errorf("Wait returned %v and Err %v, expected ErrOutOfRange", vErr, op.Err())
+	}
+	if !bytes.Equal(buf, make([]byte, VIXDISKLIB_SECTOR_SIZE)) {
+		t.Error("failed read changed the caller's buffer")
+	}
+}
+
+func TestStubAsync(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	dli, _ := openStub(t)
+
+	data := bytes.Repeat([]byte("async"), 2*VIXDISKLIB_SECTOR_SIZE/5+1)[:2*VIXDISKLIB_SECTOR_SIZE]
+	buf := append([]byte(nil), data...)
+	writeOp, vErr := WriteAsync(dli, 10, 2, buf)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	// The data was copied, so the buffer can be reused right away
+	for i := range buf {
+		buf[i] = 0
+	}
+	if vErr := Wait(dli); vErr != nil {
+		t.Fatal(vErr)
+	}
+	if vErr := writeOp.Wait(); vErr != nil {
+		t.Fatal(vErr)
+	}
+	checkAsyncDone(t, writeOp)
+
+	readOp, vErr := ReadAsync(dli, 10, 2, buf)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	if vErr := readOp.Wait(); vErr != nil {
+		t.Fatal(vErr)
+	}
+	checkAsyncDone(t, readOp)
+	if !bytes.Equal(buf, data) {
+		t.Error("asynchronous read returned other data than was written")
+	}
+}
+
+func TestStubAsyncErrors(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	dli, _ := openStub(t)
+	buf := make([]byte, VIXDISKLIB_SECTOR_SIZE)
+
+	// Failing in the completion callback, the error is returned by Wait
+	op, vErr := ReadAsync(dli, stubDiskSectors, 1, buf)
+	if vErr != nil {
+		t.Fatalf("ReadAsync failed before the completion: %v", vErr)
+	}
+	if vErr := op.Wait(); !errors.Is(vErr, ErrOutOfRange) {
+		t.Errorf("Wait returned %v, expected ErrOutOfRange", vErr)
+	}
+	checkAsyncDone(t, op)
+
+	// Failing right away, no operation is returned
+	stubInjectFault("WriteAsync", VIX_E_HOST_CONNECTION_LOST, 0, 1)
+	asyncMutex.Lock()
+	pending := len(asyncOps)
+	asyncMutex.Unlock()
+	if op, vErr := WriteAsync(dli, 0, 1, buf); !errors.Is(vErr, ErrHostConnectionLost) || op != nil {
+		t.Errorf("WriteAsync returned %v, %v, expected no operation and ErrHostConnectionLost", op, vErr)
+	}
+	asyncMutex.Lock()
+	if len(asyncOps) != pending {
+		t.Error("operation failing right away is still registered")
+	}
+	asyncMutex.Unlock()
+
+	if _, vErr := ReadAsync(dli, 0, 2, buf); !errors.Is(vErr, ErrInvalidArg) {
+		t.Errorf("ReadAsync into a short buffer returned %v, expected ErrInvalidArg", vErr)
+	}
+
+	stubInjectFault("Wait", VIX_E_HOST_CONNECTION_LOST, 0, 1)
+	if vErr := Wait(dli); !errors.Is(vErr, ErrHostConnectionLost) {
+		t.Errorf("Wait returned %v, expected ErrHostConnectionLost", vErr)
+	}
+}
@@ -17,27 +17,80 @@ limitations under the License.
 #include "gvddk_c.h"
 #include <string.h>
 
+/*
+ * LogMessage formats a VDDK log message and hands it to Go. Messages that do
+ * not fit in the stack buffer are formatted again into a heap buffer.
+ */
+static void LogMessage(int level, const char *fmt, va_list args)
+{
+    char buf[1024];
+    char *msg = buf;
+    va_list argsCopy;
+    int len;
+
+    va_copy(argsCopy, args);
+    len = vsnprintf(buf, sizeof(buf), fmt, argsCopy);
+    va_end(argsCopy);
+    if (len < 0) {
+        return;
+    }
+    if ((size_t)len >= sizeof(buf)) {
+        char *heapBuf = malloc(len + 1);
+        if (heapBuf != NULL) {
+            vsnprintf(heapBuf, len + 1, fmt, args);
+            msg = heapBuf;
+        }
+    }
+    GoLogMessage(level, msg);
+    if (msg != buf) {
+        free(msg);
+    }
+}
+
 void LogFunc(const char *fmt, va_list args)
 {
-    char *buf = malloc(1024);
-    sprintf(buf, fmt, args);
-    GoLogWarn(buf);
+    LogMessage(GVDDK_LOG_INFO, fmt, args);
+}
+
+void WarnFunc(const char *fmt, va_list args)
+{
+    LogMessage(GVDDK_LOG_WARN, fmt, args);
+}
+
+void PanicFunc(const char *fmt, va_list args)
+{
+    LogMessage(GVDDK_LOG_PANIC, fmt, args);
 }
 
+/*
+ * ProgressFunc is the VixDiskLibProgressFunc passed to all long running
+ * operations. The progress data is a Go handle identifying the caller's
+ * progress function; returning false asks VDDK to abort the operation.
+ */
 bool ProgressFunc(void *progressData, int percentCompleted)
 {
-    return true;
+    return GoProgressCallback((uintptr_t)progressData, percentCompleted);
 }
 
-VixError Init(uint32 major, uint32 minor, char* libDir)
+VixError Init(uint32 major, uint32 minor, char* libDir, bool logToGo)
 {
-    VixError result = VixDiskLib_Init(major, minor, NULL, NULL, NULL, libDir);
+    VixError result;
+    if (logToGo) {
+        result = VixDiskLib_Init(major, minor, &LogFunc, &WarnFunc, &PanicFunc, libDir);
+    } else {
+        result = VixDiskLib_Init(major, minor, NULL, NULL, NULL, libDir);
+    }
     return result;
 }
 
-VixError InitEx(uint32 major, uint32 minor, char* libDir, char* configFile)
+VixError InitEx(uint32 major, uint32 minor, char* libDir, char* configFile, bool logToGo)
 {
-    VixError result = VixDiskLib_InitEx(major, minor, NULL, NULL, NULL, libDir, configFile);
+    VixError result;
+    if (logToGo) {
+        result = VixDiskLib_InitEx(major, minor, &LogFunc, &WarnFunc, &PanicFunc, libDir, configFile);
+    } else {
+        result = VixDiskLib_InitEx(major, minor, NULL, NULL, NULL, libDir, configFile);
+    }
     return result;
 }
 
@@ -91,45 +144,108 @@ void Params_helper(VixDiskLibConnectParams *cnxParams, char* arg1, char* arg2, c
     return;
 }
 
-VixError Create(VixDiskLibConnection connection, char *path, VixDiskLibCreateParams *createParams, void *progressCallbackData)
+/*
+ * AllocateConnectParams allocates connect params with VDDK, or from the heap
+ * with VDDK releases that predate VixDiskLib_AllocateConnectParams.
+ */
+VixDiskLibConnectParams *AllocateConnectParams(void)
+{
+    if (gvddk.AllocateConnectParams == NULL) {
+        return calloc(1, sizeof(VixDiskLibConnectParams));
+    }
+    return VixDiskLib_AllocateConnectParams();
+}
+
+/*
+ * FreeConnectParams frees connect params from AllocateConnectParams. The
+ * strings they point to are owned by Go, so they are detached first rather
+ * than being released by VDDK.
+ */
+void FreeConnectParams(VixDiskLibConnectParams *cnxParams)
+{
+    if (cnxParams == NULL) {
+        return;
+    }
+    if (gvddk.FreeConnectParams == NULL) {
+        free(cnxParams);
+        return;
+    }
+    cnxParams->vmxSpec = NULL;
+    cnxParams->serverName = NULL;
+    cnxParams->thumbPrint = NULL;
+    memset(&cnxParams->creds, 0, sizeof(cnxParams->creds));
+    memset(&cnxParams->spec, 0, sizeof(cnxParams->spec));
+    VixDiskLib_FreeConnectParams(cnxParams);
+}
+
+VixError Create(VixDiskLibConnection connection, char *path, VixDiskLibCreateParams *createParams, uintptr_t progressHandle)
 {
     VixError vixError;
-    vixError = VixDiskLib_Create(connection, path, createParams, (VixDiskLibProgressFunc)&ProgressFunc, progressCallbackData);
+    vixError = VixDiskLib_Create(connection, path, createParams, (VixDiskLibProgressFunc)&ProgressFunc, (void *)progressHandle);
     return vixError;
 }
 
-VixError CreateChild(VixDiskLibHandle diskHandle, char *childPath, VixDiskLibDiskType diskType, void *progressCallbackData)
+VixError CreateChild(VixDiskLibHandle diskHandle, char *childPath, VixDiskLibDiskType diskType, uintptr_t progressHandle)
 {
     VixError vixError;
-    vixError = VixDiskLib_CreateChild(diskHandle, childPath, diskType, (VixDiskLibProgressFunc)&ProgressFunc, progressCallbackData);
+    vixError = VixDiskLib_CreateChild(diskHandle, childPath, diskType, (VixDiskLibProgressFunc)&ProgressFunc, (void *)progressHandle);
     return vixError;
 }
 
-VixError Defragment(VixDiskLibHandle diskHandle, void *progressCallbackData)
+VixError Defragment(VixDiskLibHandle diskHandle, uintptr_t progressHandle)
 {
     VixError vixError;
-    vixError = VixDiskLib_Defragment(diskHandle, (VixDiskLibProgressFunc)&ProgressFunc, progressCallbackData);
+    vixError = VixDiskLib_Defragment(diskHandle, (VixDiskLibProgressFunc)&ProgressFunc, (void *)progressHandle);
     return vixError;
 }
 
-VixError GetInfo(VixDiskLibHandle diskHandle, VixDiskLibInfo *info)
+VixError GetInfo(VixDiskLibHandle diskHandle, VixDiskLibInfo **info)
 {
     VixError error;
-    error = VixDiskLib_GetInfo(diskHandle, &info);
+    *info = NULL;
+    error = VixDiskLib_GetInfo(diskHandle, info);
     return error;
 }
 
-VixError Grow(VixDiskLibConnection connection, char* path, VixDiskLibSectorType capacity, bool updateGeometry, void *progressCallbackData)
+/*
+ * GetInfoSectorSizes returns the sector sizes in info, which a library of
+ * major version libraryMajor allocated. Libraries before 8.0 support neither
+ * 4Kn disks nor the sector size fields, so both sizes are
+ * VIXDISKLIB_SECTOR_SIZE. Later ones append the fields to the info, which can
+ * only be read if the package was built with a header declaring them. false
+ * is returned if the sizes cannot be determined, including for a library of
+ * unknown version (0).
+ */
+bool GetInfoSectorSizes(const VixDiskLibInfo *info, int libraryMajor, uint32 *logical, uint32 *physical)
+{
+    if (libraryMajor > 0 && libraryMajor < 8) {
+        *logical = VIXDISKLIB_SECTOR_SIZE;
+        *physical = VIXDISKLIB_SECTOR_SIZE;
+        return true;
+    }
+#if VIXDISKLIB_VERSION_MAJOR >= 8
+    if (libraryMajor >= 8) {
+        *logical = info->logicalSectorSize;
+        *physical = info->physicalSectorSize;
+        return *logical != 0 && *physical != 0;
+    }
+#else
+    (void)info;
+#endif
+    return false;
+}
+
+VixError Grow(VixDiskLibConnection connection, char* path, VixDiskLibSectorType capacity, bool updateGeometry, uintptr_t progressHandle)
 {
     VixError error;
-    error = VixDiskLib_Grow(connection, path, capacity, updateGeometry, (VixDiskLibProgressFunc)&ProgressFunc, progressCallbackData);
+    error = VixDiskLib_Grow(connection, path, capacity, updateGeometry, (VixDiskLibProgressFunc)&ProgressFunc, (void *)progressHandle);
     return error;
 }
 
-VixError Shrink(VixDiskLibHandle diskHandle, void *progressCallbackData)
+VixError Shrink(VixDiskLibHandle diskHandle, uintptr_t progressHandle)
 {
     VixError error;
-    error = VixDiskLib_Shrink(diskHandle, (VixDiskLibProgressFunc)&ProgressFunc, progressCallbackData);
+    error = VixDiskLib_Shrink(diskHandle, (VixDiskLibProgressFunc)&ProgressFunc, (void *)progressHandle);
     return error;
 }
 
@@ -140,25 +256,25 @@ VixError CheckRepair(VixDiskLibConnection connection, char *file, bool repair)
     return error;
 }
 
-VixError Cleanup(VixDiskLibConnectParams *connectParams, uint32 numCleanedUp, uint32 numRemaining)
+VixError Cleanup(VixDiskLibConnectParams *connectParams, uint32 *numCleanedUp, uint32 *numRemaining)
 {
     VixError error;
-    error = VixDiskLib_Cleanup(connectParams, &numCleanedUp, &numRemaining);
+    error = VixDiskLib_Cleanup(connectParams, numCleanedUp, numRemaining);
     return error;
 }
 
-VixError GetMetadataKeys(VixDiskLibHandle diskHandle, char *buf, size_t bufLen, size_t required)
+VixError GetMetadataKeys(VixDiskLibHandle diskHandle, char *buf, size_t bufLen, size_t *required)
 {
     VixError error;
-    error = VixDiskLib_GetMetadataKeys(diskHandle, buf, bufLen, &required);
+    error = VixDiskLib_GetMetadataKeys(diskHandle, buf, bufLen, required);
     return error;
 }
 
 VixError Clone(VixDiskLibConnection dstConn, char *dstPath, VixDiskLibConnection srcConn, char *srcPath, VixDiskLibCreateParams *createParams,
-               void *progressCallbackData, bool overWrite)
+               uintptr_t progressHandle, bool overWrite)
 {
     VixError error;
-    error = VixDiskLib_Clone(dstConn, dstPath, srcConn, srcPath, createParams, (VixDiskLibProgressFunc)&ProgressFunc, progressCallbackData, overWrite);
+    error = VixDiskLib_Clone(dstConn, dstPath, srcConn, srcPath, createParams, (VixDiskLibProgressFunc)&ProgressFunc, (void *)progressHandle, overWrite);
     return error;
 }
 
@@ -181,7 +297,7 @@ VixError QueryAllocatedBlocks(VixDiskLibHandle diskHandle, VixDiskLibSectorType
     }
 
     bld->blockList = bl;
-    bld->numBlocks = bl->numBlocks;
+    bld->numBlocks = bl == NULL ? 0 : bl->numBlocks;
 
     return VIX_OK;
 }
@@ -194,10 +310,38 @@ VixError BlockListCopyAndFree(BlockListDescriptor *bld,  VixDiskLibBlock *ba)
 {
     VixDiskLibBlockList *bl = bld->blockList;
 
-    for (int i = 0; i < bl->numBlocks; i++) {
+    if (bl == NULL) {
+        return VIX_OK;
+    }
+    for (int i = 0; i < bl->numBlocks && ba != NULL; i++) {
         *ba = *(&bl->blocks[i]);
         ba++;
     }
 
     return VixDiskLib_FreeBlockList(bl);
 }
+
+/*
+ * AsyncCompletion is the VixDiskLibCompletionCB for ReadAsync and WriteAsync.
+ * The callback data is the id of the Go AsyncOp waiting for the result.
+ */
+void AsyncCompletion(void *cbData, VixError result)
+{
+    GoAsyncCompletion((uintptr_t)cbData, result);
+}
+
+/*
+ * ReadAsync and WriteAsync wrap the VixDiskLib methods of the same name. The
+ * buffer must be C memory, since it is used after the call returns.
+ */
+VixError ReadAsync(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector, VixDiskLibSectorType numSectors,
+                   uint8 *buf, uintptr_t opId)
+{
+    return VixDiskLib_ReadAsync(diskHandle, startSector, numSectors, buf, &AsyncCompletion, (void *)opId);
+}
+
+VixError WriteAsync(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector, VixDiskLibSectorType numSectors,
+                    uint8 *buf, uintptr_t opId)
+{
+    return VixDiskLib_WriteAsync(diskHandle, startSector, numSectors, buf, &AsyncCompletion, (void *)opId);
+}
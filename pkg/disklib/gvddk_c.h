@@ -14,9 +14,15 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
+#ifndef GVDDK_C_H
+#define GVDDK_C_H
+
 #include <stdio.h>
+#include <stdarg.h>
 #include <stdbool.h>
-#include "vixDiskLib.h"
+#include <stdint.h>
+#include <stdlib.h>
+#include "gvddk_dl.h"
 
 typedef struct {
     VixDiskLibHandle dli;
@@ -28,27 +34,48 @@ typedef struct {
     void*  blockList; /* opaque to Go */
 } BlockListDescriptor;
 
+/* Levels passed to GoLogMessage, matching disklib.LogLevel */
+enum {
+    GVDDK_LOG_INFO = 0,
+    GVDDK_LOG_WARN = 1,
+    GVDDK_LOG_PANIC = 2,
+};
+
 void LogFunc(const char *fmt, va_list args);
-void GoLogWarn(char * msg);
-VixError Init(uint32 major, uint32 minor, char* libDir);
-VixError InitEx(uint32 major, uint32 minor, char* libDir, char* configFile);
+void WarnFunc(const char *fmt, va_list args);
+void PanicFunc(const char *fmt, va_list args);
+void GoLogMessage(int level, char *msg);
+bool GoProgressCallback(uintptr_t progressHandle, int percentCompleted);
+void GoAsyncCompletion(uintptr_t opId, VixError result);
+VixError Init(uint32 major, uint32 minor, char* libDir, bool logToGo);
+VixError InitEx(uint32 major, uint32 minor, char* libDir, char* configFile, bool logToGo);
 VixError Connect(VixDiskLibConnectParams *cnxParams, VixDiskLibConnection *connection);
 VixError ConnectEx(VixDiskLibConnectParams *cnxParams, bool readOnly, char* transportModes, VixDiskLibConnection *connection);
 DiskHandle Open(VixDiskLibConnection conn, char* path, uint32 flags);
 VixError PrepareForAccess(VixDiskLibConnectParams *cnxParams, char* identity);
+VixDiskLibConnectParams *AllocateConnectParams(void);
+void FreeConnectParams(VixDiskLibConnectParams *cnxParams);
 void Params_helper(VixDiskLibConnectParams *cnxParams, char* arg1, char* arg2, char* arg3, bool isFcd, bool isSession);
-VixError Create(VixDiskLibConnection connection, char *path, VixDiskLibCreateParams *createParams, void *progressCallbackData);
+VixError Create(VixDiskLibConnection connection, char *path, VixDiskLibCreateParams *createParams, uintptr_t progressHandle);
 bool ProgressFunc(void *progressData, int percentCompleted);
-VixError CreateChild(VixDiskLibHandle diskHandle, char *childPath, VixDiskLibDiskType diskType, void *progressCallbackData);
-VixError Defragment(VixDiskLibHandle diskHandle, void *progressCallbackData);
-VixError GetInfo(VixDiskLibHandle diskHandle, VixDiskLibInfo *info);
-VixError Grow(VixDiskLibConnection connection, char* path, VixDiskLibSectorType capacity, bool updateGeometry, void *progressCallbackData);
-VixError Shrink(VixDiskLibHandle diskHandle, void *progressCallbackData);
+VixError CreateChild(VixDiskLibHandle diskHandle, char *childPath, VixDiskLibDiskType diskType, uintptr_t progressHandle);
+VixError Defragment(VixDiskLibHandle diskHandle, uintptr_t progressHandle);
+VixError GetInfo(VixDiskLibHandle diskHandle, VixDiskLibInfo **info);
+bool GetInfoSectorSizes(const VixDiskLibInfo *info, int libraryMajor, uint32 *logical, uint32 *physical);
+VixError Grow(VixDiskLibConnection connection, char* path, VixDiskLibSectorType capacity, bool updateGeometry, uintptr_t progressHandle);
+VixError Shrink(VixDiskLibHandle diskHandle, uintptr_t progressHandle);
 VixError CheckRepair(VixDiskLibConnection connection, char *file, bool repair);
-VixError Cleanup(VixDiskLibConnectParams *connectParams, uint32 numCleanedUp, uint32 numRemaining);
-VixError GetMetadataKeys(VixDiskLibHandle diskHandle, char *buf, size_t bufLen, size_t required);
+VixError Cleanup(VixDiskLibConnectParams *connectParams, uint32 *numCleanedUp, uint32 *numRemaining);
+VixError GetMetadataKeys(VixDiskLibHandle diskHandle, char *buf, size_t bufLen, size_t *required);
 VixError Clone(VixDiskLibConnection dstConn, char *dstPath, VixDiskLibConnection srcConn, char *srcPath, VixDiskLibCreateParams *createParams,
-               void *progressCallbackData, bool overWrite);
+               uintptr_t progressHandle, bool overWrite);
 VixError QueryAllocatedBlocks(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector,
                               VixDiskLibSectorType numSectors, VixDiskLibSectorType chunkSize, BlockListDescriptor *bld);
 VixError BlockListCopyAndFree(BlockListDescriptor *bld, VixDiskLibBlock *ba);
+void AsyncCompletion(void *cbData, VixError result);
+VixError ReadAsync(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector, VixDiskLibSectorType numSectors,
+                   uint8 *buf, uintptr_t opId);
+VixError WriteAsync(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector, VixDiskLibSectorType numSectors,
+                    uint8 *buf, uintptr_t opId);
+
+#endif /* GVDDK_C_H */
@@ -0,0 +1,115 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+#define _GNU_SOURCE
+#include "gvddk_dl.h"
+#include <dlfcn.h>
+#include <link.h>
+#include <stdio.h>
+#include <string.h>
+
+GvddkFunctions gvddk;
+
+static void *gvddkLib;
+
+typedef struct {
+    const char *name;
+    void **fn;
+    bool required;
+} GvddkSymbol;
+
+#define GVDDK_SYMBOL(name, required) { "VixDiskLib_" #name, (void **)&gvddk.name, required },
+static const GvddkSymbol gvddkSymbols[] = {
+    GVDDK_FUNCTIONS(GVDDK_SYMBOL)
+};
+#undef GVDDK_SYMBOL
+
+#define GVDDK_NUM_SYMBOLS (sizeof(gvddkSymbols) / sizeof(gvddkSymbols[0]))
+
+/*
+ * GvddkLoad opens the library at path and resolves the functions listed in
+ * GVDDK_FUNCTIONS. On failure the table is left empty and the reason is
+ * written to errBuf. Callers serialize calls to GvddkLoad and do not call it
+ * again once it succeeded.
+ */
+VixError GvddkLoad(const char *path, char *errBuf, size_t errLen)
+{
+    void *lib;
+    size_t i;
+
+    lib = dlopen(path, RTLD_NOW);
+    if (lib == NULL) {
+        snprintf(errBuf, errLen, "%s", dlerror());
+        return VIX_E_FILE_NOT_FOUND;
+    }
+
+    for (i = 0; i < GVDDK_NUM_SYMBOLS; i++) {
+        *gvddkSymbols[i].fn = dlsym(lib, gvddkSymbols[i].name);
+        if (*gvddkSymbols[i].fn == NULL && gvddkSymbols[i].required) {
+            snprintf(errBuf, errLen, "%s does not provide %s", path, gvddkSymbols[i].name);
+            memset(&gvddk, 0, sizeof(gvddk));
+            dlclose(lib);
+            return VIX_E_NOT_SUPPORTED;
+        }
+    }
+
+    gvddkLib = lib;
+    return VIX_OK;
+}
+
+bool GvddkLoaded(void)
+{
+    return gvddkLib != NULL;
+}
+
+bool GvddkHasSymbol(const char *name)
+{
+    size_t i;
+
+    for (i = 0; i < GVDDK_NUM_SYMBOLS; i++) {
+        if (strcmp(gvddkSymbols[i].name, name) == 0) {
+            return *gvddkSymbols[i].fn != NULL;
+        }
+    }
+    return false;
+}
+
+/*
+ * GvddkLookup looks up a symbol of the loaded library that is not part of the
+ * function table, or returns NULL.
+ */
+void *GvddkLookup(const char *name)
+{
+    if (gvddkLib == NULL) {
+        return NULL;
+    }
+    return dlsym(gvddkLib, name);
+}
+
+/*
+ * GvddkLibraryPath writes the file name the dynamic linker loaded the library
+ * from to buf, which may be a symbolic link, e.g. lib64/libvixDiskLib.so. It
+ * returns false if no library is loaded or the name does not fit.
+ */
+bool GvddkLibraryPath(char *buf, size_t len)
+{
+    struct link_map *map;
+
+    if (gvddkLib == NULL || dlinfo(gvddkLib, RTLD_DI_LINKMAP, &map) != 0 || map->l_name == NULL) {
+        return false;
+    }
+    return (size_t)snprintf(buf, len, "%s", map->l_name) < len;
+}
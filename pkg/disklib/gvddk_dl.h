@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+#ifndef GVDDK_DL_H
+#define GVDDK_DL_H
+
+/*
+ * libvixDiskLib is loaded at runtime with dlopen rather than linked, so that
+ * programs using this package start on hosts without VDDK. Every VixDiskLib
+ * function used by the package is called through the gvddk table, which
+ * GvddkLoad fills in; the defines below redirect the usual names to it.
+ */
+
+#include <stdbool.h>
+#include <stddef.h>
+#include "vixDiskLib.h"
+
+/*
+ * GVDDK_FUNCTIONS lists the functions looked up by GvddkLoad. Loading fails
+ * if a required function is missing; optional ones were added in later VDDK
+ * releases and are left NULL when the library does not provide them.
+ */
+#define GVDDK_FUNCTIONS(X) \
+    X(Init, true) \
+    X(InitEx, true) \
+    X(Exit, true) \
+    X(ListTransportModes, true) \
+    X(GetErrorText, true) \
+    X(FreeErrorText, true) \
+    X(AllocateConnectParams, false) \
+    X(FreeConnectParams, false) \
+    X(Connect, true) \
+    X(ConnectEx, true) \
+    X(Disconnect, true) \
+    X(PrepareForAccess, false) \
+    X(EndAccess, false) \
+    X(Cleanup, true) \
+    X(Open, true) \
+    X(Close, true) \
+    X(GetInfo, true) \
+    X(FreeInfo, true) \
+    X(GetTransportMode, true) \
+    X(Read, true) \
+    X(Write, true) \
+    X(ReadAsync, false) \
+    X(WriteAsync, false) \
+    X(Wait, false) \
+    X(Flush, false) \
+    X(GetMetadataKeys, true) \
+    X(ReadMetadata, true) \
+    X(WriteMetadata, true) \
+    X(Create, true) \
+    X(CreateChild, true) \
+    X(Clone, true) \
+    X(Grow, true) \
+    X(Shrink, true) \
+    X(Defragment, true) \
+    X(Attach, true) \
+    X(CheckRepair, true) \
+    X(Rename, true) \
+    X(Unlink, true) \
+    X(SpaceNeededForClone, true) \
+    X(QueryAllocatedBlocks, false) \
+    X(FreeBlockList, false)
+
+#define GVDDK_FIELD(name, required) __typeof__(VixDiskLib_##name) *name;
+typedef struct {
+    GVDDK_FUNCTIONS(GVDDK_FIELD)
+} GvddkFunctions;
+#undef GVDDK_FIELD
+
+extern GvddkFunctions gvddk;
+
+VixError GvddkLoad(const char *path, char *errBuf, size_t errLen);
+bool GvddkLoaded(void);
+bool GvddkHasSymbol(const char *name);
+void *GvddkLookup(const char *name);
+bool GvddkLibraryPath(char *buf, size_t len);
+
+#define VixDiskLib_Init (*gvddk.Init)
+#define VixDiskLib_InitEx (*gvddk.InitEx)
+#define VixDiskLib_Exit (*gvddk.Exit)
+#define VixDiskLib_ListTransportModes (*gvddk.ListTransportModes)
+#define VixDiskLib_GetErrorText (*gvddk.GetErrorText)
+#define VixDiskLib_FreeErrorText (*gvddk.FreeErrorText)
+#define VixDiskLib_AllocateConnectParams (*gvddk.AllocateConnectParams)
+#define VixDiskLib_FreeConnectParams (*gvddk.FreeConnectParams)
+#define VixDiskLib_Connect (*gvddk.Connect)
+#define VixDiskLib_ConnectEx (*gvddk.ConnectEx)
+#define VixDiskLib_Disconnect (*gvddk.Disconnect)
+#define VixDiskLib_PrepareForAccess (*gvddk.PrepareForAccess)
+#define VixDiskLib_EndAccess (*gvddk.EndAccess)
+#define VixDiskLib_Cleanup (*gvddk.Cleanup)
+#define VixDiskLib_Open (*gvddk.Open)
+#define VixDiskLib_Close (*gvddk.Close)
+#define VixDiskLib_GetInfo (*gvddk.GetInfo)
+#define VixDiskLib_FreeInfo (*gvddk.FreeInfo)
+#define VixDiskLib_GetTransportMode (*gvddk.GetTransportMode)
+#define VixDiskLib_Read (*gvddk.Read)
+#define VixDiskLib_Write (*gvddk.Write)
+#define VixDiskLib_ReadAsync (*gvddk.ReadAsync)
+#define VixDiskLib_WriteAsync (*gvddk.WriteAsync)
+#define VixDiskLib_Wait (*gvddk.Wait)
+#define VixDiskLib_Flush (*gvddk.Flush)
+#define VixDiskLib_GetMetadataKeys (*gvddk.GetMetadataKeys)
+#define VixDiskLib_ReadMetadata (*gvddk.ReadMetadata)
+#define VixDiskLib_WriteMetadata (*gvddk.WriteMetadata)
+#define VixDiskLib_Create (*gvddk.Create)
+#define VixDiskLib_CreateChild (*gvddk.CreateChild)
+#define VixDiskLib_Clone (*gvddk.Clone)
+#define VixDiskLib_Grow (*gvddk.Grow)
+#define VixDiskLib_Shrink (*gvddk.Shrink)
+#define VixDiskLib_Defragment (*gvddk.Defragment)
+#define VixDiskLib_Attach (*gvddk.Attach)
+#define VixDiskLib_CheckRepair (*gvddk.CheckRepair)
+#define VixDiskLib_Rename (*gvddk.Rename)
+#define VixDiskLib_Unlink (*gvddk.Unlink)
+#define VixDiskLib_SpaceNeededForClone (*gvddk.SpaceNeededForClone)
+#define VixDiskLib_QueryAllocatedBlocks (*gvddk.QueryAllocatedBlocks)
+#define VixDiskLib_FreeBlockList (*gvddk.FreeBlockList)
+
+#endif /* GVDDK_DL_H */
@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+// #include "gvddk_c.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes
+const (
+	VIX_OK                            = C.VIX_OK
+	VIX_E_FAIL                        = C.VIX_E_FAIL
+	VIX_E_OUT_OF_MEMORY               = C.VIX_E_OUT_OF_MEMORY
+	VIX_E_INVALID_ARG                 = C.VIX_E_INVALID_ARG
+	VIX_E_FILE_NOT_FOUND              = C.VIX_E_FILE_NOT_FOUND
+	VIX_E_OBJECT_IS_BUSY              = C.VIX_E_OBJECT_IS_BUSY
+	VIX_E_NOT_SUPPORTED               = C.VIX_E_NOT_SUPPORTED
+	VIX_E_FILE_ERROR                  = C.VIX_E_FILE_ERROR
+	VIX_E_DISK_FULL                   = C.VIX_E_DISK_FULL
+	VIX_E_CANCELLED                   = C.VIX_E_CANCELLED
+	VIX_E_FILE_READ_ONLY              = C.VIX_E_FILE_READ_ONLY
+	VIX_E_FILE_ALREADY_EXISTS         = C.VIX_E_FILE_ALREADY_EXISTS
+	VIX_E_FILE_ACCESS_ERROR           = C.VIX_E_FILE_ACCESS_ERROR
+	VIX_E_FILE_ALREADY_LOCKED         = C.VIX_E_FILE_ALREADY_LOCKED
+	VIX_E_BUFFER_TOOSMALL             = C.VIX_E_BUFFER_TOOSMALL
+	VIX_E_OBJECT_NOT_FOUND            = C.VIX_E_OBJECT_NOT_FOUND
+	VIX_E_HOST_NOT_CONNECTED          = C.VIX_E_HOST_NOT_CONNECTED
+	VIX_E_AUTHENTICATION_FAIL         = C.VIX_E_AUTHENTICATION_FAIL
+	VIX_E_HOST_CONNECTION_LOST        = C.VIX_E_HOST_CONNECTION_LOST
+	VIX_E_HOST_USER_PERMISSIONS       = C.VIX_E_HOST_USER_PERMISSIONS
+	VIX_E_DISK_NOIO                   = C.VIX_E_DISK_NOIO
+	VIX_E_DISK_NEEDSREPAIR            = C.VIX_E_DISK_NEEDSREPAIR
+	VIX_E_DISK_NOTSUPPORTED           = C.VIX_E_DISK_NOTSUPPORTED
+	VIX_E_DISK_KEY_NOTFOUND           = C.VIX_E_DISK_KEY_NOTFOUND
+	VIX_E_DISK_INVALID_CONNECTION     = C.VIX_E_DISK_INVALID_CONNECTION
+	VIX_E_NET_HTTP_COULDNT_CONNECT    = C.VIX_E_NET_HTTP_COULDNT_CONNECT
+	VIX_E_NET_HTTP_OPERATION_TIMEDOUT = C.VIX_E_NET_HTTP_OPERATION_TIMEDOUT
+	VIX_E_NET_HTTP_TRANSFER           = C.VIX_E_NET_HTTP_TRANSFER
+)
+
+// Sentinel errors for common VIX error codes. Errors returned by this package match them with errors.Is,
+// e.g. errors.Is(err, disklib.ErrFileNotFound).
+var (
+	ErrFailed             = newSentinel(VIX_E_FAIL, "Unknown error")
+	ErrInvalidArg         = newSentinel(VIX_E_INVALID_ARG, "Invalid argument")
+	ErrFileNotFound       = newSentinel(VIX_E_FILE_NOT_FOUND, "File not found")
+	ErrObjectBusy         = newSentinel(VIX_E_OBJECT_IS_BUSY, "Object is busy")
+	ErrNotSupported       = newSentinel(VIX_E_NOT_SUPPORTED, "Operation not supported")
+	ErrDiskFull           = newSentinel(VIX_E_DISK_FULL, "Disk full")
+	ErrCancelled          = newSentinel(VIX_E_CANCELLED, "Operation cancelled")
+	ErrReadOnly           = newSentinel(VIX_E_FILE_READ_ONLY, "File is read only")
+	ErrAlreadyExists      = newSentinel(VIX_E_FILE_ALREADY_EXISTS, "File already exists")
+	ErrAccessDenied       = newSentinel(VIX_E_FILE_ACCESS_ERROR, "Access denied")
+	ErrFileLocked         = newSentinel(VIX_E_FILE_ALREADY_LOCKED, "File is locked")
+	ErrBufferTooSmall     = newSentinel(VIX_E_BUFFER_TOOSMALL, "Buffer too small")
+	ErrObjectNotFound     = newSentinel(VIX_E_OBJECT_NOT_FOUND, "Object not found")
+	ErrHostNotConnected   = newSentinel(VIX_E_HOST_NOT_CONNECTED, "Host not connected")
+	ErrAuthentication     = newSentinel(VIX_E_AUTHENTICATION_FAIL, "Authentication failed")
+	ErrHostConnectionLost = newSentinel(VIX_E_HOST_CONNECTION_LOST, "Host connection lost")
+	ErrPermissionDenied   = newSentinel(VIX_E_HOST_USER_PERMISSIONS, "Insufficient permissions on host")
+	ErrOutOfRange         = newSentinel(VIX_E_DISK_OUTOFRANGE, "Sector out of range")
+	ErrDiskNeedsRepair    = newSentinel(VIX_E_DISK_NEEDSREPAIR, "Disk needs repair")
+	ErrKeyNotFound        = newSentinel(VIX_E_DISK_KEY_NOTFOUND, "Metadata key not found")
+	ErrInvalidConnection  = newSentinel(VIX_E_DISK_INVALID_CONNECTION, "Invalid connection")
+	ErrTimeout            = newSentinel(VIX_E_NET_HTTP_OPERATION_TIMEDOUT, "Operation timed out")
+)
+
+// newSentinel returns a sentinel error, which errors returned by this package with the same VIX error code
+// match.
+func newSentinel(errCode uint64, errMsg string) VddkError {
+	return &vddkErrorImpl{
+		err_code: errCode,
+		err_msg:  errMsg,
+		sentinel: true,
+	}
+}
+
+// transientErrors lists the codes of failures that may succeed when retried, possibly after reconnecting. A
+// locked file is not among them, the lock usually belongs to a running VM or another backup that holds it for
+// longer than any retry waits.
+var transientErrors = map[uint64]bool{
+	VIX_E_OBJECT_IS_BUSY:              true,
+	VIX_E_HOST_NOT_CONNECTED:          true,
+	VIX_E_HOST_CONNECTION_LOST:        true,
+	VIX_E_DISK_NOIO:                   true,
+	VIX_E_DISK_INVALID_CONNECTION:     true,
+	VIX_E_NET_HTTP_COULDNT_CONNECT:    true,
+	VIX_E_NET_HTTP_OPERATION_TIMEDOUT: true,
+	VIX_E_NET_HTTP_TRANSFER:           true,
+}
+
+// vixCode strips the VIX error to its code, dropping the flags VDDK keeps in the upper bits.
+func vixCode(errCode uint64) uint64 {
+	return errCode & 0xFFFF
+}
+
+// IsTransient reports whether err is a VddkError for a failure that is worth retrying, such as a lost
+// host connection or an expired session. Other errors, e.g. a missing file, are permanent.
+func IsTransient(err error) bool {
+	return transientErrors[ErrorCode(err)]
+}
+
+// ErrorCode returns the VIX error code of the first VddkError in the chain of err, without the flags VDDK
+// keeps in the upper bits, or VIX_OK if there is none.
+func ErrorCode(err error) uint64 {
+	var vErr VddkError
+	if !errors.As(err, &vErr) {
+		return VIX_OK
+	}
+	return vixCode(vErr.VixErrorCode())
+}
+
+// GetErrorText returns VDDK's description of errCode.
+func GetErrorText(errCode uint64) string {
+	if !Loaded() {
+		return ""
+	}
+	text := C.VixDiskLib_GetErrorText(C.VixError(errCode), nil)
+	if text == nil {
+		return ""
+	}
+	defer C.VixDiskLib_FreeErrorText(text)
+	return C.GoString(text)
+}
+
+// newVixError builds the error for a failed VixDiskLib call. msg describes the failure, e.g. "Open
+// virtual disk file failed"; op names the VixDiskLib function and args lists its arguments for the error
+// text, which must never include credentials.
+func newVixError(res C.VixError, msg string, op string, args string) VddkError {
+	return &vddkErrorImpl{
+		err_code: uint64(res),
+		err_msg:  fmt.Sprintf("%s. The error code is %d.", msg, res),
+		op:       op,
+		args:     args,
+		text:     GetErrorText(uint64(res)),
+		vix:      true,
+	}
+}
+
+// checkSectorBuffer makes sure buf can hold numSectors sectors before its address is handed to VDDK, which
+// would otherwise read or write past the end of the slice.
+func checkSectorBuffer(buf []byte, numSectors uint64, msg string, op string, args string) VddkError {
+	if numSectors == 0 || numSectors > uint64(len(buf))/VIXDISKLIB_SECTOR_SIZE {
+		return &vddkErrorImpl{
+			err_code: VIX_E_INVALID_ARG,
+			err_msg:  fmt.Sprintf("%s. Buffer of %d bytes cannot hold %d sectors.", msg, len(buf), numSectors),
+			op:       op,
+			args:     args,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// vixFlags stands for the extra information VDDK keeps above the code in the upper bits of a VixError.
+const vixFlags = 0x5 << 32
+
+func TestErrorsIs(t *testing.T) {
+	fromVddk := &vddkErrorImpl{err_code: VIX_E_HOST_CONNECTION_LOST | vixFlags, vix: true}
+	tests := []struct {
+		name   string
+		err    error
+		target error
+		is     bool
+	}{
+		{"vddk error with flags", fromVddk, ErrHostConnectionLost, true},
+		{"wrapped vddk error", fmt.Errorf("read: %w", fromVddk), ErrHostConnectionLost, true},
+		{"other sentinel", fromVddk, ErrFileNotFound, false},
+		{"NewVddkError", NewVddkError(VIX_E_FILE_NOT_FOUND, "gone"), ErrFileNotFound, true},
+		{"sentinel itself", ErrFailed, ErrFailed, true},
+		{"same code, not a sentinel", NewVddkError(VIX_E_FAIL, "a"), NewVddkError(VIX_E_FAIL, "b"), false},
+		{"sentinel against error of same code", ErrFailed, NewVddkError(VIX_E_FAIL, "b"), false},
+		{"code beyond the VIX range", NewVddkError(VIX_E_FAIL|vixFlags, "not from VDDK"), ErrFailed, false},
+		{"plain error", errors.New("failed"), ErrFailed, false},
+	}
+	for _, test := range tests {
+		if is := errors.Is(test.err, test.target); is != test.is {
+			t.Errorf("%s: errors.Is returned %v, expected %v", test.name, is, test.is)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{ErrHostConnectionLost, true},
+		{&vddkErrorImpl{err_code: VIX_E_NET_HTTP_TRANSFER | vixFlags, vix: true}, true},
+		{fmt.Errorf("read: %w", NewVddkError(VIX_E_OBJECT_IS_BUSY, "busy")), true},
+		{ErrFileNotFound, false},
+		{NewVddkError(VIX_E_FILE_ALREADY_LOCKED, "locked"), false},
+		{NewVddkError(VIX_E_FAIL, "failed"), false},
+		{errors.New("failed"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if transient := IsTransient(test.err); transient != test.transient {
+			t.Errorf("IsTransient(%v) returned %v, expected %v", test.err, transient, test.transient)
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	if code := ErrorCode(fmt.Errorf("open: %w", &vddkErrorImpl{err_code: VIX_E_FILE_NOT_FOUND | vixFlags, vix: true})); code != VIX_E_FILE_NOT_FOUND {
+		t.Errorf("ErrorCode returned %d, expected %d", code, VIX_E_FILE_NOT_FOUND)
+	}
+	if code := ErrorCode(errors.New("failed")); code != VIX_OK {
+		t.Errorf("ErrorCode of a plain error returned %d", code)
+	}
+}
+
+func TestErrorUnwrap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := progressError(ctx, VIX_E_CANCELLED, "Create virtual disk failed", "Create", "")
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrCancelled) {
+		t.Errorf("%v does not match both context.Canceled and ErrCancelled", err)
+	}
+	if errors.Unwrap(err) != context.Canceled {
+		t.Errorf("Unwrap returned %v", errors.Unwrap(err))
+	}
+	if errors.Unwrap(NewVddkError(VIX_E_FAIL, "failed")) != nil {
+		t.Error("an error without cause unwraps to non-nil")
+	}
+}
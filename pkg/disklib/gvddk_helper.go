@@ -19,9 +19,15 @@ package disklib
 // #include "gvddk_c.h"
 import "C"
 import (
+	"bytes"
 	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"net/url"
+	"os"
+	"strings"
 )
 import "crypto/sha1"
 
@@ -99,7 +105,8 @@ type ConnectParams struct {
 }
 
 type VixDiskLibHandle struct {
-	dli C.VixDiskLibHandle
+	dli       C.VixDiskLibHandle
+	localPath string // the disk file if it was opened without a server, for GetInfo to read its type
 }
 
 type VixDiskLibConnection struct {
@@ -114,6 +121,12 @@ type VddkError interface {
 type vddkErrorImpl struct {
 	err_code uint64
 	err_msg  string
+	op       string // VixDiskLib function that failed, if known
+	args     string // arguments of the failed call, for the error text
+	text     string // VixDiskLib_GetErrorText description of err_code
+	cause    error  // underlying error, e.g. the context error for a cancelled operation
+	vix      bool   // err_code was returned by VDDK and may carry flags in the upper bits
+	sentinel bool   // one of the exported sentinel errors, which errors.Is matches by code
 }
 
 type VixDiskLibCreateParams struct {
@@ -154,23 +167,161 @@ type VixDiskLibGeometry struct {
 }
 
 type VixDiskLibInfo struct {
-	BiosGeo            VixDiskLibGeometry
-	PhysGeo            VixDiskLibGeometry
-	Capacity           VixDiskLibSectorType
-	AdapterType        VixDiskLibAdapterType
-	NumLinks           int
+	BiosGeo     VixDiskLibGeometry
+	PhysGeo     VixDiskLibGeometry
+	Capacity    VixDiskLibSectorType // in VIXDISKLIB_SECTOR_SIZE sectors, whatever the disk's sector size
+	AdapterType VixDiskLibAdapterType
+	// NumLinks is the number of links in the opened chain, i.e. the base disk plus its child disks. A disk
+	// opened with VIXDISKLIB_FLAG_OPEN_SINGLE_LINK always reports 1, even if it has a parent.
+	NumLinks int
+	// ParentFileNameHint is the parent of the opened link as recorded in its descriptor, or "" for a base
+	// disk. The path is only a hint and may be stale if the parent was moved.
 	ParentFileNameHint string
-	Uuid               string
+	Uuid               string   // as reported by VDDK, e.g. "60 00 c2 9b 69 2f 04 25-b1 ae 0d 24 3b 9c 41 e7"
+	UUID               DiskUUID // Uuid parsed, zero if the disk has none
+	// DiskType is read from the descriptor of a disk opened from a local file, see ReadDiskType. VDDK does
+	// not report the type, so it is VIXDISKLIB_DISK_UNKNOWN for disks opened through a server.
+	DiskType VixDiskLibDiskType
+	// LogicalSectorSize is the granularity in bytes reads and writes must be aligned to; 4096 for 4Kn
+	// disks. PhysicalSectorSize is the sector size of the backing storage. VDDK reports them from 8.0 on;
+	// with earlier releases, which do not support 4Kn disks, both are 512.
+	LogicalSectorSize  uint32
+	PhysicalSectorSize uint32
 }
 
-func (this vddkErrorImpl) Error() string {
-	return this.err_msg
+// HasParent reports whether the opened link is a child disk.
+func (this VixDiskLibInfo) HasParent() bool {
+	return this.ParentFileNameHint != ""
 }
 
-func (this vddkErrorImpl) VixErrorCode() uint64 {
+// DiskUUID is the 16 byte UUID of a virtual disk.
+type DiskUUID [16]byte
+
+// ParseDiskUUID parses a disk UUID in either the VDDK format, 16 space separated hex bytes with a dash after
+// the eighth, or the usual 8-4-4-4-12 format.
+func ParseDiskUUID(s string) (DiskUUID, error) {
+	var uuid DiskUUID
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(s)
+	if len(digits) != 2*len(uuid) {
+		return DiskUUID{}, fmt.Errorf("invalid disk UUID %q", s)
+	}
+	if _, err := hex.Decode(uuid[:], []byte(digits)); err != nil {
+		return DiskUUID{}, fmt.Errorf("invalid disk UUID %q: %v", s, err)
+	}
+	return uuid, nil
+}
+
+// String formats the UUID as 8-4-4-4-12 lower case hex digits.
+func (this DiskUUID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", this[0:4], this[4:6], this[6:8], this[8:10], this[10:16])
+}
+
+// IsZero reports whether the UUID is unset.
+func (this DiskUUID) IsZero() bool {
+	return this == DiskUUID{}
+}
+
+// createTypes maps the createType of a VMDK descriptor to the disk type.
+var createTypes = map[string]VixDiskLibDiskType{
+	"monolithicSparse":     VIXDISKLIB_DISK_MONOLITHIC_SPARSE,
+	"monolithicFlat":       VIXDISKLIB_DISK_MONOLITHIC_FLAT,
+	"twoGbMaxExtentSparse": VIXDISKLIB_DISK_SPLIT_SPARSE,
+	"twoGbMaxExtentFlat":   VIXDISKLIB_DISK_SPLIT_FLAT,
+	"vmfs":                 VIXDISKLIB_DISK_VMFS_FLAT,
+	"streamOptimized":      VIXDISKLIB_DISK_STREAM_OPTIMIZED,
+	"vmfsThin":             VIXDISKLIB_DISK_VMFS_THIN,
+	"vmfsSparse":           VIXDISKLIB_DISK_VMFS_SPARSE,
+}
+
+// maxDescriptorSize bounds the descriptor ReadDiskType reads, real ones take a few hundred bytes.
+const maxDescriptorSize = 64 * 1024
+
+// ReadDiskType returns the type of the VMDK at path, from the createType of its descriptor. The descriptor is
+// either the file itself or embedded in a sparse extent, as in monolithic sparse and stream optimized disks.
+// It returns VIXDISKLIB_DISK_UNKNOWN if the file cannot be read or has no descriptor, e.g. a raw image.
+func ReadDiskType(path string) VixDiskLibDiskType {
+	file, err := os.Open(path)
+	if err != nil {
+		return VIXDISKLIB_DISK_UNKNOWN
+	}
+	defer file.Close()
+	header := make([]byte, VIXDISKLIB_SECTOR_SIZE)
+	n, _ := io.ReadFull(file, header)
+	offset, size := int64(0), int64(maxDescriptorSize)
+	// The header of a sparse extent gives the descriptor's offset and size in sectors
+	if n >= 44 && string(header[:4]) == "KDMV" {
+		offset = int64(binary.LittleEndian.Uint64(header[28:])) * VIXDISKLIB_SECTOR_SIZE
+		size = int64(binary.LittleEndian.Uint64(header[36:])) * VIXDISKLIB_SECTOR_SIZE
+		if offset <= 0 || size <= 0 || size > maxDescriptorSize {
+			return VIXDISKLIB_DISK_UNKNOWN
+		}
+	}
+	descriptor := make([]byte, size)
+	n, _ = file.ReadAt(descriptor, offset)
+	return parseCreateType(descriptor[:n])
+}
+
+// parseCreateType returns the disk type named by the createType entry of a VMDK descriptor.
+func parseCreateType(descriptor []byte) VixDiskLibDiskType {
+	if !bytes.HasPrefix(descriptor, []byte("# Disk DescriptorFile")) {
+		return VIXDISKLIB_DISK_UNKNOWN
+	}
+	for _, line := range strings.Split(string(descriptor), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) != "createType" {
+			continue
+		}
+		if diskType, ok := createTypes[strings.Trim(strings.TrimSpace(value), `"`)]; ok {
+			return diskType
+		}
+		break
+	}
+	return VIXDISKLIB_DISK_UNKNOWN
+}
+
+func (this *vddkErrorImpl) Error() string {
+	msg := this.err_msg
+	if this.text != "" {
+		msg = msg + " " + this.text + "."
+	}
+	if this.op != "" {
+		msg = fmt.Sprintf("VixDiskLib_%s(%s): %s", this.op, this.args, msg)
+	}
+	return msg
+}
+
+func (this *vddkErrorImpl) VixErrorCode() uint64 {
 	return this.err_code
 }
 
+// Op returns the name of the VixDiskLib function that failed, or "" if unknown.
+func (this *vddkErrorImpl) Op() string {
+	return this.op
+}
+
+// ErrorText returns VDDK's description of the error code, or "" if unknown.
+func (this *vddkErrorImpl) ErrorText() string {
+	return this.text
+}
+
+// Is reports whether target is the sentinel error of the same VIX error code, e.g. ErrFileNotFound, so that
+// errors.Is matches the sentinels regardless of message. Any other target only matches itself.
+func (this *vddkErrorImpl) Is(target error) bool {
+	sentinel, ok := target.(*vddkErrorImpl)
+	if !ok || !sentinel.sentinel {
+		return false
+	}
+	code := this.err_code
+	if this.vix {
+		code = vixCode(code)
+	}
+	return code == sentinel.err_code
+}
+
+func (this *vddkErrorImpl) Unwrap() error {
+	return this.cause
+}
+
 func NewConnectParams(vmxSpec string, serverName string, thumbPrint string, userName string, password string,
 	fcdId string, ds string, fcdssId string, cookie string, identity string, path string, flag uint32, readOnly bool, mode string) ConnectParams {
 	params := ConnectParams{
@@ -193,7 +344,7 @@ func NewConnectParams(vmxSpec string, serverName string, thumbPrint string, user
 }
 
 func NewVddkError(err_code uint64, err_msg string) VddkError {
-	vddkError := vddkErrorImpl{
+	vddkError := &vddkErrorImpl{
 		err_code: err_code,
 		err_msg:  err_msg,
 	}
@@ -0,0 +1,73 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadDiskType(t *testing.T) {
+	dir := t.TempDir()
+	descriptor := func(createType string) []byte {
+		return []byte("# Disk DescriptorFile\nversion=1\nCID=fffffffe\nparentCID=ffffffff\ncreateType=\"" + createType + "\"\n")
+	}
+	// A sparse extent with the descriptor embedded at sector 1
+	sparse := make([]byte, 3*VIXDISKLIB_SECTOR_SIZE)
+	copy(sparse, "KDMV")
+	binary.LittleEndian.PutUint64(sparse[28:], 1)
+	binary.LittleEndian.PutUint64(sparse[36:], 2)
+	copy(sparse[VIXDISKLIB_SECTOR_SIZE:], descriptor("streamOptimized"))
+	// A sparse extent whose descriptor lies past the end of the file
+	truncated := make([]byte, VIXDISKLIB_SECTOR_SIZE)
+	copy(truncated, sparse)
+	binary.LittleEndian.PutUint64(truncated[28:], 8)
+
+	tests := []struct {
+		name     string
+		content  []byte
+		diskType VixDiskLibDiskType
+	}{
+		{"monolithicSparse", descriptor("monolithicSparse"), VIXDISKLIB_DISK_MONOLITHIC_SPARSE},
+		{"monolithicFlat", descriptor("monolithicFlat"), VIXDISKLIB_DISK_MONOLITHIC_FLAT},
+		{"twoGbMaxExtentSparse", descriptor("twoGbMaxExtentSparse"), VIXDISKLIB_DISK_SPLIT_SPARSE},
+		{"twoGbMaxExtentFlat", descriptor("twoGbMaxExtentFlat"), VIXDISKLIB_DISK_SPLIT_FLAT},
+		{"vmfs", descriptor("vmfs"), VIXDISKLIB_DISK_VMFS_FLAT},
+		{"vmfsThin", descriptor("vmfsThin"), VIXDISKLIB_DISK_VMFS_THIN},
+		{"vmfsSparse", descriptor("vmfsSparse"), VIXDISKLIB_DISK_VMFS_SPARSE},
+		{"unknown createType", descriptor("seSparse"), VIXDISKLIB_DISK_UNKNOWN},
+		{"no createType", []byte("# Disk DescriptorFile\nversion=1\n"), VIXDISKLIB_DISK_UNKNOWN},
+		{"embedded", sparse, VIXDISKLIB_DISK_STREAM_OPTIMIZED},
+		{"truncated", truncated, VIXDISKLIB_DISK_UNKNOWN},
+		{"raw", make([]byte, 4*VIXDISKLIB_SECTOR_SIZE), VIXDISKLIB_DISK_UNKNOWN},
+		{"empty", nil, VIXDISKLIB_DISK_UNKNOWN},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name+".vmdk")
+		if err := os.WriteFile(path, test.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if diskType := ReadDiskType(path); diskType != test.diskType {
+			t.Errorf("ReadDiskType on %s returned %d, expected %d", test.name, diskType, test.diskType)
+		}
+	}
+	if diskType := ReadDiskType(filepath.Join(dir, "missing.vmdk")); diskType != VIXDISKLIB_DISK_UNKNOWN {
+		t.Errorf("ReadDiskType on a missing file returned %d, expected %d", diskType, VIXDISKLIB_DISK_UNKNOWN)
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+// #include "gvddk_c.h"
+import "C"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// DefaultLibDir is the VDDK installation directory Load falls back to.
+const DefaultLibDir = "/usr/local/vmware-vix-disklib-distrib"
+
+const libName = "libvixDiskLib.so"
+
+var (
+	loadMutex   sync.Mutex
+	loadedPath  string
+	loadedMajor int // major version of the loaded library, 0 if unknown
+)
+
+// Load opens libvixDiskLib at runtime. path is either the library itself or a VDDK installation directory,
+// in which case lib64/libvixDiskLib.so below it is used. With an empty path the dynamic linker's search path
+// is tried first, then DefaultLibDir. The libraries VDDK depends on are resolved by the dynamic linker, so
+// LD_LIBRARY_PATH may still need to include the VDDK lib64 directory.
+//
+// Init and InitEx call Load with their directory if no library has been loaded yet, so calling Load is only
+// needed to pick a different library or to check for VDDK up front. A library cannot be unloaded; loading a
+// second, different one fails.
+func Load(path string) VddkError {
+	loadMutex.Lock()
+	defer loadMutex.Unlock()
+	candidates := libraryCandidates(path)
+	if loadedPath != "" {
+		for _, candidate := range candidates {
+			if candidate == loadedPath {
+				return nil
+			}
+		}
+		return NewVddkError(VIX_E_OBJECT_IS_BUSY, fmt.Sprintf("Load %s failed. %s is already loaded.", path, loadedPath))
+	}
+
+	errBuf := (*C.char)(C.malloc(1024))
+	defer C.free(unsafe.Pointer(errBuf))
+	var failures []string
+	for _, candidate := range candidates {
+		libPath := C.CString(candidate)
+		res := C.GvddkLoad(libPath, errBuf, 1024)
+		C.free(unsafe.Pointer(libPath))
+		if res == 0 {
+			loadedPath = candidate
+			loadedMajor = libraryFileVersion(loadedFile(candidate))
+			return nil
+		}
+		if res != C.VIX_E_FILE_NOT_FOUND {
+			// The library was found but is unusable, trying other locations would hide that
+			return NewVddkError(uint64(res), fmt.Sprintf("Load VDDK library failed. %s.", C.GoString(errBuf)))
+		}
+		failures = append(failures, C.GoString(errBuf))
+	}
+	return NewVddkError(VIX_E_FILE_NOT_FOUND, fmt.Sprintf("Load VDDK library failed. %s.", strings.Join(failures, "; ")))
+}
+
+// libraryCandidates lists the files Load tries for path, in order.
+func libraryCandidates(path string) []string {
+	if path == "" {
+		return []string{libName, filepath.Join(DefaultLibDir, "lib64", libName)}
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return []string{filepath.Join(path, "lib64", libName), filepath.Join(path, libName)}
+	}
+	return []string{path}
+}
+
+// loadedFile returns the file the dynamic linker loaded the library from, or path if it cannot tell.
+func loadedFile(path string) string {
+	buf := (*C.char)(C.malloc(4096))
+	defer C.free(unsafe.Pointer(buf))
+	if !C.GvddkLibraryPath(buf, 4096) {
+		return path
+	}
+	return C.GoString(buf)
+}
+
+// libraryFileVersion returns the major version in the name of the VDDK library file at path, following
+// symbolic links, e.g. 8 for lib64/libvixDiskLib.so linking to libvixDiskLib.so.8.0.3. It returns 0 if
+// neither name carries a version.
+func libraryFileVersion(path string) int {
+	names := []string{path}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		names = append([]string{resolved}, names...)
+	}
+	for _, name := range names {
+		version := strings.TrimPrefix(filepath.Base(name), libName+".")
+		if version == filepath.Base(name) {
+			continue
+		}
+		if major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0]); err == nil && major > 0 {
+			return major
+		}
+	}
+	return 0
+}
+
+// LibraryMajorVersion returns the major version of the loaded VDDK library, as given by its file name, or 0
+// if no library is loaded or its version is unknown. GetInfo needs it to read the sector sizes, which VDDK
+// reports from 8.0 on.
+func LibraryMajorVersion() int {
+	loadMutex.Lock()
+	defer loadMutex.Unlock()
+	return loadedMajor
+}
+
+// Loaded reports whether a VDDK library has been loaded.
+func Loaded() bool {
+	return bool(C.GvddkLoaded())
+}
+
+// HasSymbol reports whether the loaded VDDK library provides the function name, e.g.
+// "VixDiskLib_QueryAllocatedBlocks". Functions added in later VDDK releases are optional; calling a wrapper
+// whose function is missing returns an error matching ErrNotSupported.
+func HasSymbol(name string) bool {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	return bool(C.GvddkHasSymbol(cName))
+}
+
+// checkSymbol returns an error when the VDDK function name cannot be called, either because no library is
+// loaded or because the loaded one lacks it.
+func checkSymbol(name string, op string) VddkError {
+	if HasSymbol(name) {
+		return nil
+	}
+	if err := checkLoaded(op); err != nil {
+		return err
+	}
+	return &vddkErrorImpl{
+		err_code: VIX_E_NOT_SUPPORTED,
+		err_msg:  fmt.Sprintf("%s failed. The loaded VDDK library does not provide %s.", op, name),
+		op:       op,
+	}
+}
+
+// checkLoaded returns an error matching ErrNotSupported when no VDDK library is loaded, instead of calling
+// through the empty function table.
+func checkLoaded(op string) VddkError {
+	if Loaded() {
+		return nil
+	}
+	return &vddkErrorImpl{
+		err_code: VIX_E_NOT_SUPPORTED,
+		err_msg:  fmt.Sprintf("%s failed. The VDDK library is not loaded, call Load or Init first.", op),
+		op:       op,
+	}
+}
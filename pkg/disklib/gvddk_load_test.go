@@ -0,0 +1,161 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLibraryCandidates(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "libvixDiskLib.so.8")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path       string
+		candidates []string
+	}{
+		{"", []string{libName, filepath.Join(DefaultLibDir, "lib64", libName)}},
+		{dir, []string{filepath.Join(dir, "lib64", libName), filepath.Join(dir, libName)}},
+		{file, []string{file}},
+		{filepath.Join(dir, "missing"), []string{filepath.Join(dir, "missing")}},
+	}
+	for _, test := range tests {
+		if candidates := libraryCandidates(test.path); !reflect.DeepEqual(candidates, test.candidates) {
+			t.Errorf("libraryCandidates(%q) returned %q, expected %q", test.path, candidates, test.candidates)
+		}
+	}
+}
+
+func TestLibraryFileVersion(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "libvixDiskLib.so.8.0.3")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, libName)
+	if err := os.Symlink(filepath.Base(file), link); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path  string
+		major int
+	}{
+		{file, 8},
+		{link, 8},
+		{"/opt/vddk/lib64/libvixDiskLib.so.7", 7},
+		{"/opt/vddk/lib64/libvixDiskLib.so", 0},
+		{"/opt/vddk/lib64/libvixDiskLib.so.x", 0},
+		{"/opt/stub/libvixDiskLibStub.so.8", 0},
+	}
+	for _, test := range tests {
+		if major := libraryFileVersion(test.path); major != test.major {
+			t.Errorf("libraryFileVersion(%q) returned %d, expected %d", test.path, major, test.major)
+		}
+	}
+}
+
+// TestNotLoaded checks that the wrappers fail instead of crashing when no library is loaded. A library cannot
+// be unloaded, so once one is, the test runs again in a fresh process.
+func TestNotLoaded(t *testing.T) {
+	if Loaded() {
+		out, err := exec.Command(os.Args[0], "-test.run=^TestNotLoaded$", "-test.v").CombinedOutput()
+		if err != nil {
+			t.Fatalf("TestNotLoaded failed in a fresh process: %v\n%s", err, out)
+		}
+		return
+	}
+
+	dir := t.TempDir()
+	if err := Load(filepath.Join(dir, "missing.so")); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("loading a missing library returned %v, expected ErrFileNotFound", err)
+	}
+	// The C library loads fine but is not VDDK
+	if err := Load("libc.so.6"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("loading libc returned %v, expected ErrNotSupported", err)
+	}
+	if Loaded() || HasSymbol("VixDiskLib_Init") {
+		t.Fatal("a failed Load left a library loaded")
+	}
+
+	ctx := context.Background()
+	var conn VixDiskLibConnection
+	var dli VixDiskLibHandle
+	params, err := BuildConnectParams(WithPath(filepath.Join(dir, "disk.vmdk")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, VIXDISKLIB_SECTOR_SIZE)
+	calls := map[string]func() VddkError{
+		"Connect":          func() VddkError { _, err := Connect(params); return err },
+		"ConnectEx":        func() VddkError { _, err := ConnectEx(params); return err },
+		"PrepareForAccess": func() VddkError { return PrepareForAccess(params) },
+		"EndAccess":        func() VddkError { return EndAccess(params) },
+		"Cleanup":          func() VddkError { _, _, err := Cleanup(params); return err },
+		"Open":             func() VddkError { _, err := Open(conn, params); return err },
+		"Close":            func() VddkError { return Close(dli) },
+		"Disconnect":       func() VddkError { return Disconnect(conn) },
+		"Read":             func() VddkError { return Read(dli, 0, 1, buf) },
+		"Write":            func() VddkError { return Write(dli, 0, 1, buf) },
+		"ReadAsync":        func() VddkError { _, err := ReadAsync(dli, 0, 1, buf); return err },
+		"WriteAsync":       func() VddkError { _, err := WriteAsync(dli, 0, 1, buf); return err },
+		"Wait":             func() VddkError { return Wait(dli) },
+		"Flush":            func() VddkError { return Flush(dli) },
+		"GetInfo":          func() VddkError { _, err := GetInfo(dli); return err },
+		"ReadMetadata":     func() VddkError { _, err := ReadMetadata(dli, "key"); return err },
+		"WriteMetadata":    func() VddkError { return WriteMetadata(dli, "key", "val") },
+		"GetMetadataKeys":  func() VddkError { _, err := GetMetadataKeys(dli); return err },
+		"QueryAllocatedBlocks": func() VddkError {
+			_, err := QueryAllocatedBlocks(dli, 0, VIXDISKLIB_MIN_CHUNK_SIZE, VIXDISKLIB_MIN_CHUNK_SIZE)
+			return err
+		},
+		"Create": func() VddkError {
+			return Create(ctx, conn, "disk.vmdk", VixDiskLibCreateParams{}, nil)
+		},
+		"CreateChild": func() VddkError { return CreateChild(ctx, dli, "child.vmdk", VIXDISKLIB_DISK_MONOLITHIC_SPARSE, nil) },
+		"Clone": func() VddkError {
+			return Clone(ctx, conn, "dst.vmdk", conn, "src.vmdk", VixDiskLibCreateParams{}, nil, false)
+		},
+		"Grow":                func() VddkError { return Grow(ctx, conn, "disk.vmdk", 4096, false, nil) },
+		"Shrink":              func() VddkError { return Shrink(ctx, dli, nil) },
+		"Defragment":          func() VddkError { return Defragment(ctx, dli, nil) },
+		"Attach":              func() VddkError { return Attach(dli, dli) },
+		"CheckRepair":         func() VddkError { return CheckRepair(conn, "disk.vmdk", false) },
+		"Rename":              func() VddkError { return Rename("a.vmdk", "b.vmdk") },
+		"Unlink":              func() VddkError { return Unlink(conn, "disk.vmdk") },
+		"SpaceNeededForClone": func() VddkError { _, err := SpaceNeededForClone(dli, VIXDISKLIB_DISK_MONOLITHIC_SPARSE); return err },
+	}
+	for op, call := range calls {
+		if err := call(); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("%s returned %v, expected ErrNotSupported", op, err)
+		}
+	}
+	if modes := GetTransportMode(dli); modes != "" {
+		t.Errorf("GetTransportMode returned %q", modes)
+	}
+	if modes := ListTransportModes(); modes != "" {
+		t.Errorf("ListTransportModes returned %q", modes)
+	}
+	Exit()
+}
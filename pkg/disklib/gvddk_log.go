@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+// #include "gvddk_c.h"
+import "C"
+import (
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogLevel identifies which of the VDDK log handlers produced a message.
+type LogLevel int
+
+const (
+	LogInfo  LogLevel = C.GVDDK_LOG_INFO
+	LogWarn  LogLevel = C.GVDDK_LOG_WARN
+	LogPanic LogLevel = C.GVDDK_LOG_PANIC
+)
+
+func (level LogLevel) String() string {
+	switch level {
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogPanic:
+		return "panic"
+	default:
+		return "unknown"
+	}
+}
+
+// LogFunc receives VDDK's log, warning and panic output, formatted and without the trailing newline.
+// It is called from VDDK threads and must be safe for concurrent use.
+type LogFunc func(level LogLevel, msg string)
+
+// InitOption configures Init and InitEx.
+type InitOption func(*initOptions)
+
+type initOptions struct {
+	logFunc LogFunc
+}
+
+// WithLogFunc installs VDDK log handlers that forward every message to logFunc. Use this to route VDDK
+// diagnostics to a logger other than logrus or slog. A nil logFunc keeps VDDK's own handlers.
+func WithLogFunc(logFunc LogFunc) InitOption {
+	return func(opts *initOptions) {
+		opts.logFunc = logFunc
+	}
+}
+
+// WithLogger installs VDDK log handlers that forward messages to logger, tagged with component=vddk.
+// Info messages are logged at info level, warnings at warn level and panics at error level, since a
+// logrus panic would unwind through VDDK. A nil logger keeps VDDK's own handlers.
+func WithLogger(logger logrus.FieldLogger) InitOption {
+	if logger == nil {
+		return WithLogFunc(nil)
+	}
+	entry := logger.WithField("component", "vddk")
+	return WithLogFunc(func(level LogLevel, msg string) {
+		switch level {
+		case LogInfo:
+			entry.Info(msg)
+		case LogWarn:
+			entry.Warn(msg)
+		default:
+			entry.WithField("vddkLevel", level.String()).Error(msg)
+		}
+	})
+}
+
+var (
+	logMutex   sync.RWMutex
+	vddkLogger LogFunc
+)
+
+// setLogFunc records the handler for VDDK log output and reports whether the C log handlers should
+// be installed.
+func setLogFunc(opts []InitOption) bool {
+	var options initOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	vddkLogger = options.logFunc
+	return vddkLogger != nil
+}
+
+//export GoLogMessage
+func GoLogMessage(level C.int, msg *C.char) {
+	logMutex.RLock()
+	logFunc := vddkLogger
+	logMutex.RUnlock()
+	if logFunc == nil {
+		return
+	}
+	logFunc(LogLevel(level), strings.TrimRight(C.GoString(msg), "\r\n"))
+}
@@ -0,0 +1,51 @@
+//go:build go1.21
+
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// WithSlogHandler installs VDDK log handlers that forward messages to handler, with the attribute
+// component=vddk. As with WithLogger, info messages are logged at slog.LevelInfo, warnings at
+// slog.LevelWarn and panics at slog.LevelError with vddkLevel=panic. A nil handler keeps VDDK's own
+// handlers. log/slog needs Go 1.21, so the option is only built with it.
+func WithSlogHandler(handler slog.Handler) InitOption {
+	if handler == nil {
+		return WithLogFunc(nil)
+	}
+	handler = handler.WithAttrs([]slog.Attr{slog.String("component", "vddk")})
+	return WithLogFunc(func(level LogLevel, msg string) {
+		ctx := context.Background()
+		record := slog.NewRecord(time.Now(), slog.LevelInfo, msg, 0)
+		switch level {
+		case LogInfo:
+		case LogWarn:
+			record.Level = slog.LevelWarn
+		default:
+			record.Level = slog.LevelError
+			record.AddAttrs(slog.String("vddkLevel", level.String()))
+		}
+		if handler.Enabled(ctx, record.Level) {
+			handler.Handle(ctx, record)
+		}
+	})
+}
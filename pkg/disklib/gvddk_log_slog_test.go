@@ -0,0 +1,83 @@
+//go:build go1.21
+
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// applyLogOption returns the LogFunc opt installs.
+func applyLogOption(opt InitOption) LogFunc {
+	var options initOptions
+	opt(&options)
+	return options.logFunc
+}
+
+func TestSlogHandler(t *testing.T) {
+	var out bytes.Buffer
+	handler := slog.NewTextHandler(&out, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})
+	logFunc := applyLogOption(WithSlogHandler(handler))
+	if logFunc == nil {
+		t.Fatal("WithSlogHandler installed no LogFunc")
+	}
+	logFunc(LogInfo, "info 1")
+	logFunc(LogWarn, "warn 2")
+	logFunc(LogPanic, "panic 3")
+
+	expected := []string{
+		`level=INFO msg="info 1" component=vddk`,
+		`level=WARN msg="warn 2" component=vddk`,
+		`level=ERROR msg="panic 3" component=vddk vddkLevel=panic`,
+	}
+	if received := strings.TrimSuffix(out.String(), "\n"); received != strings.Join(expected, "\n") {
+		t.Errorf("handler received\n%s\nexpected\n%s", received, strings.Join(expected, "\n"))
+	}
+
+	// Messages below the level of the handler are dropped
+	out.Reset()
+	warnOnly := applyLogOption(WithSlogHandler(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	warnOnly(LogInfo, "info 1")
+	if out.Len() != 0 {
+		t.Errorf("handler at warn level received %q", out.String())
+	}
+}
+
+func TestLogOptionsNil(t *testing.T) {
+	// A nil logger or handler keeps VDDK's own handlers instead of failing when VDDK logs
+	for name, opt := range map[string]InitOption{
+		"WithLogFunc":     WithLogFunc(nil),
+		"WithLogger":      WithLogger(nil),
+		"WithSlogHandler": WithSlogHandler(nil),
+	} {
+		if logFunc := applyLogOption(opt); logFunc != nil {
+			t.Errorf("%s(nil) installed a LogFunc", name)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+//go:build vddkstub
+
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+type logMessage struct {
+	level LogLevel
+	msg   string
+}
+
+// initStubLogging initializes the stub again with opts and restores VDDK's own handlers at the end of the test.
+func initStubLogging(t *testing.T, opts ...InitOption) {
+	initStub(t)
+	libDir := os.Getenv("LIBPATH")
+	if err := Init(7, 0, libDir, opts...); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := Init(7, 0, libDir); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestStubLogFunc(t *testing.T) {
+	var mutex sync.Mutex
+	var messages []logMessage
+	initStubLogging(t, WithLogFunc(func(level LogLevel, msg string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		messages = append(messages, logMessage{level, msg})
+	}))
+
+	// Longer than the stack buffer of LogMessage, so it is formatted again on the heap
+	long := strings.Repeat("x", 3000)
+	for _, level := range []LogLevel{LogInfo, LogWarn, LogPanic} {
+		if !stubLog(level, "message", int(level)) {
+			t.Fatal("the stub does not provide VixDiskLibStub_Log")
+		}
+	}
+	stubLog(LogWarn, long, 7)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := []logMessage{
+		{LogInfo, "VixDiskLib stub 7.0 initialized from " + os.Getenv("LIBPATH")},
+		{LogInfo, "message 0"},
+		{LogWarn, "message 1"},
+		{LogPanic, "message 2"},
+		{LogWarn, long + " 7"},
+	}
+	if len(messages) != len(expected) {
+		t.Fatalf("received %d messages, expected %d", len(messages), len(expected))
+	}
+	for i := range expected {
+		if messages[i] != expected[i] {
+			t.Errorf("message %d is %s %.40q, expected %s %.40q", i, messages[i].level, messages[i].msg, expected[i].level, expected[i].msg)
+		}
+	}
+	if len(messages[4].msg) != len(expected[4].msg) {
+		t.Errorf("long message has %d bytes, expected %d", len(messages[4].msg), len(expected[4].msg))
+	}
+}
+
+func TestStubLogger(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	initStubLogging(t, WithLogger(logger))
+	hook.Reset()
+
+	stubLog(LogInfo, "info", 1)
+	stubLog(LogWarn, "warn", 2)
+	stubLog(LogPanic, "panic", 3)
+
+	entries := hook.AllEntries()
+	expected := []struct {
+		level logrus.Level
+		msg   string
+	}{
+		{logrus.InfoLevel, "info 1"},
+		{logrus.WarnLevel, "warn 2"},
+		{logrus.ErrorLevel, "panic 3"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("logged %d entries, expected %d", len(entries), len(expected))
+	}
+	for i, entry := range entries {
+		if entry.Level != expected[i].level || entry.Message != expected[i].msg {
+			t.Errorf("entry %d is %s %q, expected %s %q", i, entry.Level, entry.Message, expected[i].level, expected[i].msg)
+		}
+		if entry.Data["component"] != "vddk" {
+			t.Errorf("entry %d has component %v", i, entry.Data["component"])
+		}
+	}
+	if level := entries[2].Data["vddkLevel"]; level != "panic" {
+		t.Errorf("panic entry has vddkLevel %v", level)
+	}
+}
+
+func TestStubLogDefault(t *testing.T) {
+	var received int
+	initStubLogging(t, WithLogFunc(func(level LogLevel, msg string) {
+		received++
+	}))
+	// Init without options installs no Go handlers, so nothing reaches the previous LogFunc
+	if err := Init(7, 0, os.Getenv("LIBPATH")); err != nil {
+		t.Fatal(err)
+	}
+	received = 0
+	stubLog(LogInfo, "info", 1)
+	if received != 0 {
+		t.Errorf("%d messages reached the LogFunc of an earlier Init", received)
+	}
+}
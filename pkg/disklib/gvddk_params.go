@@ -0,0 +1,245 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Transport modes other than the NBD ones
+const (
+	FILE = "file"
+	SAN  = "san"
+)
+
+// Maximum length of the identity passed to PrepareForAccess and EndAccess
+const maxIdentityLen = 50
+
+// ConnectOption sets one aspect of ConnectParams. Options are applied in order, so later options override
+// earlier ones.
+type ConnectOption func(*ConnectParams)
+
+// WithServer sets the vCenter or ESXi host to connect to and the SHA-1 thumbprint of its certificate,
+// see GetThumbPrintForServer.
+func WithServer(serverName string, thumbPrint string) ConnectOption {
+	return func(params *ConnectParams) {
+		params.serverName = serverName
+		params.thumbPrint = thumbPrint
+	}
+}
+
+// WithCredentials authenticates with a user name and password.
+func WithCredentials(userName string, password string) ConnectOption {
+	return func(params *ConnectParams) {
+		params.cookie = ""
+		params.userName = userName
+		params.password = password
+	}
+}
+
+// WithSessionCookie authenticates with an existing vSphere session instead of a password.
+func WithSessionCookie(cookie string, userName string, key string) ConnectOption {
+	return func(params *ConnectParams) {
+		params.cookie = cookie
+		params.userName = userName
+		params.password = key
+	}
+}
+
+// WithFCD selects the first class disk fcdId on datastore, given as a managed object reference.
+func WithFCD(fcdId string, datastore string) ConnectOption {
+	return func(params *ConnectParams) {
+		params.fcdId = fcdId
+		params.ds = datastore
+	}
+}
+
+// WithFCDSnapshot selects a snapshot of the first class disk set with WithFCD.
+func WithFCDSnapshot(fcdssId string) ConnectOption {
+	return func(params *ConnectParams) {
+		params.fcdssId = fcdssId
+	}
+}
+
+// WithVMX selects the virtual machine whose disks are accessed, e.g. "moref=vm-42".
+func WithVMX(vmxSpec string) ConnectOption {
+	return func(params *ConnectParams) {
+		params.vmxSpec = vmxSpec
+	}
+}
+
+// WithIdentity sets the identity passed to PrepareForAccess and EndAccess, at most 50 characters.
+func WithIdentity(identity string) ConnectOption {
+	return func(params *ConnectParams) {
+		params.identity = identity
+	}
+}
+
+// WithPath sets the path of the disk to open, e.g. "[datastore1] vm/vm.vmdk".
+func WithPath(path string) ConnectOption {
+	return func(params *ConnectParams) {
+		params.path = path
+	}
+}
+
+// WithOpenFlags sets the VIXDISKLIB_FLAG_OPEN_* flags used to open the disk.
+func WithOpenFlags(flag uint32) ConnectOption {
+	return func(params *ConnectParams) {
+		params.flag = flag
+	}
+}
+
+// WithReadOnly requests a read only connection.
+func WithReadOnly(readOnly bool) ConnectOption {
+	return func(params *ConnectParams) {
+		params.readOnly = readOnly
+	}
+}
+
+// WithTransport sets the transport modes to try, in order of preference, e.g. NBDSSL or "hotadd:nbdssl".
+func WithTransport(modes ...string) ConnectOption {
+	return func(params *ConnectParams) {
+		params.mode = strings.Join(modes, ":")
+	}
+}
+
+// BuildConnectParams returns ConnectParams configured by opts, after checking them with Validate.
+func BuildConnectParams(opts ...ConnectOption) (ConnectParams, error) {
+	params := ConnectParams{}.With(opts...)
+	if err := params.Validate(); err != nil {
+		return ConnectParams{}, err
+	}
+	return params, nil
+}
+
+// With returns a copy of the parameters with opts applied. The receiver is not modified.
+func (this ConnectParams) With(opts ...ConnectOption) ConnectParams {
+	for _, opt := range opts {
+		opt(&this)
+	}
+	return this
+}
+
+func (this ConnectParams) VmxSpec() string {
+	return this.vmxSpec
+}
+
+func (this ConnectParams) ServerName() string {
+	return this.serverName
+}
+
+func (this ConnectParams) ThumbPrint() string {
+	return this.thumbPrint
+}
+
+func (this ConnectParams) UserName() string {
+	return this.userName
+}
+
+func (this ConnectParams) FcdId() string {
+	return this.fcdId
+}
+
+func (this ConnectParams) Datastore() string {
+	return this.ds
+}
+
+func (this ConnectParams) FcdSnapshotId() string {
+	return this.fcdssId
+}
+
+func (this ConnectParams) SessionCookie() string {
+	return this.cookie
+}
+
+func (this ConnectParams) Identity() string {
+	return this.identity
+}
+
+func (this ConnectParams) Path() string {
+	return this.path
+}
+
+func (this ConnectParams) Flags() uint32 {
+	return this.flag
+}
+
+func (this ConnectParams) ReadOnly() bool {
+	return this.readOnly
+}
+
+func (this ConnectParams) TransportModes() []string {
+	if this.mode == "" {
+		return nil
+	}
+	return strings.Split(this.mode, ":")
+}
+
+// Validate checks the parameters for contradictory or incomplete combinations. The returned error
+// matches ErrInvalidArg. Connect, ConnectEx, PrepareForAccess, EndAccess and Cleanup validate their
+// parameters before calling VDDK.
+func (this ConnectParams) Validate() error {
+	if err := this.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this ConnectParams) validate() VddkError {
+	invalid := func(format string, args ...interface{}) VddkError {
+		return NewVddkError(VIX_E_INVALID_ARG, "Invalid connect parameters: "+fmt.Sprintf(format, args...))
+	}
+	if this.fcdId != "" && this.vmxSpec != "" {
+		return invalid("a first class disk (%q) and a virtual machine (%q) cannot both be selected", this.fcdId, this.vmxSpec)
+	}
+	if this.fcdId != "" && this.ds == "" {
+		return invalid("first class disk %q requires a datastore", this.fcdId)
+	}
+	if this.fcdId != "" && this.serverName == "" {
+		return invalid("first class disk %q requires a server", this.fcdId)
+	}
+	if this.fcdssId != "" && this.fcdId == "" {
+		return invalid("first class disk snapshot %q requires a first class disk", this.fcdssId)
+	}
+	if this.cookie != "" && this.userName == "" {
+		return invalid("session cookie requires a user name")
+	}
+	if len(this.identity) > maxIdentityLen {
+		return invalid("identity %q is longer than %d characters", this.identity, maxIdentityLen)
+	}
+
+	compression := this.flag & VIXDISKLIB_FLAG_OPEN_COMPRESSION_MASK
+	if compression&(compression-1) != 0 {
+		return invalid("at most one compression flag may be set, got %#x", compression)
+	}
+	hasNbd := false
+	for _, mode := range this.TransportModes() {
+		switch mode {
+		case NBD, NBDSSL:
+			hasNbd = true
+		case HOTADD, SAN, FILE:
+		default:
+			return invalid("unknown transport mode %q", mode)
+		}
+	}
+	// Compression only applies to NBD transports
+	if compression != 0 && this.mode != "" && !hasNbd {
+		return invalid("compression flags %#x require the nbd or nbdssl transport, got %q", compression, this.mode)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	fcd := []ConnectOption{WithServer("vc.example.com", "AA:BB"), WithCredentials("user", "secret"), WithFCD("fcd-1", "datastore-1")}
+	tests := []struct {
+		name string
+		opts []ConnectOption
+		// reason is part of the error message for rejected parameters, empty for accepted ones
+		reason string
+	}{
+		{"local file", []ConnectOption{WithPath("/tmp/disk.vmdk")}, ""},
+		{"virtual machine over nbdssl", []ConnectOption{WithServer("vc.example.com", "AA:BB"), WithCredentials("user", "secret"),
+			WithVMX("moref=vm-42"), WithTransport(HOTADD, NBDSSL), WithOpenFlags(VIXDISKLIB_FLAG_OPEN_COMPRESSION_SKIPZ)}, ""},
+		{"first class disk snapshot", append(fcd, WithFCDSnapshot("fcdss-1")), ""},
+		{"session cookie", []ConnectOption{WithSessionCookie("cookie", "user", "key")}, ""},
+		{"identity of 50 characters", []ConnectOption{WithIdentity(strings.Repeat("i", 50))}, ""},
+		{"compression without transport", []ConnectOption{WithOpenFlags(VIXDISKLIB_FLAG_OPEN_COMPRESSION_ZLIB)}, ""},
+
+		{"first class disk and virtual machine", append(fcd, WithVMX("moref=vm-42")), "cannot both be selected"},
+		{"first class disk without datastore", []ConnectOption{WithServer("vc.example.com", "AA:BB"), WithFCD("fcd-1", "")}, "requires a datastore"},
+		{"first class disk without server", []ConnectOption{WithFCD("fcd-1", "datastore-1")}, "requires a server"},
+		{"snapshot without first class disk", []ConnectOption{WithFCDSnapshot("fcdss-1")}, "requires a first class disk"},
+		{"cookie without user", []ConnectOption{WithSessionCookie("cookie", "", "key")}, "requires a user name"},
+		{"identity of 51 characters", []ConnectOption{WithIdentity(strings.Repeat("i", 51))}, "longer than 50 characters"},
+		{"two compression flags", []ConnectOption{WithOpenFlags(VIXDISKLIB_FLAG_OPEN_COMPRESSION_ZLIB | VIXDISKLIB_FLAG_OPEN_COMPRESSION_FASTLZ)}, "at most one compression flag"},
+		{"compression over hotadd", []ConnectOption{WithTransport(HOTADD), WithOpenFlags(VIXDISKLIB_FLAG_OPEN_COMPRESSION_SKIPZ)}, "require the nbd or nbdssl transport"},
+		{"unknown transport mode", []ConnectOption{WithTransport(NBD, "carrier-pigeon")}, "unknown transport mode"},
+	}
+	for _, test := range tests {
+		params := ConnectParams{}.With(test.opts...)
+		err := params.Validate()
+		if test.reason == "" {
+			if err != nil {
+				t.Errorf("%s: Validate failed: %v", test.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidArg) || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("%s: Validate returned %v, expected ErrInvalidArg because of %q", test.name, err, test.reason)
+		}
+		if _, err := BuildConnectParams(test.opts...); err == nil {
+			t.Errorf("%s: BuildConnectParams accepted the parameters", test.name)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+// #include "gvddk_c.h"
+import "C"
+import (
+	"context"
+	"fmt"
+	"runtime/cgo"
+)
+
+// ProgressFunc receives the completion percentage VDDK reports for a long running operation
+// (Create, CreateChild, Clone, Grow, Shrink and Defragment). Returning false aborts the operation.
+type ProgressFunc func(percentCompleted int) bool
+
+// progressState is the Go side of the progress data handed to the C ProgressFunc.
+type progressState struct {
+	ctx      context.Context
+	progress ProgressFunc
+}
+
+// newHandle and deleteHandle are cgo.NewHandle and cgo.Handle.Delete, which tests replace to check that no
+// progress handle leaks.
+var (
+	newHandle    = cgo.NewHandle
+	deleteHandle = cgo.Handle.Delete
+)
+
+// newProgressHandle wraps ctx and progress in a handle that can be passed through C as the
+// progress callback data. The caller must pass the handle to deleteProgressHandle once the operation
+// returns.
+func newProgressHandle(ctx context.Context, progress ProgressFunc) cgo.Handle {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return newHandle(&progressState{
+		ctx:      ctx,
+		progress: progress,
+	})
+}
+
+// deleteProgressHandle releases a handle returned by newProgressHandle.
+func deleteProgressHandle(progressHandle cgo.Handle) {
+	deleteHandle(progressHandle)
+}
+
+//export GoProgressCallback
+func GoProgressCallback(progressHandle C.uintptr_t, percentCompleted C.int) C._Bool {
+	state := cgo.Handle(progressHandle).Value().(*progressState)
+	// VDDK has no other way to interrupt an operation, so cancellation is only noticed on the next
+	// progress report.
+	if state.ctx.Err() != nil {
+		return false
+	}
+	if state.progress != nil && !state.progress(int(percentCompleted)) {
+		return false
+	}
+	return true
+}
+
+// progressError builds the error for a failed long running operation. When the operation was aborted
+// because ctx was cancelled, the error wraps the context error.
+func progressError(ctx context.Context, res C.VixError, msg string, op string, args string) VddkError {
+	if ctx != nil && ctx.Err() != nil {
+		return &vddkErrorImpl{
+			err_code: uint64(res),
+			err_msg:  fmt.Sprintf("%s. The operation was aborted: %s.", msg, ctx.Err()),
+			op:       op,
+			args:     args,
+			cause:    ctx.Err(),
+			vix:      true,
+		}
+	}
+	return newVixError(res, msg, op, args)
+}
@@ -0,0 +1,211 @@
+//go:build vddkstub
+
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/cgo"
+	"sync/atomic"
+	"testing"
+)
+
+// progressOps runs each long running operation against the stub. Every call of an op works on fresh files,
+// so that Create and Clone do not find their destination from an earlier call.
+func progressOps(t *testing.T) map[string]func(ctx context.Context, progress ProgressFunc) VddkError {
+	dli, path := openStub(t)
+	dir := filepath.Dir(path)
+	params, err := BuildConnectParams(WithPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, vErr := ConnectEx(params)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	t.Cleanup(func() {
+		Disconnect(conn)
+	})
+	createParams := NewCreateParams(VIXDISKLIB_DISK_MONOLITHIC_SPARSE, VIXDISKLIB_ADAPTER_SCSI_LSILOGIC, 7, 64)
+	calls := 0
+	newPath := func(name string) string {
+		calls++
+		return filepath.Join(dir, fmt.Sprintf("%s-%d.img", name, calls))
+	}
+	return map[string]func(ctx context.Context, progress ProgressFunc) VddkError{
+		"Create": func(ctx context.Context, progress ProgressFunc) VddkError {
+			return Create(ctx, conn, newPath("create"), createParams, progress)
+		},
+		"CreateChild": func(ctx context.Context, progress ProgressFunc) VddkError {
+			return CreateChild(ctx, dli, newPath("child"), VIXDISKLIB_DISK_MONOLITHIC_SPARSE, progress)
+		},
+		"Clone": func(ctx context.Context, progress ProgressFunc) VddkError {
+			return Clone(ctx, conn, newPath("clone"), conn, path, createParams, progress, false)
+		},
+		"Grow": func(ctx context.Context, progress ProgressFunc) VddkError {
+			grown := newPath("grow")
+			if err := os.WriteFile(grown, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			return Grow(ctx, conn, grown, 128, false, progress)
+		},
+		"Shrink": func(ctx context.Context, progress ProgressFunc) VddkError {
+			return Shrink(ctx, dli, progress)
+		},
+		"Defragment": func(ctx context.Context, progress ProgressFunc) VddkError {
+			return Defragment(ctx, dli, progress)
+		},
+	}
+}
+
+// countProgressHandles counts the progress handles created and not deleted until the end of the test.
+func countProgressHandles(t *testing.T) *int64 {
+	var handles int64
+	t.Cleanup(func() {
+		newHandle = cgo.NewHandle
+		deleteHandle = cgo.Handle.Delete
+	})
+	newHandle = func(v interface{}) cgo.Handle {
+		atomic.AddInt64(&handles, 1)
+		return cgo.NewHandle(v)
+	}
+	deleteHandle = func(handle cgo.Handle) {
+		handle.Delete()
+		atomic.AddInt64(&handles, -1)
+	}
+	return &handles
+}
+
+// checkProgressHandles fails the test if a progress handle counted in handles was not deleted.
+func checkProgressHandles(t *testing.T, handles *int64) {
+	t.Helper()
+	if n := atomic.LoadInt64(handles); n != 0 {
+		t.Errorf("%d progress handles were not deleted", n)
+	}
+}
+
+func TestStubProgress(t *testing.T) {
+	baseline := initStub(t)
+	handles := countProgressHandles(t)
+	checkOutstanding(t, baseline)
+	for op, call := range progressOps(t) {
+		var percents []int
+		record := func(percent int) bool {
+			percents = append(percents, percent)
+			return true
+		}
+		if vErr := call(context.Background(), record); vErr != nil {
+			t.Errorf("%s failed: %v", op, vErr)
+		}
+		if expected := []int{0, 25, 50, 75, 100}; !reflect.DeepEqual(percents, expected) {
+			t.Errorf("%s reported progress %v, expected %v", op, percents, expected)
+		}
+		// Neither a progress function nor a context is required
+		if vErr := call(nil, nil); vErr != nil {
+			t.Errorf("%s without progress function failed: %v", op, vErr)
+		}
+		checkProgressHandles(t, handles)
+	}
+}
+
+func TestStubProgressAbort(t *testing.T) {
+	baseline := initStub(t)
+	handles := countProgressHandles(t)
+	checkOutstanding(t, baseline)
+	for op, call := range progressOps(t) {
+		var percents []int
+		vErr := call(context.Background(), func(percent int) bool {
+			percents = append(percents, percent)
+			return percent < 50
+		})
+		if !errors.Is(vErr, ErrCancelled) || errors.Is(vErr, context.Canceled) {
+			t.Errorf("%s aborted by its progress function returned %v, expected ErrCancelled without a context error", op, vErr)
+		}
+		if expected := []int{0, 25, 50}; !reflect.DeepEqual(percents, expected) {
+			t.Errorf("%s reported progress %v after the abort, expected %v", op, percents, expected)
+		}
+		checkProgressHandles(t, handles)
+	}
+}
+
+func TestStubProgressContext(t *testing.T) {
+	baseline := initStub(t)
+	handles := countProgressHandles(t)
+	checkOutstanding(t, baseline)
+	for op, call := range progressOps(t) {
+		// Cancelled up front, the progress function is never called
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		called := false
+		vErr := call(ctx, func(percent int) bool {
+			called = true
+			return true
+		})
+		if !errors.Is(vErr, ErrCancelled) || !errors.Is(vErr, context.Canceled) {
+			t.Errorf("%s with a cancelled context returned %v, expected ErrCancelled and context.Canceled", op, vErr)
+		}
+		if called {
+			t.Errorf("%s called the progress function with a cancelled context", op)
+		}
+
+		// Cancelled during the operation, it stops at the next progress report
+		ctx, cancel = context.WithCancel(context.Background())
+		var percents []int
+		vErr = call(ctx, func(percent int) bool {
+			percents = append(percents, percent)
+			if percent == 25 {
+				cancel()
+			}
+			return true
+		})
+		cancel()
+		if !errors.Is(vErr, context.Canceled) {
+			t.Errorf("%s cancelled during the operation returned %v, expected context.Canceled", op, vErr)
+		}
+		if expected := []int{0, 25}; !reflect.DeepEqual(percents, expected) {
+			t.Errorf("%s reported progress %v after the cancellation, expected %v", op, percents, expected)
+		}
+		checkProgressHandles(t, handles)
+	}
+}
+
+func TestStubProgressFailure(t *testing.T) {
+	baseline := initStub(t)
+	handles := countProgressHandles(t)
+	checkOutstanding(t, baseline)
+	for op, call := range progressOps(t) {
+		stubInjectFault(op, VIX_E_DISK_FULL, 0, 1)
+		called := false
+		vErr := call(context.Background(), func(percent int) bool {
+			called = true
+			return true
+		})
+		if !errors.Is(vErr, ErrDiskFull) || errors.Is(vErr, context.Canceled) {
+			t.Errorf("%s with an injected fault returned %v, expected ErrDiskFull", op, vErr)
+		}
+		if called {
+			t.Errorf("%s reported progress although it failed up front", op)
+		}
+		checkProgressHandles(t, handles)
+	}
+}
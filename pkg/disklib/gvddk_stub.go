@@ -0,0 +1,124 @@
+//go:build vddkstub
+
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+/*
+#include "gvddk_c.h"
+
+static long GvddkStubOutstanding(void)
+{
+    long (*outstanding)(void) = (long (*)(void))GvddkLookup("VixDiskLibStub_Outstanding");
+
+    return outstanding == NULL ? -1 : outstanding();
+}
+
+static bool GvddkStubInjectFault(const char *op, VixError err, int skip, int count)
+{
+    int (*inject)(const char *, VixError, int, int) =
+        (int (*)(const char *, VixError, int, int))GvddkLookup("VixDiskLibStub_InjectFault");
+
+    return inject != NULL && inject(op, err, skip, count) == 0;
+}
+
+static bool GvddkStubLog(int level, const char *msg, int n)
+{
+    void (*log)(int, const char *, int) = (void (*)(int, const char *, int))GvddkLookup("VixDiskLibStub_Log");
+
+    if (log == NULL) {
+        return false;
+    }
+    log(level, msg, n);
+    return true;
+}
+
+static bool GvddkStubSetSectorSizes(uint32 logical, uint32 physical)
+{
+    void (*set)(uint32, uint32) = (void (*)(uint32, uint32))GvddkLookup("VixDiskLibStub_SetSectorSizes");
+
+    if (set == NULL) {
+        return false;
+    }
+    set(logical, physical);
+    return true;
+}
+
+static void GvddkStubClearFaults(void)
+{
+    void (*clear)(void) = (void (*)(void))GvddkLookup("VixDiskLibStub_ClearFaults");
+
+    if (clear != NULL) {
+        clear();
+    }
+}
+*/
+import "C"
+import "unsafe"
+
+// Hooks into the stub library of test/vddkstub, so that tests of this package can check error mapping and
+// memory handling without VDDK. They are only built with the vddkstub tag, see "make test".
+
+// stubOutstanding returns the number of objects the stub handed out and has not seen freed, or -1 if the
+// loaded library is not the stub.
+func stubOutstanding() int64 {
+	return int64(C.GvddkStubOutstanding())
+}
+
+// stubInjectFault makes the stub fail count calls of the VixDiskLib function op, e.g. "Read", with errCode
+// after letting skip calls through. A negative count fails every further call.
+func stubInjectFault(op string, errCode uint64, skip int, count int) bool {
+	cOp := C.CString(op)
+	defer C.free(unsafe.Pointer(cOp))
+	return bool(C.GvddkStubInjectFault(cOp, C.VixError(errCode), C.int(skip), C.int(count)))
+}
+
+// stubClearFaults removes all injected faults.
+func stubClearFaults() {
+	C.GvddkStubClearFaults()
+}
+
+// stubLog makes the stub send "msg n" through the log handler of level that Init installed.
+func stubLog(level LogLevel, msg string, n int) bool {
+	cMsg := C.CString(msg)
+	defer C.free(unsafe.Pointer(cMsg))
+	return bool(C.GvddkStubLog(C.int(level), cMsg, C.int(n)))
+}
+
+// stubSetSectorSizes sets the sector sizes of the disks the stub opens from now on.
+func stubSetSectorSizes(logical uint32, physical uint32) bool {
+	return bool(C.GvddkStubSetSectorSizes(C.uint32(logical), C.uint32(physical)))
+}
+
+// StubOutstanding, StubInjectFault and StubClearFaults make the hooks available to the tests of the packages
+// built on this one.
+
+// StubOutstanding is stubOutstanding for other packages.
+func StubOutstanding() int64 {
+	return stubOutstanding()
+}
+
+// StubInjectFault is stubInjectFault for other packages.
+func StubInjectFault(op string, errCode uint64, skip int, count int) bool {
+	return stubInjectFault(op, errCode, skip, count)
+}
+
+// StubClearFaults is stubClearFaults for other packages.
+func StubClearFaults() {
+	stubClearFaults()
+}
+
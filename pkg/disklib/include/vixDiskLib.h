@@ -0,0 +1,321 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+ * Minimal stand-in for the VDDK vixDiskLib.h header. It declares only the
+ * subset of the VixDiskLib API used by pkg/disklib, with the same names and
+ * calling conventions as VDDK 8.0, so that the package builds on hosts
+ * without VDDK; the library itself is loaded at runtime. The stub library in
+ * test/vddkstub is built against it too. Directories in CGO_CFLAGS are
+ * searched first, so VDDK's own header is used when its include directory is
+ * given there.
+ */
+
+#ifndef _VIXDISKLIB_STUB_H_
+#define _VIXDISKLIB_STUB_H_
+
+#include <stdarg.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+#define VIXDISKLIB_VERSION_MAJOR 8
+#define VIXDISKLIB_VERSION_MINOR 0
+
+typedef uint8_t  uint8;
+typedef uint16_t uint16;
+typedef uint32_t uint32;
+typedef uint64_t uint64;
+typedef int32_t  int32;
+typedef int64_t  int64;
+typedef char     Bool;
+
+typedef uint64 VixError;
+
+#define VIX_ERROR_CODE(err) ((err) & 0xFFFF)
+#define VIX_SUCCEEDED(err)  (VIX_OK == (err))
+#define VIX_FAILED(err)     (VIX_OK != (err))
+
+enum {
+   VIX_OK                          = 0,
+   VIX_E_FAIL                      = 1,
+   VIX_E_OUT_OF_MEMORY             = 2,
+   VIX_E_INVALID_ARG               = 3,
+   VIX_E_FILE_NOT_FOUND            = 4,
+   VIX_E_OBJECT_IS_BUSY            = 5,
+   VIX_E_NOT_SUPPORTED             = 6,
+   VIX_E_FILE_ERROR                = 7,
+   VIX_E_DISK_FULL                 = 8,
+   VIX_E_INCORRECT_FILE_TYPE       = 9,
+   VIX_E_CANCELLED                 = 10,
+   VIX_E_FILE_READ_ONLY            = 11,
+   VIX_E_FILE_ALREADY_EXISTS       = 12,
+   VIX_E_FILE_ACCESS_ERROR         = 13,
+   VIX_E_REQUIRES_LARGE_FILES      = 14,
+   VIX_E_FILE_ALREADY_LOCKED       = 15,
+   VIX_E_BUFFER_TOOSMALL           = 24,
+   VIX_E_OBJECT_NOT_FOUND          = 25,
+   VIX_E_HOST_NOT_CONNECTED        = 26,
+   VIX_E_AUTHENTICATION_FAIL       = 35,
+   VIX_E_HOST_CONNECTION_LOST      = 36,
+   VIX_E_HOST_USER_PERMISSIONS     = 3015,
+   VIX_E_DISK_INVAL                = 16000,
+   VIX_E_DISK_NOINIT               = 16001,
+   VIX_E_DISK_NOIO                 = 16002,
+   VIX_E_DISK_PARTIALCHAIN         = 16003,
+   VIX_E_DISK_NEEDSREPAIR          = 16006,
+   VIX_E_DISK_OUTOFRANGE           = 16007,
+   VIX_E_DISK_NOTSUPPORTED         = 16013,
+   VIX_E_DISK_KEY_NOTFOUND         = 16052,
+   VIX_E_DISK_INVALID_CONNECTION   = 16054,
+   VIX_E_NET_HTTP_COULDNT_CONNECT  = 30007,
+   VIX_E_NET_HTTP_OPERATION_TIMEDOUT = 30028,
+   VIX_E_NET_HTTP_TRANSFER         = 30200,
+   VIX_ASYNC                       = 25000,
+};
+
+#define VIXDISKLIB_SECTOR_SIZE 512
+
+#define VIXDISKLIB_MIN_CHUNK_SIZE   128
+#define VIXDISKLIB_MAX_CHUNK_SIZE   (64 * 1024 * 1024 / VIXDISKLIB_SECTOR_SIZE)
+#define VIXDISKLIB_MAX_CHUNK_NUMBER (512 * 1024)
+
+#define VIXDISKLIB_FLAG_OPEN_UNBUFFERED         (1 << 0)
+#define VIXDISKLIB_FLAG_OPEN_SINGLE_LINK        (1 << 1)
+#define VIXDISKLIB_FLAG_OPEN_READ_ONLY          (1 << 2)
+#define VIXDISKLIB_FLAG_OPEN_COMPRESSION_ZLIB   (1 << 4)
+#define VIXDISKLIB_FLAG_OPEN_COMPRESSION_FASTLZ (1 << 5)
+#define VIXDISKLIB_FLAG_OPEN_COMPRESSION_SKIPZ  (1 << 7)
+#define VIXDISKLIB_FLAG_OPEN_COMPRESSION_MASK   (VIXDISKLIB_FLAG_OPEN_COMPRESSION_ZLIB | \
+                                                 VIXDISKLIB_FLAG_OPEN_COMPRESSION_FASTLZ | \
+                                                 VIXDISKLIB_FLAG_OPEN_COMPRESSION_SKIPZ)
+
+typedef uint64 VixDiskLibSectorType;
+
+typedef struct VixDiskLibConnectParam *VixDiskLibConnection;
+typedef struct VixDiskLibHandleStruct *VixDiskLibHandle;
+
+typedef enum {
+   VIXDISKLIB_DISK_MONOLITHIC_SPARSE = 1,
+   VIXDISKLIB_DISK_MONOLITHIC_FLAT   = 2,
+   VIXDISKLIB_DISK_SPLIT_SPARSE      = 3,
+   VIXDISKLIB_DISK_SPLIT_FLAT        = 4,
+   VIXDISKLIB_DISK_VMFS_FLAT         = 5,
+   VIXDISKLIB_DISK_STREAM_OPTIMIZED  = 6,
+   VIXDISKLIB_DISK_VMFS_THIN         = 7,
+   VIXDISKLIB_DISK_VMFS_SPARSE       = 8,
+   VIXDISKLIB_DISK_UNKNOWN           = 256,
+} VixDiskLibDiskType;
+
+typedef enum {
+   VIXDISKLIB_ADAPTER_IDE           = 1,
+   VIXDISKLIB_ADAPTER_SCSI_BUSLOGIC = 2,
+   VIXDISKLIB_ADAPTER_SCSI_LSILOGIC = 3,
+   VIXDISKLIB_ADAPTER_UNKNOWN       = 256,
+} VixDiskLibAdapterType;
+
+typedef enum {
+   VIXDISKLIB_CRED_UID       = 1,
+   VIXDISKLIB_CRED_SESSIONID = 2,
+   VIXDISKLIB_CRED_TICKETID  = 3,
+   VIXDISKLIB_CRED_SSPI      = 4,
+   VIXDISKLIB_CRED_UNKNOWN   = 256,
+} VixDiskLibCredType;
+
+typedef enum {
+   VIXDISKLIB_SPEC_VMX             = 0,
+   VIXDISKLIB_SPEC_VSTORAGE_OBJECT = 1,
+   VIXDISKLIB_SPEC_UNKNOWN         = 2,
+} VixDiskLibSpecType;
+
+typedef struct {
+   uint32 cylinders;
+   uint32 heads;
+   uint32 sectors;
+} VixDiskLibGeometry;
+
+typedef struct {
+   VixDiskLibGeometry    biosGeo;
+   VixDiskLibGeometry    physGeo;
+   VixDiskLibSectorType  capacity;
+   VixDiskLibAdapterType adapterType;
+   int                   numLinks;
+   char                 *parentFileNameHint;
+   char                 *uuid;
+   /* Appended in 8.0, libraries before it allocate the info without them */
+   uint32                logicalSectorSize;
+   uint32                physicalSectorSize;
+} VixDiskLibInfo;
+
+typedef struct {
+   VixDiskLibDiskType    diskType;
+   VixDiskLibAdapterType adapterType;
+   uint16                hwVersion;
+   VixDiskLibSectorType  capacity;
+} VixDiskLibCreateParams;
+
+typedef struct {
+   char *userName;
+   char *password;
+} VixDiskLibUidPasswdCreds;
+
+typedef struct {
+   char *cookie;
+   char *userName;
+   char *key;
+} VixDiskLibSessionIdCreds;
+
+typedef struct {
+   char *id;
+   char *datastoreMoRef;
+   char *ssId;
+} VixDiskLibVStorageObjectSpec;
+
+typedef struct VixDiskLibConnectParams {
+   char *vmxSpec;
+   char *serverName;
+   char *thumbPrint;
+   long  privateUse;
+   VixDiskLibCredType credType;
+   union {
+      VixDiskLibUidPasswdCreds uid;
+      VixDiskLibSessionIdCreds sessionId;
+      void *ticketId;
+   } creds;
+   uint32 port;
+   uint32 nfcHostPort;
+   char  *reserved1;
+   char   reserved2[8];
+   void  *reserved3;
+   VixDiskLibSpecType specType;
+   union {
+      VixDiskLibVStorageObjectSpec vStorageObjSpec;
+   } spec;
+} VixDiskLibConnectParams;
+
+typedef struct {
+   VixDiskLibSectorType offset;
+   VixDiskLibSectorType length;
+} VixDiskLibBlock;
+
+typedef struct {
+   uint32          numBlocks;
+   VixDiskLibBlock blocks[1];
+} VixDiskLibBlockList;
+
+typedef void (VixDiskLibGenericLogFunc)(const char *fmt, va_list args);
+typedef Bool (*VixDiskLibProgressFunc)(void *progressData, int percentCompleted);
+typedef void (*VixDiskLibCompletionCB)(void *cbData, VixError result);
+
+VixError VixDiskLib_Init(uint32 majorVersion, uint32 minorVersion,
+                         VixDiskLibGenericLogFunc *log, VixDiskLibGenericLogFunc *warn,
+                         VixDiskLibGenericLogFunc *panic, const char *libDir);
+VixError VixDiskLib_InitEx(uint32 majorVersion, uint32 minorVersion,
+                           VixDiskLibGenericLogFunc *log, VixDiskLibGenericLogFunc *warn,
+                           VixDiskLibGenericLogFunc *panic, const char *libDir,
+                           const char *configFile);
+void VixDiskLib_Exit(void);
+const char *VixDiskLib_ListTransportModes(void);
+
+VixDiskLibConnectParams *VixDiskLib_AllocateConnectParams(void);
+void VixDiskLib_FreeConnectParams(VixDiskLibConnectParams *connectParams);
+
+VixError VixDiskLib_Connect(const VixDiskLibConnectParams *connectParams,
+                            VixDiskLibConnection *connection);
+VixError VixDiskLib_ConnectEx(const VixDiskLibConnectParams *connectParams,
+                              Bool readOnly, const char *snapshotRef,
+                              const char *transportModes,
+                              VixDiskLibConnection *connection);
+VixError VixDiskLib_Disconnect(VixDiskLibConnection connection);
+VixError VixDiskLib_PrepareForAccess(const VixDiskLibConnectParams *connectParams,
+                                     const char *identity);
+VixError VixDiskLib_EndAccess(const VixDiskLibConnectParams *connectParams,
+                              const char *identity);
+VixError VixDiskLib_Cleanup(const VixDiskLibConnectParams *connectParams,
+                            uint32 *numCleanedUp, uint32 *numRemaining);
+
+VixError VixDiskLib_Open(const VixDiskLibConnection connection, const char *path,
+                         uint32 flags, VixDiskLibHandle *diskHandle);
+VixError VixDiskLib_Close(VixDiskLibHandle diskHandle);
+VixError VixDiskLib_GetInfo(VixDiskLibHandle diskHandle, VixDiskLibInfo **info);
+void VixDiskLib_FreeInfo(VixDiskLibInfo *info);
+const char *VixDiskLib_GetTransportMode(VixDiskLibHandle diskHandle);
+
+VixError VixDiskLib_Read(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector,
+                         VixDiskLibSectorType numSectors, uint8 *readBuffer);
+VixError VixDiskLib_Write(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector,
+                          VixDiskLibSectorType numSectors, const uint8 *writeBuffer);
+VixError VixDiskLib_ReadAsync(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector,
+                              VixDiskLibSectorType numSectors, uint8 *readBuffer,
+                              VixDiskLibCompletionCB callback, void *cbData);
+VixError VixDiskLib_WriteAsync(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector,
+                               VixDiskLibSectorType numSectors, const uint8 *writeBuffer,
+                               VixDiskLibCompletionCB callback, void *cbData);
+VixError VixDiskLib_Wait(VixDiskLibHandle diskHandle);
+VixError VixDiskLib_Flush(VixDiskLibHandle diskHandle);
+
+VixError VixDiskLib_ReadMetadata(VixDiskLibHandle diskHandle, const char *key,
+                                 char *buf, size_t bufLen, size_t *requiredLen);
+VixError VixDiskLib_WriteMetadata(VixDiskLibHandle diskHandle, const char *key,
+                                  const char *val);
+VixError VixDiskLib_GetMetadataKeys(VixDiskLibHandle diskHandle, char *keysBuffer,
+                                    size_t bufLen, size_t *requiredLen);
+
+VixError VixDiskLib_Create(const VixDiskLibConnection connection, const char *path,
+                           const VixDiskLibCreateParams *createParams,
+                           VixDiskLibProgressFunc progressFunc, void *progressCallbackData);
+VixError VixDiskLib_CreateChild(VixDiskLibHandle diskHandle, const char *childPath,
+                                VixDiskLibDiskType diskType,
+                                VixDiskLibProgressFunc progressFunc, void *progressCallbackData);
+VixError VixDiskLib_Clone(const VixDiskLibConnection dstConnection, const char *dstPath,
+                          const VixDiskLibConnection srcConnection, const char *srcPath,
+                          const VixDiskLibCreateParams *vixCreateParams,
+                          VixDiskLibProgressFunc progressFunc, void *progressCallbackData,
+                          Bool overWrite);
+VixError VixDiskLib_Grow(VixDiskLibConnection connection, const char *path,
+                         VixDiskLibSectorType capacity, Bool updateGeometry,
+                         VixDiskLibProgressFunc progressFunc, void *progressCallbackData);
+VixError VixDiskLib_Shrink(VixDiskLibHandle diskHandle,
+                           VixDiskLibProgressFunc progressFunc, void *progressCallbackData);
+VixError VixDiskLib_Defragment(VixDiskLibHandle diskHandle,
+                               VixDiskLibProgressFunc progressFunc, void *progressCallbackData);
+VixError VixDiskLib_Attach(VixDiskLibHandle parent, VixDiskLibHandle child);
+VixError VixDiskLib_Rename(const char *srcFileName, const char *dstFileName);
+VixError VixDiskLib_Unlink(VixDiskLibConnection connection, const char *path);
+VixError VixDiskLib_SpaceNeededForClone(VixDiskLibHandle diskHandle, VixDiskLibDiskType cloneDiskType,
+                                        uint64 *spaceNeeded);
+VixError VixDiskLib_CheckRepair(const VixDiskLibConnection connection, const char *filename,
+                                Bool repair);
+
+VixError VixDiskLib_QueryAllocatedBlocks(VixDiskLibHandle diskHandle,
+                                         VixDiskLibSectorType startSector,
+                                         VixDiskLibSectorType numSectors,
+                                         VixDiskLibSectorType chunkSize,
+                                         VixDiskLibBlockList **blockList);
+VixError VixDiskLib_FreeBlockList(VixDiskLibBlockList *blockList);
+
+char *VixDiskLib_GetErrorText(VixError err, const char *locale);
+void VixDiskLib_FreeErrorText(char *errMsg);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif /* _VIXDISKLIB_STUB_H_ */
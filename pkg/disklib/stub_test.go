@@ -0,0 +1,611 @@
+//go:build vddkstub
+
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disklib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const stubDiskSectors = 2048
+
+// initStub loads the library in LIBPATH and skips the test unless it is the stub of test/vddkstub, see
+// "make test". It returns the number of outstanding stub objects before the test.
+func initStub(t *testing.T) int64 {
+	libDir := os.Getenv("LIBPATH")
+	if libDir == "" {
+		t.Skip("Skipping testing if environment variables are not set.")
+	}
+	if err := Init(7, 0, libDir); err != nil {
+		t.Fatal(err)
+	}
+	baseline := stubOutstanding()
+	if baseline < 0 {
+		t.Skip("Skipping testing as LIBPATH does not point to the stub library.")
+	}
+	t.Cleanup(stubClearFaults)
+	return baseline
+}
+
+// openStub opens a fresh sparse image through the stub and returns its handle, which is closed at the
+// end of the test.
+func openStub(t *testing.T) (VixDiskLibHandle, string) {
+	path := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, stubDiskSectors*VIXDISKLIB_SECTOR_SIZE); err != nil {
+		t.Fatal(err)
+	}
+	return openStubFile(t, path), path
+}
+
+// openStubFile opens the image at path through the stub and returns its handle, which is closed at the
+// end of the test.
+func openStubFile(t *testing.T, path string) VixDiskLibHandle {
+	params, err := BuildConnectParams(WithPath(path), WithIdentity("stub_test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vErr := PrepareForAccess(params); vErr != nil {
+		t.Fatal(vErr)
+	}
+	conn, vErr := ConnectEx(params)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	dli, vErr := Open(conn, params)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	t.Cleanup(func() {
+		if vErr := Close(dli); vErr != nil {
+			t.Error(vErr)
+		}
+		if vErr := Disconnect(conn); vErr != nil {
+			t.Error(vErr)
+		}
+		if vErr := EndAccess(params); vErr != nil {
+			t.Error(vErr)
+		}
+	})
+	return dli
+}
+
+// checkOutstanding fails the test if the stub objects handed out during the test were not all freed.
+func checkOutstanding(t *testing.T, baseline int64) {
+	t.Cleanup(func() {
+		if n := stubOutstanding(); n != baseline {
+			t.Errorf("%d stub objects outstanding, expected %d", n, baseline)
+		}
+	})
+}
+
+func TestStubReadWrite(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	dli, _ := openStub(t)
+
+	info, vErr := GetInfo(dli)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	if info.Capacity != stubDiskSectors {
+		t.Errorf("capacity %d, expected %d", info.Capacity, stubDiskSectors)
+	}
+
+	data := bytes.Repeat([]byte("stub"), 2*VIXDISKLIB_SECTOR_SIZE/4)
+	if vErr := Write(dli, 3, 2, data); vErr != nil {
+		t.Fatal(vErr)
+	}
+	buf := make([]byte, 2*VIXDISKLIB_SECTOR_SIZE)
+	if vErr := Read(dli, 3, 2, buf); vErr != nil {
+		t.Fatal(vErr)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Error("read data differs from written data")
+	}
+	if vErr := Flush(dli); vErr != nil {
+		t.Fatal(vErr)
+	}
+
+	if vErr := WriteMetadata(dli, "uuid.b", "2"); vErr != nil {
+		t.Fatal(vErr)
+	}
+	if vErr := WriteMetadata(dli, "uuid.a", "1"); vErr != nil {
+		t.Fatal(vErr)
+	}
+	val, vErr := ReadMetadata(dli, "uuid.a")
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	if val != "1" {
+		t.Errorf("metadata uuid.a is %q, expected \"1\"", val)
+	}
+	keys, vErr := GetMetadataKeys(dli)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "uuid.a" || keys[1] != "uuid.b" {
+		t.Errorf("metadata keys %q, expected [uuid.a uuid.b]", keys)
+	}
+	if _, vErr := ReadMetadata(dli, "missing"); !errors.Is(vErr, ErrKeyNotFound) {
+		t.Errorf("reading a missing key returned %v, expected ErrKeyNotFound", vErr)
+	}
+}
+
+func TestStubQueryAllocatedBlocks(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	dli, _ := openStub(t)
+
+	// A single sector in the third chunk allocates that chunk only
+	chunk := VixDiskLibSectorType(VIXDISKLIB_MIN_CHUNK_SIZE)
+	data := bytes.Repeat([]byte{0xA5}, VIXDISKLIB_SECTOR_SIZE)
+	if vErr := Write(dli, uint64(2*chunk+5), 1, data); vErr != nil {
+		t.Fatal(vErr)
+	}
+	blocks, vErr := QueryAllocatedBlocks(dli, 0, stubDiskSectors, chunk)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	if len(blocks) != 1 || blocks[0].Offset() != 2*chunk || blocks[0].Length() != chunk {
+		t.Errorf("allocated blocks %v, expected one block at %d of length %d", blocks, 2*chunk, chunk)
+	}
+
+	if _, vErr := QueryAllocatedBlocks(dli, 1, stubDiskSectors-chunk, chunk); !errors.Is(vErr, ErrInvalidArg) {
+		t.Errorf("misaligned query returned %v, expected ErrInvalidArg", vErr)
+	}
+}
+
+func TestStubErrors(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	dli, path := openStub(t)
+	buf := make([]byte, VIXDISKLIB_SECTOR_SIZE)
+
+	if !stubInjectFault("Read", VIX_E_HOST_CONNECTION_LOST, 0, 1) {
+		t.Fatal("could not inject fault")
+	}
+	vErr := Read(dli, 0, 1, buf)
+	if !errors.Is(vErr, ErrHostConnectionLost) || !IsTransient(vErr) {
+		t.Fatalf("injected fault returned %v, expected transient ErrHostConnectionLost", vErr)
+	}
+	impl, ok := vErr.(*vddkErrorImpl)
+	if !ok {
+		t.Fatalf("unexpected error type %T", vErr)
+	}
+	if impl.Op() != "Read" || impl.ErrorText() == "" {
+		t.Errorf("error has op %q and text %q, expected op \"Read\" and the stub's text", impl.Op(), impl.ErrorText())
+	}
+	if vErr := Read(dli, 0, 1, buf); vErr != nil {
+		t.Errorf("read after the injected fault failed: %v", vErr)
+	}
+
+	if vErr := Read(dli, stubDiskSectors, 1, buf); !errors.Is(vErr, ErrOutOfRange) || IsTransient(vErr) {
+		t.Errorf("read past the end returned %v, expected permanent ErrOutOfRange", vErr)
+	}
+
+	// The buffer is checked before calling the library, so the injected fault is not hit
+	stubInjectFault("Read", VIX_E_HOST_CONNECTION_LOST, 0, -1)
+	if vErr := Read(dli, 0, 2, buf); !errors.Is(vErr, ErrInvalidArg) {
+		t.Errorf("read into a short buffer returned %v, expected ErrInvalidArg", vErr)
+	}
+	stubClearFaults()
+
+	params, err := BuildConnectParams(WithPath(filepath.Join(filepath.Dir(path), "missing.img")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, vErr := ConnectEx(params)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	defer Disconnect(conn)
+	if _, vErr := Open(conn, params); !errors.Is(vErr, ErrFileNotFound) {
+		t.Errorf("opening a missing file returned %v, expected ErrFileNotFound", vErr)
+	}
+}
+
+func TestStubConnectOpenClose(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	path := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(path, make([]byte, 16*VIXDISKLIB_SECTOR_SIZE), 0644); err != nil {
+		t.Fatal(err)
+	}
+	params, err := BuildConnectParams(WithPath(path), WithIdentity("stub_test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectOutstanding := func(step string, expected int64) {
+		t.Helper()
+		if n := stubOutstanding(); n != baseline+expected {
+			t.Fatalf("%s: %d stub objects outstanding, expected %d", step, n-baseline, expected)
+		}
+	}
+
+	for _, connect := range []func(ConnectParams) (VixDiskLibConnection, VddkError){Connect, ConnectEx} {
+		conn, vErr := connect(params)
+		if vErr != nil {
+			t.Fatal(vErr)
+		}
+		// The connect params are freed again, only the connection is left
+		expectOutstanding("connected", 1)
+		dli, vErr := Open(conn, params)
+		if vErr != nil {
+			t.Fatal(vErr)
+		}
+		expectOutstanding("opened", 2)
+		if vErr := Close(dli); vErr != nil {
+			t.Fatal(vErr)
+		}
+		expectOutstanding("closed", 1)
+		if vErr := Disconnect(conn); vErr != nil {
+			t.Fatal(vErr)
+		}
+		expectOutstanding("disconnected", 0)
+	}
+
+	// Failed calls free their connect params and error text and hand out nothing
+	stubInjectFault("ConnectEx", VIX_E_HOST_CONNECTION_LOST, 0, 1)
+	if _, vErr := ConnectEx(params); !errors.Is(vErr, ErrHostConnectionLost) {
+		t.Fatalf("ConnectEx returned %v, expected ErrHostConnectionLost", vErr)
+	}
+	expectOutstanding("failed ConnectEx", 0)
+
+	conn, vErr := ConnectEx(params)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	defer Disconnect(conn)
+	stubInjectFault("Open", VIX_E_FILE_NOT_FOUND, 0, 1)
+	if _, vErr := Open(conn, params); !errors.Is(vErr, ErrFileNotFound) {
+		t.Fatalf("Open returned %v, expected ErrFileNotFound", vErr)
+	}
+	expectOutstanding("failed Open", 1)
+
+	dli, vErr := Open(conn, params)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	stubInjectFault("Close", VIX_E_HOST_CONNECTION_LOST, 0, 1)
+	if vErr := Close(dli); !errors.Is(vErr, ErrHostConnectionLost) {
+		t.Fatalf("Close returned %v, expected ErrHostConnectionLost", vErr)
+	}
+	expectOutstanding("failed Close", 2)
+	if vErr := Close(dli); vErr != nil {
+		t.Fatal(vErr)
+	}
+	expectOutstanding("closed", 1)
+}
+
+func TestStubLoad(t *testing.T) {
+	initStub(t)
+	libDir := os.Getenv("LIBPATH")
+	if !Loaded() {
+		t.Fatal("Init did not load the library")
+	}
+	// Loading the same library again, by directory or by file, is a no-op
+	for _, path := range []string{libDir, filepath.Join(libDir, "lib64", libName)} {
+		if err := Load(path); err != nil {
+			t.Errorf("Load(%q) failed: %v", path, err)
+		}
+	}
+	if err := Load(t.TempDir()); !errors.Is(err, ErrObjectBusy) {
+		t.Errorf("loading a second library returned %v, expected ErrObjectBusy", err)
+	}
+
+	for name, has := range map[string]bool{
+		"VixDiskLib_Read":                 true,
+		"VixDiskLib_QueryAllocatedBlocks": true,
+		"VixDiskLib_Missing":              false,
+		"VixDiskLibStub_Outstanding":      false,
+	} {
+		if HasSymbol(name) != has {
+			t.Errorf("HasSymbol(%q) returned %v, expected %v", name, !has, has)
+		}
+	}
+}
+
+func TestStubCleanup(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	params, err := BuildConnectParams(WithPath(filepath.Join(t.TempDir(), "disk.img")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanedUp, remaining, vErr := Cleanup(params)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	if cleanedUp != 2 || remaining != 1 {
+		t.Errorf("Cleanup returned %d cleaned up and %d remaining, expected 2 and 1", cleanedUp, remaining)
+	}
+
+	stubInjectFault("Cleanup", VIX_E_HOST_CONNECTION_LOST, 0, 1)
+	cleanedUp, remaining, vErr = Cleanup(params)
+	if !errors.Is(vErr, ErrHostConnectionLost) || cleanedUp != 0 || remaining != 0 {
+		t.Errorf("failed Cleanup returned %d, %d, %v", cleanedUp, remaining, vErr)
+	}
+}
+
+// Create and Clone pass the create params to VDDK, which reports the adapter type through GetInfo and the
+// hardware version in the virtualHWVersion metadata entry.
+func TestStubCreateGetInfo(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	dir := t.TempDir()
+	params, err := BuildConnectParams(WithPath(dir), WithIdentity("stub_test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, vErr := ConnectEx(params)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	t.Cleanup(func() {
+		if vErr := Disconnect(conn); vErr != nil {
+			t.Error(vErr)
+		}
+	})
+	tests := []struct {
+		name         string
+		createParams VixDiskLibCreateParams
+		clone        string // the disk to clone instead of creating a new one, of the same capacity
+	}{
+		{"ide", NewCreateParams(VIXDISKLIB_DISK_MONOLITHIC_SPARSE, VIXDISKLIB_ADAPTER_IDE, 4, 64), ""},
+		{"buslogic", NewCreateParams(VIXDISKLIB_DISK_MONOLITHIC_FLAT, VIXDISKLIB_ADAPTER_SCSI_BUSLOGIC, 7, 128), ""},
+		{"lsilogic", NewCreateParams(VIXDISKLIB_DISK_SPLIT_SPARSE, VIXDISKLIB_ADAPTER_SCSI_LSILOGIC, 14, 4096), ""},
+		{"clone", NewCreateParams(VIXDISKLIB_DISK_MONOLITHIC_SPARSE, VIXDISKLIB_ADAPTER_IDE, 10, 128), "buslogic"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, test.name+".vmdk")
+			if test.clone != "" {
+				vErr = Clone(context.Background(), conn, path, conn, filepath.Join(dir, test.clone+".vmdk"), test.createParams, nil, false)
+			} else {
+				vErr = Create(context.Background(), conn, path, test.createParams, nil)
+			}
+			if vErr != nil {
+				t.Fatal(vErr)
+			}
+			dli := openStubFile(t, path)
+			info, vErr := GetInfo(dli)
+			if vErr != nil {
+				t.Fatal(vErr)
+			}
+			if info.Capacity != test.createParams.capacity {
+				t.Errorf("capacity %d, expected %d", info.Capacity, test.createParams.capacity)
+			}
+			if info.AdapterType != test.createParams.adapterType {
+				t.Errorf("adapter type %d, expected %d", info.AdapterType, test.createParams.adapterType)
+			}
+			hwVersion, vErr := ReadMetadata(dli, "virtualHWVersion")
+			if vErr != nil {
+				t.Fatal(vErr)
+			}
+			if expected := strconv.Itoa(int(test.createParams.hwVersion)); hwVersion != expected {
+				t.Errorf("virtualHWVersion is %q, expected %q", hwVersion, expected)
+			}
+		})
+	}
+}
+
+// GetInfo frees the info VDDK allocates every time.
+func TestStubGetInfoRepeated(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	dli, _ := openStub(t)
+	open := stubOutstanding()
+	for i := 0; i < 100; i++ {
+		if _, vErr := GetInfo(dli); vErr != nil {
+			t.Fatal(vErr)
+		}
+		if n := stubOutstanding(); n != open {
+			t.Fatalf("%d stub objects outstanding after %d GetInfo calls, expected %d", n, i+1, open)
+		}
+	}
+}
+
+func TestStubSpaceNeededForClone(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	dli, _ := openStub(t)
+
+	space, vErr := SpaceNeededForClone(dli, VIXDISKLIB_DISK_MONOLITHIC_SPARSE)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	if space != stubDiskSectors*VIXDISKLIB_SECTOR_SIZE {
+		t.Errorf("SpaceNeededForClone returned %d, expected %d", space, stubDiskSectors*VIXDISKLIB_SECTOR_SIZE)
+	}
+
+	stubInjectFault("SpaceNeededForClone", VIX_E_NOT_SUPPORTED, 0, 1)
+	if space, vErr := SpaceNeededForClone(dli, VIXDISKLIB_DISK_MONOLITHIC_SPARSE); !errors.Is(vErr, ErrNotSupported) || space != 0 {
+		t.Errorf("failed SpaceNeededForClone returned %d, %v", space, vErr)
+	}
+}
+
+func TestStubMetadata(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	dli, _ := openStub(t)
+
+	keys, vErr := GetMetadataKeys(dli)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	if keys == nil || len(keys) != 0 {
+		t.Errorf("GetMetadataKeys of a disk without metadata returned %#v, expected an empty list", keys)
+	}
+
+	// Both calls size their buffer from the length VDDK reports, so long values and many keys fit
+	long := strings.Repeat("v", 5000)
+	values := map[string]string{"empty": "", "long": long}
+	for i := 0; i < 20; i++ {
+		values[fmt.Sprintf("key.%02d", i)] = fmt.Sprint(i)
+	}
+	for key, val := range values {
+		if vErr := WriteMetadata(dli, key, val); vErr != nil {
+			t.Fatal(vErr)
+		}
+	}
+	for key, expected := range values {
+		val, vErr := ReadMetadata(dli, key)
+		if vErr != nil {
+			t.Fatal(vErr)
+		}
+		if val != expected {
+			t.Errorf("metadata %s has %d bytes %.20q, expected %d bytes %.20q", key, len(val), val, len(expected), expected)
+		}
+	}
+	keys, vErr = GetMetadataKeys(dli)
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	sort.Strings(keys)
+	var expected []string
+	for key := range values {
+		expected = append(expected, key)
+	}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("GetMetadataKeys returned %q, expected %q", keys, expected)
+	}
+
+	// A failure of the second call, which fetches the data, is returned as well
+	stubInjectFault("ReadMetadata", VIX_E_HOST_CONNECTION_LOST, 1, 1)
+	if val, vErr := ReadMetadata(dli, "long"); !errors.Is(vErr, ErrHostConnectionLost) || val != "" {
+		t.Errorf("ReadMetadata failing on the second call returned %.20q, %v", val, vErr)
+	}
+	stubInjectFault("GetMetadataKeys", VIX_E_HOST_CONNECTION_LOST, 1, 1)
+	if keys, vErr := GetMetadataKeys(dli); !errors.Is(vErr, ErrHostConnectionLost) || keys != nil {
+		t.Errorf("GetMetadataKeys failing on the second call returned %q, %v", keys, vErr)
+	}
+}
+
+func TestStubSectorSizes(t *testing.T) {
+	baseline := initStub(t)
+	checkOutstanding(t, baseline)
+	t.Cleanup(func() {
+		stubSetSectorSizes(VIXDISKLIB_SECTOR_SIZE, VIXDISKLIB_SECTOR_SIZE)
+	})
+	// Only VDDK 8 and later report the sector sizes and support 4Kn disks, the v7 stub keeps 512 byte sectors
+	major := LibraryMajorVersion()
+	tests := []struct {
+		name              string
+		logical, physical uint32
+	}{
+		{"512n", 512, 512},
+		{"512e", 512, 4096},
+		{"4Kn", 4096, 4096},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !stubSetSectorSizes(test.logical, test.physical) {
+				t.Fatal("the stub does not provide VixDiskLibStub_SetSectorSizes")
+			}
+			logical, physical := test.logical, test.physical
+			if major < 8 {
+				logical, physical = VIXDISKLIB_SECTOR_SIZE, VIXDISKLIB_SECTOR_SIZE
+			}
+			dli, _ := openStub(t)
+			info, vErr := GetInfo(dli)
+			if vErr != nil {
+				t.Fatal(vErr)
+			}
+			if info.LogicalSectorSize != logical || info.PhysicalSectorSize != physical {
+				t.Errorf("GetInfo with VDDK %d reported sector sizes %d/%d, expected %d/%d", major, info.LogicalSectorSize, info.PhysicalSectorSize, logical, physical)
+			}
+			if info.Capacity != stubDiskSectors {
+				t.Errorf("capacity %d, expected %d", info.Capacity, stubDiskSectors)
+			}
+
+			// Capacity and I/O stay in 512 byte units, but must be aligned to the logical sector size
+			perSector := uint64(logical / VIXDISKLIB_SECTOR_SIZE)
+			buf := make([]byte, 2*logical)
+			if vErr := Read(dli, perSector, 2*perSector, buf); vErr != nil {
+				t.Errorf("aligned read failed: %v", vErr)
+			}
+			if perSector > 1 {
+				if vErr := Read(dli, 1, perSector, buf); !errors.Is(vErr, ErrInvalidArg) {
+					t.Errorf("misaligned read returned %v, expected ErrInvalidArg", vErr)
+				}
+			}
+		})
+	}
+}
+
+func TestStubLibraryMajorVersion(t *testing.T) {
+	initStub(t)
+	// make test runs the stub tests against the 8.0.0 stub and the 7.0.0 stub in v7
+	expected := 8
+	if filepath.Base(os.Getenv("LIBPATH")) == "v7" {
+		expected = 7
+	}
+	if major := LibraryMajorVersion(); major != expected {
+		t.Errorf("LibraryMajorVersion returned %d, expected %d", major, expected)
+	}
+}
+
+func TestStubGetInfoDiskType(t *testing.T) {
+	baseline := initStub(t)
+	dir := t.TempDir()
+	descriptor := filepath.Join(dir, "disk.vmdk")
+	extent := filepath.Join(dir, "disk-flat.vmdk")
+	// The stub opens the descriptor as a raw image, only its type matters here
+	text := "# Disk DescriptorFile\nversion=1\nCID=fffffffe\nparentCID=ffffffff\ncreateType=\"monolithicFlat\"\n\n" +
+		"# Extent description\nRW 2048 FLAT \"disk-flat.vmdk\" 0\n"
+	if err := os.WriteFile(descriptor, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(extent, make([]byte, 2048*VIXDISKLIB_SECTOR_SIZE), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		diskType VixDiskLibDiskType
+	}{
+		{descriptor, VIXDISKLIB_DISK_MONOLITHIC_FLAT},
+		{extent, VIXDISKLIB_DISK_UNKNOWN},
+	}
+	for _, test := range tests {
+		t.Run(filepath.Base(test.path), func(t *testing.T) {
+			info, vErr := GetInfo(openStubFile(t, test.path))
+			if vErr != nil {
+				t.Fatal(vErr)
+			}
+			if info.DiskType != test.diskType {
+				t.Errorf("GetInfo reported disk type %d, expected %d", info.DiskType, test.diskType)
+			}
+		})
+	}
+	checkOutstanding(t, baseline)
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/virtual-disks/pkg/disklib"
+)
+
+// Backend is the storage under a Disk. The Disk turns byte offsets into sector aligned calls, so a backend only
+// sees whole sectors of VIXDISKLIB_SECTOR_SIZE bytes within the capacity reported by Info. Calls may be made
+// concurrently, except that Close is never called while another call is running. Errors are returned to the
+// caller of the Disk unchanged; backends may return disklib.VddkErrors so that callers can match them with
+// the disklib sentinel errors and RetryPolicy can classify them.
+type Backend interface {
+	// ReadSectors reads numSectors sectors at startSector into buf.
+	ReadSectors(startSector uint64, numSectors uint64, buf []byte) error
+	// WriteSectors writes numSectors sectors from buf at startSector.
+	WriteSectors(startSector uint64, numSectors uint64, buf []byte) error
+	// Info returns the geometry of the disk. Only Capacity and LogicalSectorSize are used by the Disk.
+	Info() (disklib.VixDiskLibInfo, error)
+	// QueryAllocatedBlocks returns the allocated chunks of chunkSize sectors in the given range, like
+	// disklib.QueryAllocatedBlocks. Backends without allocation tracking return disklib.ErrNotSupported.
+	QueryAllocatedBlocks(startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, error)
+	ReadMetadata(key string) (string, error)
+	WriteMetadata(key string, val string) error
+	GetMetadataKeys() ([]string, error)
+	// ReadOnly reports whether writes are refused, so that the Disk can reject them before calling the backend.
+	ReadOnly() bool
+	// Flush makes all completed writes durable.
+	Flush() error
+	// Close releases the backend. It is called once.
+	Close() error
+}
+
+// AsyncBackend is implemented by backends supporting asynchronous I/O, see Disk.ReadAsync.
+type AsyncBackend interface {
+	Backend
+	ReadAsync(startSector uint64, numSectors uint64, buf []byte) (*disklib.AsyncOp, error)
+	WriteAsync(startSector uint64, numSectors uint64, buf []byte) (*disklib.AsyncOp, error)
+	// Wait blocks until all asynchronous operations have completed.
+	Wait() error
+}
+
+// Reopener is implemented by backends that can replace a lost connection. RetryPolicy reopens them before
+// retrying a call that failed with one of its ReconnectErrors. Reopen is never called concurrently with other
+// calls.
+type Reopener interface {
+	Reopen() error
+}
+
+// errAsyncNotSupported is returned for asynchronous I/O on a backend that is not an AsyncBackend.
+var errAsyncNotSupported = newDiskError(disklib.VIX_E_NOT_SUPPORTED, "The disk backend does not support asynchronous I/O", nil)
+
+// NewDisk returns a Disk reading and writing backend, configured by opts. The Disk owns the backend and closes
+// it on Close, but not if NewDisk fails.
+func NewDisk(backend Backend, opts ...DiskOption) (*Disk, error) {
+	info, err := backend.Info()
+	if err != nil {
+		return nil, err
+	}
+	disk := newDisk(backend, info, disklib.ConnectParams{})
+	disk.configure(opts)
+	return disk, nil
+}
+
+// OpenBackend returns a DiskReaderWriter for backend, see NewDisk.
+func OpenBackend(backend Backend, logger logrus.FieldLogger, opts ...DiskOption) (DiskReaderWriter, error) {
+	disk, err := NewDisk(backend, opts...)
+	if err != nil {
+		return DiskReaderWriter{}, err
+	}
+	return NewDiskReaderWriter(DiskConnectHandle{disk}, logger), nil
+}
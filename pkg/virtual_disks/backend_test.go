@@ -0,0 +1,314 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/virtual-disks/pkg/conformance"
+	"github.com/vmware/virtual-disks/pkg/disklib"
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+// newMemBackend returns a MemBackend of numSectors 512 byte sectors.
+func newMemBackend(numSectors int) *conformance.MemBackend {
+	return conformance.NewMemBackend(numSectors*disklib.VIXDISKLIB_SECTOR_SIZE, disklib.VIXDISKLIB_SECTOR_SIZE)
+}
+
+func TestBackendReadWrite(t *testing.T) {
+	backend := newMemBackend(16)
+	diskReaderWriter, err := virtual_disks.OpenBackend(backend, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskReaderWriter.Close()
+
+	// Misaligned at both ends
+	if _, err := diskReaderWriter.WriteAt([]byte("hello, world"), 510); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(backend.Bytes()[510:522], []byte("hello, world")) {
+		t.Fatalf("backend holds %q", backend.Bytes()[510:522])
+	}
+	if _, err := diskReaderWriter.Seek(507, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	if n, err := io.ReadFull(diskReaderWriter, buf); err != nil || !bytes.Equal(buf[:n], []byte("\x00\x00\x00hello, ")) {
+		t.Fatalf("Read returned %q, %v", buf[:n], err)
+	}
+	// Reads are cut at the end of the disk
+	if n, err := diskReaderWriter.ReadAt(buf, 16*512-4); n != 4 || err != nil {
+		t.Fatalf("ReadAt at the end returned %d, %v", n, err)
+	}
+	if _, err := diskReaderWriter.WriteAt(buf, 16*512-4); err != io.ErrShortWrite {
+		t.Fatalf("WriteAt beyond the end returned %v", err)
+	}
+
+	disk := diskReaderWriter.Disk()
+	if err := disk.WriteMetadata("uuid", "42"); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := disk.ReadMetadata("uuid"); err != nil || val != "42" {
+		t.Fatalf("ReadMetadata returned %q, %v", val, err)
+	}
+	if _, err := disk.ReadAsync(make([]byte, 512), 0); !errors.Is(err, disklib.ErrNotSupported) {
+		t.Fatalf("ReadAsync returned %v, expected %v", err, disklib.ErrNotSupported)
+	}
+	// Without allocation tracking the whole disk is data
+	if off, err := disk.SeekHole(0); off != 16*512 || err != nil {
+		t.Fatalf("SeekHole returned %d, %v", off, err)
+	}
+}
+
+func TestBackendReadOnly(t *testing.T) {
+	backend := newMemBackend(4)
+	backend.SetReadOnly(true)
+	disk, err := virtual_disks.NewDisk(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := disk.WriteAt(make([]byte, 512), 0); err != virtual_disks.ErrReadOnly {
+		t.Fatalf("WriteAt returned %v, expected %v", err, virtual_disks.ErrReadOnly)
+	}
+	if err := disk.WriteMetadata("key", "val"); err != virtual_disks.ErrReadOnly {
+		t.Fatalf("WriteMetadata returned %v, expected %v", err, virtual_disks.ErrReadOnly)
+	}
+	if backend.Calls() != 0 {
+		t.Fatalf("%d calls reached the backend", backend.Calls())
+	}
+	if err := disk.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := disk.ReadAt(make([]byte, 512), 0); !errors.Is(err, virtual_disks.ErrClosed) {
+		t.Fatalf("ReadAt after Close returned %v", err)
+	}
+}
+
+// ErrClosed and ErrReadOnly are only matched by identity or cause, not by the VIX error code they carry.
+func TestBackendErrorsIs(t *testing.T) {
+	injector := virtual_disks.NewFaultInjector(1, virtual_disks.FaultRule{Ops: virtual_disks.FaultRead, Err: disklib.NewVddkError(disklib.VIX_E_FAIL, "backend failed")})
+	disk, err := virtual_disks.NewDisk(virtual_disks.NewFaultBackend(newMemBackend(4), injector))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	_, err = disk.ReadAt(make([]byte, 512), 0)
+	if err == nil || errors.Is(err, virtual_disks.ErrClosed) || errors.Is(err, os.ErrClosed) {
+		t.Errorf("VIX_E_FAIL from the backend returned %v, which matches ErrClosed", err)
+	}
+	tests := []struct {
+		err    error
+		target error
+		is     bool
+	}{
+		{virtual_disks.ErrClosed, virtual_disks.ErrClosed, true},
+		{fmt.Errorf("read: %w", virtual_disks.ErrClosed), os.ErrClosed, true},
+		{virtual_disks.ErrClosed, disklib.ErrFailed, false},
+		{disklib.ErrFailed, virtual_disks.ErrClosed, false},
+		{virtual_disks.ErrReadOnly, os.ErrPermission, true},
+		{virtual_disks.ErrReadOnly, disklib.ErrReadOnly, false},
+		{disklib.ErrReadOnly, virtual_disks.ErrReadOnly, false},
+		{virtual_disks.ErrAsyncNotSupported, disklib.ErrNotSupported, true},
+	}
+	for _, test := range tests {
+		if is := errors.Is(test.err, test.target); is != test.is {
+			t.Errorf("errors.Is(%v, %v) returned %v, expected %v", test.err, test.target, is, test.is)
+		}
+	}
+}
+
+// A lost connection is replaced through Reopener before the call is retried.
+type reopeningBackend struct {
+	*conformance.MemBackend
+	lost    bool
+	reopens int
+}
+
+func (this *reopeningBackend) ReadSectors(startSector uint64, numSectors uint64, buf []byte) error {
+	if this.lost {
+		return disklib.ErrHostConnectionLost
+	}
+	return this.MemBackend.ReadSectors(startSector, numSectors, buf)
+}
+
+func (this *reopeningBackend) Reopen() error {
+	this.lost = false
+	this.reopens++
+	return nil
+}
+
+func TestBackendReopen(t *testing.T) {
+	backend := &reopeningBackend{MemBackend: newMemBackend(4), lost: true}
+	disk, err := virtual_disks.NewDisk(backend, virtual_disks.WithRetryPolicy(virtual_disks.RetryPolicy{MaxAttempts: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	if _, err := disk.ReadAt(make([]byte, 512), 0); err != nil || backend.reopens != 1 {
+		t.Fatalf("ReadAt returned %v after %d reopens", err, backend.reopens)
+	}
+}
+
+// A write running past the end of the disk must not take the data gathered before it down with it.
+func TestBackendWriteBackBeyondEnd(t *testing.T) {
+	backend := newMemBackend(4)
+	diskReaderWriter, err := virtual_disks.OpenBackend(backend, logrus.New(), virtual_disks.WithWriteBack(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskReaderWriter.Close()
+	if _, err := diskReaderWriter.Seek(4*512-10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := diskReaderWriter.Write([]byte("gathered")); n != 8 || err != nil {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	if n, err := diskReaderWriter.Write([]byte("too far")); n != 0 || err != io.ErrShortWrite {
+		t.Fatalf("Write beyond the end returned %d, %v", n, err)
+	}
+	if err := diskReaderWriter.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(backend.Bytes()[4*512-10:4*512-2], []byte("gathered")) {
+		t.Fatalf("backend holds %q", backend.Bytes()[4*512-10:4*512-2])
+	}
+}
+
+// Sync reports a failing backend Flush, and neither it nor a failing write of the buffered data drops that data.
+func TestBackendSyncFailedFlush(t *testing.T) {
+	backend := newMemBackend(8)
+	injector := virtual_disks.NewFaultInjector(1)
+	diskReaderWriter, err := virtual_disks.OpenBackend(backend, logrus.New(), virtual_disks.WithWriteBack(4096),
+		virtual_disks.WithFaults(injector))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskReaderWriter.Close()
+	pending := bytes.Repeat([]byte{1}, 600)
+	if n, err := diskReaderWriter.Write(pending); n != len(pending) || err != nil {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	injector.AddRules(virtual_disks.FaultRule{Ops: virtual_disks.FaultWrite, Count: 1, Err: disklib.ErrDiskFull})
+	if err := diskReaderWriter.Sync(); !errors.Is(err, disklib.ErrDiskFull) {
+		t.Fatalf("Sync with a failing write returned %v, expected %v", err, disklib.ErrDiskFull)
+	}
+	if !bytes.Equal(backend.Bytes()[:600], make([]byte, 600)) {
+		t.Fatal("backend holds data of the failed write")
+	}
+	injector.AddRules(virtual_disks.FaultRule{Ops: virtual_disks.FaultFlush, Count: 1, Err: disklib.ErrDiskFull})
+	if err := diskReaderWriter.Sync(); !errors.Is(err, disklib.ErrDiskFull) {
+		t.Fatalf("Sync with a failing Flush returned %v, expected %v", err, disklib.ErrDiskFull)
+	}
+	if !bytes.Equal(backend.Bytes()[:600], pending) {
+		t.Fatal("backend does not hold the buffered data after the failed Flush")
+	}
+	buf := make([]byte, 600)
+	if _, err := diskReaderWriter.ReadAt(buf, 0); err != nil || !bytes.Equal(buf, pending) {
+		t.Fatalf("ReadAt after the failed Flush returned %v, %v", buf, err)
+	}
+	if err := diskReaderWriter.Sync(); err != nil {
+		t.Fatalf("Sync after the faults returned %v", err)
+	}
+}
+
+// Data buffered before a failed flush is written by the next one, the data of the failed Write is not.
+func TestBackendWriteBackFailedFlush(t *testing.T) {
+	backend := newMemBackend(8)
+	injector := virtual_disks.NewFaultInjector(1)
+	diskReaderWriter, err := virtual_disks.OpenBackend(backend, logrus.New(), virtual_disks.WithWriteBack(1024),
+		virtual_disks.WithFaults(injector))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskReaderWriter.Close()
+	pending := bytes.Repeat([]byte{1}, 600)
+	if n, err := diskReaderWriter.Write(pending); n != len(pending) || err != nil {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	injector.AddRules(virtual_disks.FaultRule{Ops: virtual_disks.FaultWrite, Count: 1, Err: disklib.ErrDiskFull})
+	if n, err := diskReaderWriter.Write(bytes.Repeat([]byte{2}, 600)); n != 0 || !errors.Is(err, disklib.ErrDiskFull) {
+		t.Fatalf("Write with a failing flush returned %d, %v", n, err)
+	}
+	if err := diskReaderWriter.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(backend.Bytes()[:600], pending) || !bytes.Equal(backend.Bytes()[600:1200], make([]byte, 600)) {
+		t.Fatal("backend does not hold exactly the data written before the failed flush")
+	}
+
+	// The same holds for the flush before a write that does not follow the pending data
+	if _, err := diskReaderWriter.WriteAt(make([]byte, 1200), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diskReaderWriter.Seek(100, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diskReaderWriter.Write(pending[:10]); err != nil {
+		t.Fatal(err)
+	}
+	injector.AddRules(virtual_disks.FaultRule{Ops: virtual_disks.FaultWrite, Count: 1, Err: disklib.ErrDiskFull})
+	if _, err := diskReaderWriter.Seek(0, io.SeekStart); !errors.Is(err, disklib.ErrDiskFull) {
+		t.Fatalf("Seek with a failing flush returned %v", err)
+	}
+	buf := make([]byte, 10)
+	if _, err := diskReaderWriter.ReadAt(buf, 100); err != nil || !bytes.Equal(buf, pending[:10]) {
+		t.Fatalf("ReadAt after the failed flush returned %v, %v", buf, err)
+	}
+}
+
+// A write-back buffer smaller than a sector is enlarged to one, so that small writes are still gathered.
+func TestBackendWriteBackSmallBuffer(t *testing.T) {
+	backend := newMemBackend(4)
+	diskReaderWriter, err := virtual_disks.OpenBackend(backend, logrus.New(), virtual_disks.WithWriteBack(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskReaderWriter.Close()
+	for i := 0; i < 8; i++ {
+		if _, err := diskReaderWriter.Write(make([]byte, 64)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := backend.Calls(); calls != 1 {
+		t.Fatalf("8 writes of 64 bytes made %d backend calls, expected 1", calls)
+	}
+}
+
+// Read is cut at the end of the disk like ReadAt, also when the data was prefetched.
+func TestBackendReadAheadAtEnd(t *testing.T) {
+	diskReaderWriter, err := virtual_disks.OpenBackend(newMemBackend(8), logrus.New(), virtual_disks.WithReadAhead(1024, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskReaderWriter.Close()
+	buf := make([]byte, 3*512)
+	for _, expected := range []int{3 * 512, 3 * 512, 2 * 512} {
+		if n, err := diskReaderWriter.Read(buf); n != expected || err != nil {
+			t.Fatalf("Read returned %d, %v, expected %d bytes", n, err, expected)
+		}
+	}
+	if n, err := diskReaderWriter.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Read at the end returned %d, %v", n, err)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// blockCache is a bounded LRU cache of disk blocks, shared by all users of a Disk. Blocks are
+// filled by reads that cover them partially and dropped when they are written. Fills and invalidations of a
+// block are ordered by the range locks, which cover whole blocks when the cache is enabled.
+type blockCache struct {
+	mutex     sync.Mutex
+	blockSize int64
+	numBlocks int
+	blocks    map[int64]*list.Element // block index to element of lru
+	lru       *list.List              // *cachedBlock, most recently used first
+}
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+func newBlockCache(blockSize int64, numBlocks int) *blockCache {
+	return &blockCache{
+		blockSize: blockSize,
+		numBlocks: numBlocks,
+		blocks:    make(map[int64]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// get returns the data of block index, or nil if it is not cached. The data must not be modified.
+func (this *blockCache) get(index int64) []byte {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	element, ok := this.blocks[index]
+	if !ok {
+		return nil
+	}
+	this.lru.MoveToFront(element)
+	return element.Value.(*cachedBlock).data
+}
+
+func (this *blockCache) contains(index int64) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	_, ok := this.blocks[index]
+	return ok
+}
+
+// put caches data as block index, evicting the least recently used block if the cache is full.
+func (this *blockCache) put(index int64, data []byte) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if element, ok := this.blocks[index]; ok {
+		element.Value.(*cachedBlock).data = data
+		this.lru.MoveToFront(element)
+		return
+	}
+	if this.lru.Len() >= this.numBlocks {
+		oldest := this.lru.Back()
+		this.lru.Remove(oldest)
+		delete(this.blocks, oldest.Value.(*cachedBlock).index)
+	}
+	this.blocks[index] = this.lru.PushFront(&cachedBlock{index: index, data: data})
+}
+
+// invalidate drops the blocks overlapping length bytes at off.
+func (this *blockCache) invalidate(off int64, length int) {
+	if length <= 0 {
+		return
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for index := off / this.blockSize; index <= (off+int64(length)-1)/this.blockSize; index++ {
+		if element, ok := this.blocks[index]; ok {
+			this.lru.Remove(element)
+			delete(this.blocks, index)
+		}
+	}
+}
+
+// blockLength returns the length of block index, which is shorter than the block size at the end of the disk.
+func (this *Disk) blockLength(index int64) int64 {
+	length := this.Capacity() - index*this.cache.blockSize
+	if length > this.cache.blockSize {
+		return this.cache.blockSize
+	}
+	return length
+}
+
+// readCached reads len(p) bytes at off through the cache, with the blocks involved locked. Blocks covered
+// partially are read whole and cached, runs of uncached blocks covered whole are read directly, so large
+// reads do not evict the small ones the cache is for.
+func (this *Disk) readCached(ctx context.Context, p []byte, off int64) (int, error) {
+	total := 0
+	for total < len(p) {
+		pos := off + int64(total)
+		index := pos / this.cache.blockSize
+		blockStart := index * this.cache.blockSize
+		length := int(this.blockLength(index) - (pos - blockStart))
+		if length > len(p)-total {
+			length = len(p) - total
+		}
+		if data := this.cache.get(index); data != nil {
+			total += copy(p[total:total+length], data[pos-blockStart:])
+			continue
+		}
+		if pos == blockStart && int64(length) == this.blockLength(index) {
+			end := total + length
+			for next := index + 1; next*this.cache.blockSize < this.Capacity(); next++ {
+				nextLength := int(this.blockLength(next))
+				if end+nextLength > len(p) || this.cache.contains(next) {
+					break
+				}
+				end += nextLength
+			}
+			bytesRead, err := this.readSectors(ctx, p[total:end], pos)
+			total += bytesRead
+			if err != nil {
+				return total, err
+			}
+			continue
+		}
+		data := make([]byte, this.blockLength(index))
+		if _, err := this.readSectors(ctx, data, blockStart); err != nil {
+			return total, err
+		}
+		this.cache.put(index, data)
+		total += copy(p[total:total+length], data[pos-blockStart:])
+	}
+	return total, nil
+}
+
+// readSector reads the sector at off for a read/modify/write cycle, from the cache if its block is cached.
+func (this *Disk) readSector(ctx context.Context, buf []byte, off int64) error {
+	if this.cache != nil {
+		index := off / this.cache.blockSize
+		if data := this.cache.get(index); data != nil {
+			copy(buf, data[off-index*this.cache.blockSize:])
+			return nil
+		}
+	}
+	_, err := this.readSectors(ctx, buf, off)
+	return err
+}
@@ -0,0 +1,53 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import "testing"
+
+func TestBlockCacheEviction(t *testing.T) {
+	cache := newBlockCache(512, 2)
+	cache.put(0, []byte{0})
+	cache.put(1, []byte{1})
+	if cache.get(0) == nil {
+		t.Fatal("block 0 missing")
+	}
+	// Block 1 is now the least recently used one
+	cache.put(2, []byte{2})
+	if cache.contains(1) || !cache.contains(0) || !cache.contains(2) {
+		t.Fatal("expected block 1 to be evicted")
+	}
+	cache.put(2, []byte{3})
+	if data := cache.get(2); len(data) != 1 || data[0] != 3 {
+		t.Fatalf("block 2 is %v, expected [3]", data)
+	}
+}
+
+func TestBlockCacheInvalidate(t *testing.T) {
+	cache := newBlockCache(512, 4)
+	for index := int64(0); index < 4; index++ {
+		cache.put(index, []byte{byte(index)})
+	}
+	// Touches the last byte of block 1 and the first of block 2
+	cache.invalidate(1023, 2)
+	if !cache.contains(0) || cache.contains(1) || cache.contains(2) || !cache.contains(3) {
+		t.Fatal("expected exactly blocks 1 and 2 to be dropped")
+	}
+	cache.invalidate(0, 0)
+	if !cache.contains(0) {
+		t.Fatal("an empty write must not drop blocks")
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"errors"
+	"os"
+
+	"github.com/vmware/virtual-disks/pkg/disklib"
+)
+
+// Errors detected before a call reaches VDDK. errors.Is matches them by identity, and ErrClosed also as
+// os.ErrClosed and ErrReadOnly as os.ErrPermission, but not by VIX error code: a VIX_E_FAIL from VDDK does
+// not mean that the disk was closed.
+var (
+	ErrClosed   disklib.VddkError = &diskError{errCode: disklib.VIX_E_FAIL, msg: "The disk is closed", cause: os.ErrClosed, sentinel: true}
+	ErrReadOnly disklib.VddkError = &diskError{errCode: disklib.VIX_E_FILE_READ_ONLY, msg: "The disk is opened read only", cause: os.ErrPermission, sentinel: true}
+)
+
+// diskError is a VddkError raised by this package rather than by VDDK.
+type diskError struct {
+	errCode  uint64
+	msg      string
+	cause    error
+	sentinel bool // ErrClosed or ErrReadOnly, which do not match by code
+}
+
+func newDiskError(errCode uint64, msg string, cause error) disklib.VddkError {
+	return &diskError{
+		errCode: errCode,
+		msg:     msg,
+		cause:   cause,
+	}
+}
+
+func (this *diskError) Error() string {
+	return this.msg
+}
+
+func (this *diskError) VixErrorCode() uint64 {
+	return this.errCode
+}
+
+// Is matches the disklib sentinel of the same VIX error code, like the errors returned by disklib.
+func (this *diskError) Is(target error) bool {
+	return !this.sentinel && errors.Is(disklib.NewVddkError(this.errCode, this.msg), target)
+}
+
+func (this *diskError) Unwrap() error {
+	return this.cause
+}
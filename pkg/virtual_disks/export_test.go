@@ -0,0 +1,29 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import "time"
+
+// Unexported identifiers used by the tests of package virtual_disks_test, which cannot be part of this
+// package because they build on pkg/conformance.
+
+var ErrAsyncNotSupported = errAsyncNotSupported
+
+// NewFaultInjectorWithSleep returns an injector that delays calls with sleep instead of time.Sleep.
+func NewFaultInjectorWithSleep(seed int64, sleep func(time.Duration), rules ...FaultRule) *FaultInjector {
+	return newFaultInjector(seed, sleep, rules...)
+}
@@ -0,0 +1,400 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/vmware/virtual-disks/pkg/disklib"
+)
+
+// FaultOp selects the backend calls a FaultRule applies to. Values can be combined with |.
+type FaultOp uint32
+
+const (
+	FaultRead FaultOp = 1 << iota
+	FaultWrite
+	FaultQueryAllocatedBlocks
+	FaultMetadata // ReadMetadata, WriteMetadata and GetMetadataKeys
+	FaultFlush
+	FaultReopen
+
+	FaultAllOps = FaultRead | FaultWrite | FaultQueryAllocatedBlocks | FaultMetadata | FaultFlush | FaultReopen
+)
+
+// FaultRule describes a fault injected by a FaultInjector. A call matches the rule if it is one of Ops and
+// overlaps the sector range. Of the matching calls, the first Skip pass, then the rule fires Count times with
+// the given Probability.
+type FaultRule struct {
+	// Ops selects the calls the rule applies to, all of them if 0.
+	Ops FaultOp
+	// StartSector and NumSectors restrict the rule to reads, writes and allocation queries overlapping the
+	// range. Calls without a sector range, and every call if NumSectors is 0, match regardless.
+	StartSector uint64
+	NumSectors  uint64
+	// Skip is the number of matching calls let through before the rule fires.
+	Skip int
+	// Count is the number of times the rule fires, without limit if 0.
+	Count int
+	// Probability is the chance that a matching call fires the rule, 1 if 0.
+	Probability float64
+	// Delay is waited before the call is made, e.g. to simulate a slow host.
+	Delay time.Duration
+	// Err is returned instead of making the call, e.g. disklib.ErrHostConnectionLost.
+	Err error
+	// Partial makes a read or write failing with Err transfer the sectors before the faulty part of the
+	// call first, like a call failing halfway. The faulty part is the overlap with the rule's sector range,
+	// or the second half of the call if the rule has none.
+	Partial bool
+	// Corrupt flips a random bit in every sector of the faulty part of a read or write. Reads are corrupted
+	// after the data was read, writes are corrupted in a copy of the caller's buffer.
+	Corrupt bool
+}
+
+type faultRuleState struct {
+	rule  FaultRule
+	seen  int
+	fired int
+}
+
+// FaultInjector makes the backend of a disk fail as its rules say, see WithFaults. Whether a rule fires and
+// where data is corrupted is drawn from a generator seeded by NewFaultInjector, so a test making the same
+// calls in the same order sees the same faults. Rules can be changed while disks are using the injector.
+type FaultInjector struct {
+	mutex    sync.Mutex
+	random   *rand.Rand
+	rules    []*faultRuleState
+	injected int64
+	sleep    func(time.Duration) // delays calls, time.Sleep if nil; only tests set it, see newFaultInjector
+}
+
+// NewFaultInjector returns an injector with the given rules, drawing from a generator seeded with seed.
+func NewFaultInjector(seed int64, rules ...FaultRule) *FaultInjector {
+	return newFaultInjector(seed, nil, rules...)
+}
+
+// newFaultInjector is NewFaultInjector delaying calls with sleep, nil meaning time.Sleep. Tests pass a
+// function that records the delays instead.
+func newFaultInjector(seed int64, sleep func(time.Duration), rules ...FaultRule) *FaultInjector {
+	injector := &FaultInjector{
+		random: rand.New(rand.NewSource(seed)),
+		sleep:  sleep,
+	}
+	injector.AddRules(rules...)
+	return injector
+}
+
+// AddRules adds rules after the existing ones.
+func (this *FaultInjector) AddRules(rules ...FaultRule) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, rule := range rules {
+		if rule.Ops == 0 {
+			rule.Ops = FaultAllOps
+		}
+		this.rules = append(this.rules, &faultRuleState{rule: rule})
+	}
+}
+
+// ClearRules removes all rules, so that calls are no longer disturbed.
+func (this *FaultInjector) ClearRules() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.rules = nil
+}
+
+// Injected returns the number of times a rule fired.
+func (this *FaultInjector) Injected() int64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.injected
+}
+
+// fault is what the rules fired by one call do to it. The faulty part of the call is
+// [faultSector, faultSector+faultSectors).
+type fault struct {
+	delay        time.Duration
+	err          error
+	partial      bool
+	faultSector  uint64
+	faultSectors uint64
+	flips        []int // byte offsets into the data of the call, with the bit to flip in the lowest 3 bits
+}
+
+// plan returns the fault for a call of op on numSectors sectors at startSector, or nil if no rule fires.
+// The first firing rule with an error decides the error.
+func (this *FaultInjector) plan(op FaultOp, startSector uint64, numSectors uint64) *fault {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var result *fault
+	for _, state := range this.rules {
+		rule := state.rule
+		if rule.Ops&op == 0 {
+			continue
+		}
+		faultSector, faultSectors := startSector+numSectors/2, numSectors-numSectors/2
+		if rule.NumSectors > 0 && numSectors > 0 {
+			if rule.StartSector >= startSector+numSectors || startSector >= rule.StartSector+rule.NumSectors {
+				continue
+			}
+			faultSector = maxUint64(startSector, rule.StartSector)
+			faultSectors = minUint64(startSector+numSectors, rule.StartSector+rule.NumSectors) - faultSector
+		}
+		state.seen++
+		if state.seen <= rule.Skip || (rule.Count > 0 && state.fired >= rule.Count) {
+			continue
+		}
+		if rule.Probability > 0 && this.random.Float64() >= rule.Probability {
+			continue
+		}
+		state.fired++
+		this.injected++
+		if result == nil {
+			result = &fault{}
+		}
+		result.delay += rule.Delay
+		if rule.Err != nil && result.err == nil {
+			result.err = rule.Err
+			result.partial = rule.Partial
+			result.faultSector = faultSector
+			result.faultSectors = faultSectors
+		}
+		if rule.Corrupt {
+			base := int(faultSector-startSector) * disklib.VIXDISKLIB_SECTOR_SIZE
+			for i := 0; i < int(faultSectors); i++ {
+				offset := base + i*disklib.VIXDISKLIB_SECTOR_SIZE + this.random.Intn(disklib.VIXDISKLIB_SECTOR_SIZE)
+				result.flips = append(result.flips, offset<<3|this.random.Intn(8))
+			}
+		}
+	}
+	return result
+}
+
+// corrupt flips the planned bits of buf, which holds the data of the call from its first sector on, or only
+// the part that was transferred.
+func (this *fault) corrupt(buf []byte) {
+	for _, flip := range this.flips {
+		if flip>>3 < len(buf) {
+			buf[flip>>3] ^= 1 << (flip & 7)
+		}
+	}
+}
+
+func maxUint64(a uint64, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minUint64(a uint64, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// WithFaults wraps the backend of the disk with injector, see NewFaultBackend. It is meant for tests.
+func WithFaults(injector *FaultInjector) DiskOption {
+	return func(options *diskOptions) {
+		options.faults = injector
+	}
+}
+
+// faultBackend is the Backend returned by NewFaultBackend.
+type faultBackend struct {
+	backend  Backend
+	injector *FaultInjector
+}
+
+// faultAsyncBackend adds the asynchronous calls of an AsyncBackend to faultBackend.
+type faultAsyncBackend struct {
+	faultBackend
+}
+
+// faultReopenBackend adds Reopen to faultBackend for a backend that is a Reopener.
+type faultReopenBackend struct {
+	faultBackend
+}
+
+// faultAsyncReopenBackend adds Reopen to faultAsyncBackend for a backend that is a Reopener.
+type faultAsyncReopenBackend struct {
+	faultAsyncBackend
+}
+
+// NewFaultBackend returns a Backend passing calls to backend, disturbed as the rules of injector say. The
+// returned backend is a Reopener and an AsyncBackend if backend is; asynchronous reads and writes can be
+// delayed or failed when they are started, but are not corrupted or cut short.
+func NewFaultBackend(backend Backend, injector *FaultInjector) Backend {
+	wrapped := faultBackend{
+		backend:  backend,
+		injector: injector,
+	}
+	_, reopener := backend.(Reopener)
+	if _, ok := backend.(AsyncBackend); ok {
+		if reopener {
+			return &faultAsyncReopenBackend{faultAsyncBackend{wrapped}}
+		}
+		return &faultAsyncBackend{wrapped}
+	}
+	if reopener {
+		return &faultReopenBackend{wrapped}
+	}
+	return &wrapped
+}
+
+// before applies the delay of a planned fault and returns the fault, nil if no rule fired.
+func (this *faultBackend) before(op FaultOp, startSector uint64, numSectors uint64) *fault {
+	fault := this.injector.plan(op, startSector, numSectors)
+	if fault != nil && fault.delay > 0 {
+		if this.injector.sleep != nil {
+			this.injector.sleep(fault.delay)
+		} else {
+			time.Sleep(fault.delay)
+		}
+	}
+	return fault
+}
+
+// call makes a call without sector range, failing it if a rule says so.
+func (this *faultBackend) call(op FaultOp, fn func() error) error {
+	if fault := this.before(op, 0, 0); fault != nil && fault.err != nil {
+		return fault.err
+	}
+	return fn()
+}
+
+func (this *faultBackend) ReadSectors(startSector uint64, numSectors uint64, buf []byte) error {
+	fault := this.before(FaultRead, startSector, numSectors)
+	if fault == nil {
+		return this.backend.ReadSectors(startSector, numSectors, buf)
+	}
+	if fault.err != nil {
+		if fault.partial && fault.faultSector > startSector {
+			if err := this.backend.ReadSectors(startSector, fault.faultSector-startSector, buf); err != nil {
+				return err
+			}
+			fault.corrupt(buf[:(fault.faultSector-startSector)*disklib.VIXDISKLIB_SECTOR_SIZE])
+		}
+		return fault.err
+	}
+	if err := this.backend.ReadSectors(startSector, numSectors, buf); err != nil {
+		return err
+	}
+	fault.corrupt(buf)
+	return nil
+}
+
+func (this *faultBackend) WriteSectors(startSector uint64, numSectors uint64, buf []byte) error {
+	fault := this.before(FaultWrite, startSector, numSectors)
+	if fault == nil {
+		return this.backend.WriteSectors(startSector, numSectors, buf)
+	}
+	if len(fault.flips) > 0 {
+		buf = append([]byte(nil), buf[:numSectors*disklib.VIXDISKLIB_SECTOR_SIZE]...)
+		fault.corrupt(buf)
+	}
+	if fault.err != nil {
+		if fault.partial && fault.faultSector > startSector {
+			if err := this.backend.WriteSectors(startSector, fault.faultSector-startSector, buf); err != nil {
+				return err
+			}
+		}
+		return fault.err
+	}
+	return this.backend.WriteSectors(startSector, numSectors, buf)
+}
+
+func (this *faultBackend) Info() (disklib.VixDiskLibInfo, error) {
+	return this.backend.Info()
+}
+
+func (this *faultBackend) QueryAllocatedBlocks(startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, error) {
+	if fault := this.before(FaultQueryAllocatedBlocks, uint64(startSector), uint64(numSectors)); fault != nil && fault.err != nil {
+		return nil, fault.err
+	}
+	return this.backend.QueryAllocatedBlocks(startSector, numSectors, chunkSize)
+}
+
+func (this *faultBackend) ReadMetadata(key string) (string, error) {
+	var val string
+	err := this.call(FaultMetadata, func() (err error) {
+		val, err = this.backend.ReadMetadata(key)
+		return err
+	})
+	return val, err
+}
+
+func (this *faultBackend) WriteMetadata(key string, val string) error {
+	return this.call(FaultMetadata, func() error {
+		return this.backend.WriteMetadata(key, val)
+	})
+}
+
+func (this *faultBackend) GetMetadataKeys() ([]string, error) {
+	var keys []string
+	err := this.call(FaultMetadata, func() (err error) {
+		keys, err = this.backend.GetMetadataKeys()
+		return err
+	})
+	return keys, err
+}
+
+func (this *faultBackend) ReadOnly() bool {
+	return this.backend.ReadOnly()
+}
+
+func (this *faultBackend) Flush() error {
+	return this.call(FaultFlush, this.backend.Flush)
+}
+
+func (this *faultBackend) Close() error {
+	return this.backend.Close()
+}
+
+// reopen passes Reopen on to the backend, which must be a Reopener.
+func (this *faultBackend) reopen() error {
+	return this.call(FaultReopen, this.backend.(Reopener).Reopen)
+}
+
+func (this *faultReopenBackend) Reopen() error {
+	return this.reopen()
+}
+
+func (this *faultAsyncReopenBackend) Reopen() error {
+	return this.reopen()
+}
+
+func (this *faultAsyncBackend) ReadAsync(startSector uint64, numSectors uint64, buf []byte) (*disklib.AsyncOp, error) {
+	if fault := this.before(FaultRead, startSector, numSectors); fault != nil && fault.err != nil {
+		return nil, fault.err
+	}
+	return this.backend.(AsyncBackend).ReadAsync(startSector, numSectors, buf)
+}
+
+func (this *faultAsyncBackend) WriteAsync(startSector uint64, numSectors uint64, buf []byte) (*disklib.AsyncOp, error) {
+	if fault := this.before(FaultWrite, startSector, numSectors); fault != nil && fault.err != nil {
+		return nil, fault.err
+	}
+	return this.backend.(AsyncBackend).WriteAsync(startSector, numSectors, buf)
+}
+
+func (this *faultAsyncBackend) Wait() error {
+	return this.backend.(AsyncBackend).Wait()
+}
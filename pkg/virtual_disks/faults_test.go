@@ -0,0 +1,262 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks_test
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/virtual-disks/pkg/disklib"
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+func TestFaultRules(t *testing.T) {
+	injector := virtual_disks.NewFaultInjector(1,
+		virtual_disks.FaultRule{Ops: virtual_disks.FaultRead, Skip: 1, Count: 2, Err: disklib.ErrOutOfRange},
+		virtual_disks.FaultRule{Ops: virtual_disks.FaultWrite | virtual_disks.FaultFlush, StartSector: 8, NumSectors: 2, Err: disklib.ErrHostConnectionLost})
+	backend := virtual_disks.NewFaultBackend(newMemBackend(16), injector)
+	buf := make([]byte, 4*disklib.VIXDISKLIB_SECTOR_SIZE)
+
+	var results []bool
+	for i := 0; i < 4; i++ {
+		err := backend.ReadSectors(0, 4, buf)
+		if err != nil && !errors.Is(err, disklib.ErrOutOfRange) {
+			t.Fatalf("read %d failed with %v", i, err)
+		}
+		results = append(results, err == nil)
+	}
+	if expected := []bool{true, false, false, true}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("reads succeeded %v, expected %v", results, expected)
+	}
+
+	// Writes fail only where they overlap the sector range, calls without sectors always match
+	if err := backend.WriteSectors(4, 4, buf); err != nil {
+		t.Errorf("write before the range failed: %v", err)
+	}
+	if err := backend.WriteSectors(6, 4, buf); !errors.Is(err, disklib.ErrHostConnectionLost) {
+		t.Errorf("write overlapping the range returned %v", err)
+	}
+	if err := backend.Flush(); !errors.Is(err, disklib.ErrHostConnectionLost) {
+		t.Errorf("flush returned %v", err)
+	}
+	if _, err := backend.ReadMetadata("missing"); !errors.Is(err, disklib.ErrKeyNotFound) {
+		t.Errorf("metadata read returned %v", err)
+	}
+	if injected := injector.Injected(); injected != 4 {
+		t.Errorf("%d faults injected, expected 4", injected)
+	}
+
+	injector.ClearRules()
+	if err := backend.WriteSectors(6, 4, buf); err != nil {
+		t.Errorf("write after ClearRules failed: %v", err)
+	}
+}
+
+func TestFaultPartialAndCorrupt(t *testing.T) {
+	mem := newMemBackend(16)
+	for i := range mem.Bytes() {
+		mem.Bytes()[i] = byte(i / disklib.VIXDISKLIB_SECTOR_SIZE)
+	}
+	original := append([]byte(nil), mem.Bytes()...)
+	injector := virtual_disks.NewFaultInjector(1)
+	backend := virtual_disks.NewFaultBackend(mem, injector)
+
+	// A read failing halfway delivers the sectors before the faulty range
+	injector.AddRules(virtual_disks.FaultRule{Ops: virtual_disks.FaultRead, StartSector: 4, NumSectors: 2, Count: 1, Err: disklib.ErrOutOfRange, Partial: true})
+	buf := make([]byte, 8*disklib.VIXDISKLIB_SECTOR_SIZE)
+	if err := backend.ReadSectors(0, 8, buf); !errors.Is(err, disklib.ErrOutOfRange) {
+		t.Fatalf("partial read returned %v", err)
+	}
+	if !bytes.Equal(buf[:4*disklib.VIXDISKLIB_SECTOR_SIZE], original[:4*disklib.VIXDISKLIB_SECTOR_SIZE]) || !bytes.Equal(buf[4*disklib.VIXDISKLIB_SECTOR_SIZE:], make([]byte, 4*disklib.VIXDISKLIB_SECTOR_SIZE)) {
+		t.Error("partial read did not transfer exactly the first 4 sectors")
+	}
+
+	// Corrupted reads flip one bit in every sector of the faulty range
+	injector.ClearRules()
+	injector.AddRules(virtual_disks.FaultRule{Ops: virtual_disks.FaultRead, StartSector: 2, NumSectors: 3, Corrupt: true})
+	if err := backend.ReadSectors(0, 8, buf); err != nil {
+		t.Fatal(err)
+	}
+	for sector := 0; sector < 8; sector++ {
+		expected := 0
+		if sector >= 2 && sector < 5 {
+			expected = 1
+		}
+		start, end := sector*disklib.VIXDISKLIB_SECTOR_SIZE, (sector+1)*disklib.VIXDISKLIB_SECTOR_SIZE
+		if flipped := bitDifference(buf[start:end], original[start:end]); flipped != expected {
+			t.Errorf("sector %d has %d flipped bits, expected %d", sector, flipped, expected)
+		}
+	}
+	if !bytes.Equal(mem.Bytes(), original) {
+		t.Error("corrupted read changed the disk")
+	}
+
+	// Corrupted writes reach the disk, but not the caller's buffer
+	injector.ClearRules()
+	injector.AddRules(virtual_disks.FaultRule{Ops: virtual_disks.FaultWrite, Corrupt: true})
+	copy(buf, original)
+	if err := backend.WriteSectors(0, 8, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, original[:8*disklib.VIXDISKLIB_SECTOR_SIZE]) {
+		t.Error("corrupted write changed the caller's buffer")
+	}
+	// Without a sector range the second half of the call is faulty
+	if flipped := bitDifference(mem.Bytes()[:4*disklib.VIXDISKLIB_SECTOR_SIZE], original[:4*disklib.VIXDISKLIB_SECTOR_SIZE]); flipped != 0 {
+		t.Errorf("%d bits flipped in the first half of the write", flipped)
+	}
+	if flipped := bitDifference(mem.Bytes(), original); flipped != 4 {
+		t.Errorf("%d bits flipped by the write, expected 4", flipped)
+	}
+}
+
+func bitDifference(a []byte, b []byte) int {
+	n := 0
+	for i := range a {
+		n += bits.OnesCount8(a[i] ^ b[i])
+	}
+	return n
+}
+
+func TestFaultSeed(t *testing.T) {
+	run := func(seed int64) []bool {
+		injector := virtual_disks.NewFaultInjector(seed, virtual_disks.FaultRule{Ops: virtual_disks.FaultFlush, Probability: 0.5, Err: disklib.ErrTimeout})
+		backend := virtual_disks.NewFaultBackend(newMemBackend(1), injector)
+		var results []bool
+		for i := 0; i < 64; i++ {
+			results = append(results, backend.Flush() == nil)
+		}
+		return results
+	}
+	first := run(42)
+	if !reflect.DeepEqual(first, run(42)) {
+		t.Error("the same seed gave different faults")
+	}
+	failed := 0
+	for _, ok := range first {
+		if !ok {
+			failed++
+		}
+	}
+	if failed == 0 || failed == len(first) {
+		t.Errorf("%d of %d calls failed with probability 0.5", failed, len(first))
+	}
+}
+
+func TestFaultDelay(t *testing.T) {
+	var slept time.Duration
+	injector := virtual_disks.NewFaultInjectorWithSleep(1, func(delay time.Duration) {
+		slept += delay
+	}, virtual_disks.FaultRule{Ops: virtual_disks.FaultRead, Delay: time.Second, Count: 2})
+	backend := virtual_disks.NewFaultBackend(newMemBackend(4), injector)
+	buf := make([]byte, disklib.VIXDISKLIB_SECTOR_SIZE)
+	for i := 0; i < 3; i++ {
+		if err := backend.ReadSectors(0, 1, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if slept != 2*time.Second {
+		t.Errorf("slept %v, expected 2s", slept)
+	}
+}
+
+func TestWithFaults(t *testing.T) {
+	injector := virtual_disks.NewFaultInjector(1, virtual_disks.FaultRule{Ops: virtual_disks.FaultRead, Count: 2, Err: disklib.ErrHostConnectionLost})
+	diskReaderWriter, err := virtual_disks.OpenBackend(newMemBackend(16), logrus.New(),
+		virtual_disks.WithFaults(injector), virtual_disks.WithRetryPolicy(virtual_disks.RetryPolicy{MaxAttempts: 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskReaderWriter.Close()
+
+	// The retry policy hides both faults
+	buf := make([]byte, 100)
+	if n, err := diskReaderWriter.ReadAt(buf, 10); n != len(buf) || err != nil {
+		t.Fatalf("ReadAt returned %d, %v", n, err)
+	}
+	if injected := injector.Injected(); injected != 2 {
+		t.Errorf("%d faults injected, expected 2", injected)
+	}
+}
+
+// asyncBackend claims asynchronous I/O without doing any, for checking the interfaces of wrappers.
+type asyncBackend struct {
+	virtual_disks.Backend
+}
+
+func (this asyncBackend) ReadAsync(startSector uint64, numSectors uint64, buf []byte) (*disklib.AsyncOp, error) {
+	return nil, disklib.ErrNotSupported
+}
+
+func (this asyncBackend) WriteAsync(startSector uint64, numSectors uint64, buf []byte) (*disklib.AsyncOp, error) {
+	return nil, disklib.ErrNotSupported
+}
+
+func (this asyncBackend) Wait() error {
+	return nil
+}
+
+// asyncReopeningBackend is a reopeningBackend claiming asynchronous I/O.
+type asyncReopeningBackend struct {
+	asyncBackend
+	reopening *reopeningBackend
+}
+
+func (this asyncReopeningBackend) Reopen() error {
+	return this.reopening.Reopen()
+}
+
+func TestFaultBackendInterfaces(t *testing.T) {
+	reopening := &reopeningBackend{MemBackend: newMemBackend(4)}
+	tests := []struct {
+		name            string
+		backend         virtual_disks.Backend
+		async, reopener bool
+	}{
+		{"plain", newMemBackend(4), false, false},
+		{"async", asyncBackend{newMemBackend(4)}, true, false},
+		{"reopener", reopening, false, true},
+		{"async reopener", asyncReopeningBackend{asyncBackend{reopening}, reopening}, true, true},
+	}
+	for _, test := range tests {
+		injector := virtual_disks.NewFaultInjector(1, virtual_disks.FaultRule{Ops: virtual_disks.FaultReopen, Count: 1, Err: disklib.ErrHostConnectionLost})
+		wrapped := virtual_disks.NewFaultBackend(test.backend, injector)
+		if _, async := wrapped.(virtual_disks.AsyncBackend); async != test.async {
+			t.Errorf("%s: the fault backend is an AsyncBackend: %v, expected %v", test.name, async, test.async)
+		}
+		reopener, ok := wrapped.(virtual_disks.Reopener)
+		if ok != test.reopener {
+			t.Errorf("%s: the fault backend is a Reopener: %v, expected %v", test.name, ok, test.reopener)
+		}
+		if !ok {
+			continue
+		}
+		// Reopen is subject to the rules and passed on once they let it through
+		reopens := reopening.reopens
+		if err := reopener.Reopen(); !errors.Is(err, disklib.ErrHostConnectionLost) {
+			t.Errorf("%s: Reopen with a fault returned %v", test.name, err)
+		}
+		if err := reopener.Reopen(); err != nil || reopening.reopens != reopens+1 {
+			t.Errorf("%s: Reopen returned %v after %d reopens, expected 1", test.name, err, reopening.reopens-reopens)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/virtual-disks/pkg/disklib"
+)
+
+// errSeekNotSupported is returned by seekFile if holes cannot be found on the platform or file system.
+var errSeekNotSupported = errors.New("SEEK_DATA and SEEK_HOLE are not supported")
+
+// fileBackend is a Backend reading and writing a local raw image, a VMDK flat extent or a block device with
+// pread and pwrite. It has no metadata.
+type fileBackend struct {
+	file     *os.File
+	size     int64
+	readOnly bool
+}
+
+// OpenFile returns a DiskReaderWriter for the raw image at path, without VDDK. Allocation is reported from
+// SEEK_DATA and SEEK_HOLE where the file system supports them, otherwise the whole image counts as allocated.
+// A partial sector at the end of the file is not accessible. logger receives the messages of the disk.
+func OpenFile(path string, readOnly bool, logger logrus.FieldLogger, opts ...DiskOption) (DiskReaderWriter, error) {
+	backend, err := openFileBackend(path, readOnly)
+	if err != nil {
+		return DiskReaderWriter{}, err
+	}
+	diskReaderWriter, err := OpenBackend(backend, logger, opts...)
+	if err != nil {
+		backend.Close()
+		return DiskReaderWriter{}, err
+	}
+	return diskReaderWriter, nil
+}
+
+func openFileBackend(path string, readOnly bool) (*fileBackend, error) {
+	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	// Stat reports no size for block devices
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileBackend{
+		file:     file,
+		size:     size / disklib.VIXDISKLIB_SECTOR_SIZE * disklib.VIXDISKLIB_SECTOR_SIZE,
+		readOnly: readOnly,
+	}, nil
+}
+
+// extent returns the byte range of numSectors sectors at startSector, which must lie within the image.
+func (this *fileBackend) extent(startSector uint64, numSectors uint64, buf []byte) (int64, int, error) {
+	if numSectors == 0 || numSectors > uint64(len(buf))/disklib.VIXDISKLIB_SECTOR_SIZE {
+		return 0, 0, disklib.NewVddkError(disklib.VIX_E_INVALID_ARG, fmt.Sprintf("Buffer of %d bytes cannot hold %d sectors", len(buf), numSectors))
+	}
+	if startSector+numSectors > uint64(this.size/disklib.VIXDISKLIB_SECTOR_SIZE) {
+		return 0, 0, disklib.NewVddkError(disklib.VIX_E_DISK_OUTOFRANGE, fmt.Sprintf("Sectors %d to %d are beyond the end of %s", startSector, startSector+numSectors, this.file.Name()))
+	}
+	return int64(startSector * disklib.VIXDISKLIB_SECTOR_SIZE), int(numSectors * disklib.VIXDISKLIB_SECTOR_SIZE), nil
+}
+
+func (this *fileBackend) ReadSectors(startSector uint64, numSectors uint64, buf []byte) error {
+	off, length, err := this.extent(startSector, numSectors, buf)
+	if err != nil {
+		return err
+	}
+	_, err = this.file.ReadAt(buf[:length], off)
+	return err
+}
+
+func (this *fileBackend) WriteSectors(startSector uint64, numSectors uint64, buf []byte) error {
+	if this.readOnly {
+		return ErrReadOnly
+	}
+	off, length, err := this.extent(startSector, numSectors, buf)
+	if err != nil {
+		return err
+	}
+	_, err = this.file.WriteAt(buf[:length], off)
+	return err
+}
+
+func (this *fileBackend) Info() (disklib.VixDiskLibInfo, error) {
+	return disklib.VixDiskLibInfo{
+		Capacity: disklib.VixDiskLibSectorType(this.size / disklib.VIXDISKLIB_SECTOR_SIZE),
+	}, nil
+}
+
+// QueryAllocatedBlocks reports every chunk holding data according to SEEK_DATA and SEEK_HOLE. Like VDDK, it
+// requires the range to consist of whole chunks.
+func (this *fileBackend) QueryAllocatedBlocks(startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, error) {
+	if chunkSize == 0 || startSector%chunkSize != 0 || numSectors%chunkSize != 0 {
+		return nil, disklib.NewVddkError(disklib.VIX_E_INVALID_ARG, fmt.Sprintf("Range of %d sectors at sector %d does not consist of chunks of %d sectors", numSectors, startSector, chunkSize))
+	}
+	if startSector+numSectors > disklib.VixDiskLibSectorType(this.size/disklib.VIXDISKLIB_SECTOR_SIZE) {
+		return nil, disklib.NewVddkError(disklib.VIX_E_DISK_OUTOFRANGE, fmt.Sprintf("Sectors %d to %d are beyond the end of %s", startSector, startSector+numSectors, this.file.Name()))
+	}
+	chunkBytes := int64(chunkSize) * disklib.VIXDISKLIB_SECTOR_SIZE
+	pos := int64(startSector) * disklib.VIXDISKLIB_SECTOR_SIZE
+	end := int64(startSector+numSectors) * disklib.VIXDISKLIB_SECTOR_SIZE
+	var blocks []disklib.VixDiskLibBlock
+	for pos < end {
+		dataStart, err := seekFile(this.file, pos, true)
+		if err == io.EOF {
+			break
+		}
+		if err == errSeekNotSupported {
+			return nil, disklib.NewVddkError(disklib.VIX_E_NOT_SUPPORTED, "The file system of "+this.file.Name()+" does not report holes")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if dataStart >= end {
+			break
+		}
+		dataEnd, err := seekFile(this.file, dataStart, false)
+		if err != nil {
+			return nil, err
+		}
+		// Report whole chunks, merging those touched by consecutive extents
+		chunkStart := dataStart / chunkBytes * chunkBytes
+		chunkEnd := (dataEnd + chunkBytes - 1) / chunkBytes * chunkBytes
+		if chunkEnd > end {
+			chunkEnd = end
+		}
+		if last := len(blocks) - 1; last >= 0 && int64(blocks[last].Offset()+blocks[last].Length())*disklib.VIXDISKLIB_SECTOR_SIZE >= chunkStart {
+			blocks[last].SetLength(disklib.VixDiskLibSectorType(chunkEnd/disklib.VIXDISKLIB_SECTOR_SIZE) - blocks[last].Offset())
+		} else {
+			var block disklib.VixDiskLibBlock
+			block.SetOffset(disklib.VixDiskLibSectorType(chunkStart / disklib.VIXDISKLIB_SECTOR_SIZE))
+			block.SetLength(disklib.VixDiskLibSectorType((chunkEnd - chunkStart) / disklib.VIXDISKLIB_SECTOR_SIZE))
+			blocks = append(blocks, block)
+		}
+		pos = chunkEnd
+	}
+	return blocks, nil
+}
+
+func (this *fileBackend) ReadMetadata(key string) (string, error) {
+	return "", disklib.NewVddkError(disklib.VIX_E_DISK_KEY_NOTFOUND, "Raw images have no metadata, key "+key+" not found")
+}
+
+func (this *fileBackend) WriteMetadata(key string, val string) error {
+	return disklib.NewVddkError(disklib.VIX_E_NOT_SUPPORTED, "Raw images have no metadata")
+}
+
+func (this *fileBackend) GetMetadataKeys() ([]string, error) {
+	return nil, nil
+}
+
+func (this *fileBackend) ReadOnly() bool {
+	return this.readOnly
+}
+
+func (this *fileBackend) Flush() error {
+	if this.readOnly {
+		return nil
+	}
+	return this.file.Sync()
+}
+
+func (this *fileBackend) Close() error {
+	return this.file.Close()
+}
@@ -0,0 +1,297 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/virtual-disks/pkg/disklib"
+)
+
+// testImage creates a sparse raw image of size bytes holding data at off.
+func testImage(t *testing.T, size int64, data []byte, off int64) string {
+	path := filepath.Join(t.TempDir(), "disk-flat.vmdk")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(data, off); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenFile(t *testing.T) {
+	path := testImage(t, 1024*1024+100, []byte("data"), 4096)
+	diskReaderWriter, err := OpenFile(path, false, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The partial sector at the end is cut off
+	if capacity := diskReaderWriter.Disk().Capacity(); capacity != 1024*1024 {
+		t.Fatalf("Capacity is %d, expected %d", capacity, 1024*1024)
+	}
+	if _, err := diskReaderWriter.WriteAt([]byte("misaligned"), 4094); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 12)
+	if _, err := diskReaderWriter.ReadAt(buf, 4093); err != nil || !bytes.Equal(buf, []byte("\x00misaligned\x00")) {
+		t.Fatalf("ReadAt returned %q, %v", buf, err)
+	}
+	if _, err := diskReaderWriter.ReadAt(buf, 1024*1024); err != io.EOF {
+		t.Fatalf("ReadAt at the end returned %v, expected %v", err, io.EOF)
+	}
+	if err := diskReaderWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content[4094:4104], []byte("misaligned")) {
+		t.Fatalf("image holds %q", content[4094:4104])
+	}
+}
+
+func TestOpenFileReadOnly(t *testing.T) {
+	path := testImage(t, 64*1024, []byte("data"), 0)
+	diskReaderWriter, err := OpenFile(path, true, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskReaderWriter.Close()
+	if _, err := diskReaderWriter.WriteAt([]byte("x"), 0); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("WriteAt returned %v, expected %v", err, ErrReadOnly)
+	}
+	buf := make([]byte, 4)
+	if _, err := diskReaderWriter.Read(buf); err != nil || string(buf) != "data" {
+		t.Fatalf("Read returned %q, %v", buf, err)
+	}
+}
+
+func TestOpenFileAllocation(t *testing.T) {
+	const chunk = seekChunkSectors * disklib.VIXDISKLIB_SECTOR_SIZE
+	path := testImage(t, 16*chunk, bytes.Repeat([]byte{1}, chunk+1), 4*chunk)
+	diskReaderWriter, err := OpenFile(path, true, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskReaderWriter.Close()
+	blocks, vErr := diskReaderWriter.QueryAllocatedBlocks(0, 16*seekChunkSectors, seekChunkSectors)
+	if errors.Is(vErr, disklib.ErrNotSupported) {
+		t.Skip("The file system of the temporary directory does not report holes")
+	}
+	if vErr != nil {
+		t.Fatal(vErr)
+	}
+	if len(blocks) != 1 || blocks[0].Offset() != 4*seekChunkSectors || blocks[0].Length() != 2*seekChunkSectors {
+		t.Fatalf("allocated blocks are %v, expected chunks 4 and 5", blocks)
+	}
+	if off, err := diskReaderWriter.SeekData(0); off != 4*chunk || err != nil {
+		t.Fatalf("SeekData returned %d, %v", off, err)
+	}
+	if off, err := diskReaderWriter.SeekHole(4 * chunk); off != 6*chunk || err != nil {
+		t.Fatalf("SeekHole returned %d, %v", off, err)
+	}
+}
+
+func TestOpenFileSeek(t *testing.T) {
+	const chunk = seekChunkSectors * disklib.VIXDISKLIB_SECTOR_SIZE
+	const window = chunk * disklib.VIXDISKLIB_MAX_CHUNK_NUMBER
+	// A whole window, a window of four chunks and a partial chunk at the end. Chunk 2 is allocated, and the
+	// chunks on either side of the window boundary.
+	const size = window + 4*chunk + 8*disklib.VIXDISKLIB_SECTOR_SIZE
+	path := testImage(t, size, bytes.Repeat([]byte{1}, chunk), 2*chunk)
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(bytes.Repeat([]byte{2}, 2*chunk), window-chunk); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte("tail"), size-4); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	diskReaderWriter, err := OpenFile(path, true, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskReaderWriter.Close()
+	if _, vErr := diskReaderWriter.QueryAllocatedBlocks(0, seekChunkSectors, seekChunkSectors); errors.Is(vErr, disklib.ErrNotSupported) {
+		t.Skip("The file system of the temporary directory does not report holes")
+	}
+
+	tests := []struct {
+		name   string
+		data   bool
+		off    int64
+		result int64
+		err    error
+	}{
+		{"data from the start", true, 0, 2 * chunk, nil},
+		{"data inside data", true, 2*chunk + 10, 2*chunk + 10, nil},
+		{"data inside a hole", true, 3*chunk + 10, window - chunk, nil},
+		{"data at the window boundary", true, window, window, nil},
+		{"data in the last window", true, window + chunk, window + 4*chunk, nil},
+		{"data in the partial chunk", true, size - 1, size - 1, nil},
+		{"data at capacity", true, size, 0, io.EOF},
+		{"data past the end", true, size + chunk, 0, io.EOF},
+		{"hole from the start", false, 0, 0, nil},
+		{"hole inside data", false, 2*chunk + 10, 3 * chunk, nil},
+		{"hole inside a hole", false, 3*chunk + 10, 3*chunk + 10, nil},
+		{"hole across the window boundary", false, window - chunk, window + chunk, nil},
+		{"hole at the window boundary", false, window, window + chunk, nil},
+		{"hole in the partial chunk", false, size - 1, size, nil},
+		{"hole at capacity", false, size, 0, io.EOF},
+		{"hole past the end", false, size + chunk, 0, io.EOF},
+	}
+	for _, test := range tests {
+		seek := diskReaderWriter.Disk().SeekHole
+		if test.data {
+			seek = diskReaderWriter.Disk().SeekData
+		}
+		if result, err := seek(test.off); err != test.err || (err == nil && result != test.result) {
+			t.Errorf("%s: seeking from %d returned %d, %v, expected %d, %v", test.name, test.off, result, err, test.result, test.err)
+		}
+	}
+
+	if off, err := diskReaderWriter.Seek(0, io.SeekEnd); off != size || err != nil {
+		t.Fatalf("Seek to the end returned %d, %v", off, err)
+	}
+	buf := make([]byte, 4)
+	if n, err := diskReaderWriter.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read at the end returned %d, %v", n, err)
+	}
+	if off, err := diskReaderWriter.Seek(-4, io.SeekEnd); off != size-4 || err != nil {
+		t.Fatalf("Seek before the end returned %d, %v", off, err)
+	}
+	if _, err := io.ReadFull(diskReaderWriter, buf); err != nil || string(buf) != "tail" {
+		t.Errorf("Read before the end returned %q, %v", buf, err)
+	}
+	if off, err := diskReaderWriter.Seek(chunk, io.SeekEnd); off != size+chunk || err != nil {
+		t.Fatalf("Seek past the end returned %d, %v", off, err)
+	}
+	if n, err := diskReaderWriter.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read past the end returned %d, %v", n, err)
+	}
+	// Failed seeks leave the offset alone
+	if off, err := diskReaderWriter.SeekData(size); off != size+chunk || err != io.EOF {
+		t.Errorf("SeekData at capacity returned %d, %v", off, err)
+	}
+	if _, err := diskReaderWriter.Seek(-size-1, io.SeekEnd); err == nil {
+		t.Error("Seek before the start succeeded")
+	}
+	if off, err := diskReaderWriter.Seek(0, io.SeekCurrent); off != size+chunk || err != nil {
+		t.Errorf("offset is %d, %v after the failed seeks, expected %d", off, err, size+chunk)
+	}
+	// SeekData and SeekHole move the offset
+	if off, err := diskReaderWriter.SeekHole(window - chunk); off != window+chunk || err != nil {
+		t.Fatalf("SeekHole returned %d, %v", off, err)
+	}
+	if off, err := diskReaderWriter.Seek(0, io.SeekCurrent); off != window+chunk || err != nil {
+		t.Errorf("offset is %d, %v after SeekHole, expected %d", off, err, window+chunk)
+	}
+}
+
+// countingBackend counts the allocation queries reaching the backend.
+type countingBackend struct {
+	*fileBackend
+	queries int
+}
+
+func (this *countingBackend) QueryAllocatedBlocks(startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, error) {
+	this.queries++
+	return this.fileBackend.QueryAllocatedBlocks(startSector, numSectors, chunkSize)
+}
+
+func TestOpenFileWriteTo(t *testing.T) {
+	const chunk = seekChunkSectors * disklib.VIXDISKLIB_SECTOR_SIZE
+	const window = chunk * disklib.VIXDISKLIB_MAX_CHUNK_NUMBER
+	// Two whole windows, a window of a single chunk and a partial chunk at the end
+	const size = 2*window + chunk + 8*disklib.VIXDISKLIB_SECTOR_SIZE
+	extents := map[int64][]byte{
+		0:             []byte("first"),
+		3 * chunk:     bytes.Repeat([]byte{2}, 2*chunk),
+		window - 100:  bytes.Repeat([]byte{3}, 200),
+		2*window + 10: []byte("last window"),
+		size - 100:    []byte("tail"),
+	}
+	path := testImage(t, size, nil, 0)
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for off, data := range extents {
+		if _, err := file.WriteAt(data, off); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fb, err := openFileBackend(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &countingBackend{fileBackend: fb}
+	diskReaderWriter, err := OpenBackend(backend, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskReaderWriter.Close()
+	if _, err := diskReaderWriter.QueryAllocatedBlocks(0, seekChunkSectors, seekChunkSectors); errors.Is(err, disklib.ErrNotSupported) {
+		t.Skip("The file system of the temporary directory does not report holes")
+	}
+	backend.queries = 0
+
+	copyPath := filepath.Join(t.TempDir(), "copy.img")
+	dst, err := os.Create(copyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if n, err := io.Copy(dst, diskReaderWriter); n != size || err != nil {
+		t.Fatalf("io.Copy returned %d, %v", n, err)
+	}
+	// Each window is queried once, however many extents it holds
+	if backend.queries != 3 {
+		t.Errorf("WriteTo queried the allocation %d times, expected 3", backend.queries)
+	}
+	if info, err := dst.Stat(); err != nil || info.Size() != size {
+		t.Fatalf("copy has size %v, %v", info.Size(), err)
+	}
+	for off, data := range extents {
+		buf := make([]byte, len(data))
+		if _, err := dst.ReadAt(buf, off); err != nil || !bytes.Equal(buf, data) {
+			t.Errorf("copy holds %q at %d, expected %q", buf, off, data)
+		}
+	}
+}
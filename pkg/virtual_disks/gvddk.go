@@ -18,9 +18,10 @@ package virtual_disks
 
 import "C"
 import (
-	"fmt"
+	"context"
 	"io"
 	"sync"
+	"sync/atomic"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -46,30 +47,169 @@ func OpenFCD(serverName string, thumbPrint string, userName string, password str
 	return Open(globalParams, logger)
 }
 
-func Open(globalParams disklib.ConnectParams, logger logrus.FieldLogger) (DiskReaderWriter, disklib.VddkError) {
-	err := disklib.PrepareForAccess(globalParams)
-	if err != nil {
-		return DiskReaderWriter{}, err
+// DiskOption configures a disk opened with Open or OpenContext.
+type DiskOption func(*diskOptions)
+
+type diskOptions struct {
+	retryPolicy    RetryPolicy
+	cacheBlockSize int
+	cacheBlocks    int
+	readAheadSize  int
+	readAheadCount int
+	writeBackSize  int
+	throttles      []*Throttle
+	faults         *FaultInjector
+}
+
+// defaultCacheBlockSize is the block size of WithBlockCache if none is given.
+const defaultCacheBlockSize = 64 * 1024
+
+// defaultReadAheadSize is the chunk size of WithReadAhead if none is given.
+const defaultReadAheadSize = 1024 * 1024
+
+// defaultWriteBackSize is the buffer size of WithWriteBack if none is given.
+const defaultWriteBackSize = 1024 * 1024
+
+// WithRetryPolicy makes the disk retry failed reads, writes and allocation queries as policy allows,
+// reopening it from its ConnectParams when the connection was lost.
+func WithRetryPolicy(policy RetryPolicy) DiskOption {
+	return func(options *diskOptions) {
+		options.retryPolicy = policy
 	}
-	conn, err := disklib.ConnectEx(globalParams)
+}
+
+func Open(globalParams disklib.ConnectParams, logger logrus.FieldLogger, opts ...DiskOption) (DiskReaderWriter, disklib.VddkError) {
+	diskHandle, err := openHandle(context.Background(), globalParams, opts)
 	if err != nil {
-		disklib.EndAccess(globalParams)
-		return DiskReaderWriter{}, err
+		if vErr, ok := err.(disklib.VddkError); ok {
+			return DiskReaderWriter{}, vErr
+		}
+		return DiskReaderWriter{}, newDiskError(disklib.VIX_E_FAIL, err.Error(), err)
 	}
-	dli, err := disklib.Open(conn, globalParams)
-	if err != nil {
-		disklib.Disconnect(conn)
-		disklib.EndAccess(globalParams)
+	return NewDiskReaderWriter(diskHandle, logger), nil
+}
+
+// OpenContext is Open with cancellation. If ctx is done before the disk is open, OpenContext returns ctx.Err()
+// right away; the remaining VDDK calls finish in the background and everything they opened is closed again.
+func OpenContext(ctx context.Context, globalParams disklib.ConnectParams, logger logrus.FieldLogger, opts ...DiskOption) (DiskReaderWriter, error) {
+	if err := ctx.Err(); err != nil {
 		return DiskReaderWriter{}, err
 	}
-	info, err := disklib.GetInfo(dli)
+	type openResult struct {
+		diskHandle DiskConnectHandle
+		err        error
+	}
+	done := make(chan openResult, 1)
+	go func() {
+		diskHandle, err := openHandle(ctx, globalParams, opts)
+		done <- openResult{diskHandle, err}
+	}()
+	select {
+	case result := <-done:
+		if result.err != nil {
+			return DiskReaderWriter{}, result.err
+		}
+		return NewDiskReaderWriter(result.diskHandle, logger), nil
+	case <-ctx.Done():
+		go func() {
+			if result := <-done; result.err == nil {
+				result.diskHandle.Close()
+			}
+		}()
+		return DiskReaderWriter{}, ctx.Err()
+	}
+}
+
+// WithBlockCache caches up to numBlocks disk blocks of blockSize bytes, 64 KiB if 0, rounded up to a multiple
+// of the sector size. Small and misaligned reads, and the read/modify/write cycles of misaligned writes, are
+// served from the cache; writes drop the blocks they touch. With the cache, I/O on the same block is
+// serialized.
+func WithBlockCache(blockSize int, numBlocks int) DiskOption {
+	return func(options *diskOptions) {
+		options.cacheBlockSize = blockSize
+		options.cacheBlocks = numBlocks
+	}
+}
+
+// WithReadAhead makes sequential Read calls prefetch up to numChunks chunks of chunkSize bytes, 1 MiB if 0,
+// in the background. Prefetching starts once a Read begins where the previous one ended and stops when the
+// offset moves elsewhere.
+func WithReadAhead(chunkSize int, numChunks int) DiskOption {
+	return func(options *diskOptions) {
+		options.readAheadSize = chunkSize
+		options.readAheadCount = numChunks
+	}
+}
+
+// WithWriteBack makes Write gather adjacent writes in a buffer of bufferSize bytes, 1 MiB if 0, and write
+// them as large sector aligned writes, at least one sector. The buffer is flushed when it is full, on seeks,
+// by Sync and Close, and before I/O overlapping it. An error writing buffered data is returned by the call
+// that flushed it, and the data stays buffered for the next flush.
+func WithWriteBack(bufferSize int) DiskOption {
+	return func(options *diskOptions) {
+		options.writeBackSize = bufferSize
+		if options.writeBackSize <= 0 {
+			options.writeBackSize = defaultWriteBackSize
+		}
+	}
+}
+
+// WithThrottle limits the VDDK calls of the disk with throttle, which may be shared with other disks, e.g.
+// HostThrottle(serverName) for all disks on a host. Given several times, every throttle applies.
+func WithThrottle(throttle *Throttle) DiskOption {
+	return func(options *diskOptions) {
+		options.throttles = append(options.throttles, throttle)
+	}
+}
+
+// openHandle opens the disk through VDDK and applies opts. On failure everything done so far is undone.
+func openHandle(ctx context.Context, globalParams disklib.ConnectParams, opts []DiskOption) (DiskConnectHandle, error) {
+	backend, info, err := openVddkBackend(ctx, globalParams)
 	if err != nil {
-		disklib.Disconnect(conn)
-		disklib.EndAccess(globalParams)
-		return DiskReaderWriter{}, err
+		return DiskConnectHandle{}, err
+	}
+	disk := newDisk(backend, info, globalParams)
+	disk.configure(opts)
+	return DiskConnectHandle{disk}, nil
+}
+
+// configure applies opts to a new disk.
+func (this *Disk) configure(opts []DiskOption) {
+	options := diskOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	this.retryPolicy = options.retryPolicy
+	if options.cacheBlocks > 0 {
+		blockSize := int64(options.cacheBlockSize)
+		if blockSize <= 0 {
+			blockSize = defaultCacheBlockSize
+		}
+		sectorSize := this.SectorSize()
+		blockSize = (blockSize + sectorSize - 1) / sectorSize * sectorSize
+		this.cache = newBlockCache(blockSize, options.cacheBlocks)
+	}
+	if options.readAheadCount > 0 {
+		chunkSize := int64(options.readAheadSize)
+		if chunkSize <= 0 {
+			chunkSize = defaultReadAheadSize
+		}
+		sectorSize := this.SectorSize()
+		chunkSize = (chunkSize + sectorSize - 1) / sectorSize * sectorSize
+		this.readAhead = newReadAhead(chunkSize, options.readAheadCount)
+	}
+	if options.writeBackSize > 0 {
+		// A buffer smaller than a sector never fills up to a sector boundary
+		bufferSize := options.writeBackSize
+		if sectorSize := int(this.SectorSize()); bufferSize < sectorSize {
+			bufferSize = sectorSize
+		}
+		this.writeBack = newWriteBack(bufferSize)
+	}
+	this.throttles = options.throttles
+	if options.faults != nil {
+		this.session.backend = NewFaultBackend(this.session.backend, options.faults)
 	}
-	diskHandle := NewDiskHandle(dli, conn, globalParams, info)
-	return NewDiskReaderWriter(diskHandle, logger), nil
 }
 
 type DiskReaderWriter struct {
@@ -82,24 +222,43 @@ type DiskReaderWriter struct {
 func (this DiskReaderWriter) Read(p []byte) (n int, err error) {
 	this.mutex.Lock()
 	defer this.mutex.Unlock()
-	bytesRead, err := this.diskHandle.ReadAt(p, *this.offset)
+	if err := this.diskHandle.checkOpen(); err != nil {
+		return 0, err
+	}
+	var bytesRead int
+	if this.diskHandle.readAhead != nil {
+		bytesRead, err = this.diskHandle.readAhead.read(this.diskHandle.Disk, p, *this.offset)
+	} else {
+		bytesRead, err = this.diskHandle.ReadAt(p, *this.offset)
+	}
 	*this.offset += int64(bytesRead)
-	this.logger.Infof("Read returning %d, len(p) = %d, offset=%d\n", bytesRead, len(p), *this.offset)
+	this.logger.Debugf("Read returning %d, len(p) = %d, offset=%d\n", bytesRead, len(p), *this.offset)
 	return bytesRead, err
 }
 
 func (this DiskReaderWriter) Write(p []byte) (n int, err error) {
 	this.mutex.Lock()
 	defer this.mutex.Unlock()
-	bytesWritten, err := this.diskHandle.WriteAt(p, *this.offset)
+	if err := this.diskHandle.checkWritable(); err != nil {
+		return 0, err
+	}
+	var bytesWritten int
+	if this.diskHandle.writeBack != nil {
+		bytesWritten, err = this.diskHandle.writeBack.write(this.diskHandle.Disk, p, *this.offset)
+	} else {
+		bytesWritten, err = this.diskHandle.WriteAt(p, *this.offset)
+	}
 	*this.offset += int64(bytesWritten)
-	this.logger.Infof("Write returning %d, len(p) = %d, offset=%d\n", bytesWritten, len(p), *this.offset)
+	this.logger.Debugf("Write returning %d, len(p) = %d, offset=%d\n", bytesWritten, len(p), *this.offset)
 	return bytesWritten, err
 }
 
 func (this DiskReaderWriter) Seek(offset int64, whence int) (int64, error) {
 	this.mutex.Lock()
 	defer this.mutex.Unlock()
+	if err := this.diskHandle.checkOpen(); err != nil {
+		return *this.offset, err
+	}
 	desiredOffset := *this.offset
 	switch whence {
 	case io.SeekStart:
@@ -107,17 +266,50 @@ func (this DiskReaderWriter) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		desiredOffset += offset
 	case io.SeekEnd:
-		// Fix this later
-		return *this.offset, errors.New("Seek from SeekEnd not implemented")
+		desiredOffset = this.diskHandle.Capacity() + offset
 	}
 
 	if desiredOffset < 0 {
 		return 0, errors.New("Cannot seek to negative offset")
 	}
+	if desiredOffset != *this.offset {
+		if err := this.diskHandle.flushWrites(context.Background()); err != nil {
+			return *this.offset, err
+		}
+	}
 	*this.offset = desiredOffset
+	this.diskHandle.seekReadAhead(desiredOffset)
 	return *this.offset, nil
 }
 
+// SeekData moves the offset to the first allocated byte at or after off and returns it, see
+// DiskConnectHandle.SeekData. The offset is unchanged if an error is returned.
+func (this DiskReaderWriter) SeekData(off int64) (int64, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	dataOffset, err := this.diskHandle.SeekData(off)
+	if err != nil {
+		return *this.offset, err
+	}
+	*this.offset = dataOffset
+	this.diskHandle.seekReadAhead(dataOffset)
+	return dataOffset, nil
+}
+
+// SeekHole moves the offset to the first unallocated byte at or after off and returns it, see
+// DiskConnectHandle.SeekHole. The offset is unchanged if an error is returned.
+func (this DiskReaderWriter) SeekHole(off int64) (int64, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	holeOffset, err := this.diskHandle.SeekHole(off)
+	if err != nil {
+		return *this.offset, err
+	}
+	*this.offset = holeOffset
+	this.diskHandle.seekReadAhead(holeOffset)
+	return holeOffset, nil
+}
+
 func (this DiskReaderWriter) ReadAt(p []byte, off int64) (n int, err error) {
 	return this.diskHandle.ReadAt(p, off)
 }
@@ -126,14 +318,61 @@ func (this DiskReaderWriter) WriteAt(p []byte, off int64) (n int, err error) {
 	return this.diskHandle.WriteAt(p, off)
 }
 
+// ReadAtContext is ReadAt with cancellation, see DiskConnectHandle.ReadAtContext.
+func (this DiskReaderWriter) ReadAtContext(ctx context.Context, p []byte, off int64) (n int, err error) {
+	return this.diskHandle.ReadAtContext(ctx, p, off)
+}
+
+// WriteAtContext is WriteAt with cancellation, see DiskConnectHandle.WriteAtContext.
+func (this DiskReaderWriter) WriteAtContext(ctx context.Context, p []byte, off int64) (n int, err error) {
+	return this.diskHandle.WriteAtContext(ctx, p, off)
+}
+
+// Sync flushes writes buffered by VDDK to the disk. Once Sync returns nil, everything written before the
+// call is durable.
+func (this DiskReaderWriter) Sync() error {
+	return this.diskHandle.Sync()
+}
+
 func (this DiskReaderWriter) Close() error {
 	return this.diskHandle.Close()
 }
 
+// CloseContext is Close with cancellation, see DiskConnectHandle.CloseContext.
+func (this DiskReaderWriter) CloseContext(ctx context.Context) error {
+	return this.diskHandle.CloseContext(ctx)
+}
+
 func (this DiskReaderWriter) QueryAllocatedBlocks(startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, disklib.VddkError) {
 	return this.diskHandle.QueryAllocatedBlocks(startSector, numSectors, chunkSize)
 }
 
+// QueryAllocatedBlocksContext is QueryAllocatedBlocks with cancellation, see
+// DiskConnectHandle.QueryAllocatedBlocksContext.
+func (this DiskReaderWriter) QueryAllocatedBlocksContext(ctx context.Context, startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, error) {
+	return this.diskHandle.QueryAllocatedBlocksContext(ctx, startSector, numSectors, chunkSize)
+}
+
+// ReadAsync starts an asynchronous read of len(p) bytes at off, see DiskConnectHandle.ReadAsync.
+func (this DiskReaderWriter) ReadAsync(p []byte, off int64) (*disklib.AsyncOp, error) {
+	return this.diskHandle.ReadAsync(p, off)
+}
+
+// WriteAsync starts an asynchronous write of p at off, see DiskConnectHandle.WriteAsync.
+func (this DiskReaderWriter) WriteAsync(p []byte, off int64) (*disklib.AsyncOp, error) {
+	return this.diskHandle.WriteAsync(p, off)
+}
+
+// Wait blocks until all asynchronous reads and writes on the disk have completed.
+func (this DiskReaderWriter) Wait() error {
+	return this.diskHandle.Wait()
+}
+
+// Disk returns the disk read and written.
+func (this DiskReaderWriter) Disk() *Disk {
+	return this.diskHandle.Disk
+}
+
 func NewDiskReaderWriter(diskHandle DiskConnectHandle, logger logrus.FieldLogger) DiskReaderWriter {
 	var offset int64
 	offset = 0
@@ -147,40 +386,204 @@ func NewDiskReaderWriter(diskHandle DiskConnectHandle, logger logrus.FieldLogger
 	return retVal
 }
 
+// Disk is an open virtual disk. It is always used through a pointer, so every DiskConnectHandle and
+// DiskReaderWriter referring to it sees the same state. Close is idempotent, I/O after Close fails with
+// ErrClosed and writes to a disk opened read only fail with ErrReadOnly before reaching VDDK.
+type Disk struct {
+	locks       *rangeLocker // orders overlapping reads and writes, see lockSectors
+	session     *diskSession
+	cache       *blockCache // nil unless enabled with WithBlockCache
+	readAhead   *readAhead  // nil unless enabled with WithReadAhead
+	writeBack   *writeBack  // nil unless enabled with WithWriteBack
+	throttles   []*Throttle
+	retryPolicy RetryPolicy
+	params      disklib.ConnectParams
+	info        disklib.VixDiskLibInfo
+	readOnly    bool
+	closed      int32 // set atomically once Close starts
+	closeOnce   sync.Once
+	closeErr    error
+}
+
+// DiskConnectHandle refers to an open Disk, all of whose methods it provides.
 type DiskConnectHandle struct {
-	mutex  *sync.Mutex
-	dli    disklib.VixDiskLibHandle
-	conn   disklib.VixDiskLibConnection
-	params disklib.ConnectParams
-	info   disklib.VixDiskLibInfo
+	*Disk
 }
 
 func NewDiskHandle(dli disklib.VixDiskLibHandle, conn disklib.VixDiskLibConnection, params disklib.ConnectParams,
 	info disklib.VixDiskLibInfo) DiskConnectHandle {
-	var mutex sync.Mutex
-	return DiskConnectHandle{
-		mutex:  &mutex,
-		dli:    dli,
-		conn:   conn,
-		params: params,
-		info:   info,
+	return DiskConnectHandle{newDisk(newVddkBackend(dli, conn, params), info, params)}
+}
+
+// newDisk returns a Disk on backend. params are the ConnectParams the backend was opened with, if any.
+func newDisk(backend Backend, info disklib.VixDiskLibInfo, params disklib.ConnectParams) *Disk {
+	return &Disk{
+		locks:    newRangeLocker(),
+		session:  newDiskSession(backend),
+		params:   params,
+		info:     info,
+		readOnly: backend.ReadOnly(),
+	}
+}
+
+// Flags returns the VIXDISKLIB_FLAG_OPEN_* flags the disk was opened with, 0 unless it was opened through VDDK.
+func (this *Disk) Flags() uint32 {
+	return this.params.Flags()
+}
+
+// ReadOnly reports whether the disk was opened read only, e.g. through a read only connection or with
+// VIXDISKLIB_FLAG_OPEN_READ_ONLY.
+func (this *Disk) ReadOnly() bool {
+	return this.readOnly
+}
+
+// checkOpen returns ErrClosed once Close has been called.
+func (this *Disk) checkOpen() error {
+	if atomic.LoadInt32(&this.closed) != 0 {
+		return ErrClosed
+	}
+	return nil
+}
+
+// checkWritable returns ErrClosed after Close and ErrReadOnly if the disk was opened read only.
+func (this *Disk) checkWritable() error {
+	if err := this.checkOpen(); err != nil {
+		return err
+	}
+	if this.readOnly {
+		return ErrReadOnly
 	}
+	return nil
 }
 
-func mapError(vddkError disklib.VddkError) error {
-	switch vddkError.VixErrorCode() {
-	case disklib.VIX_E_DISK_OUTOFRANGE:
+// ioChunkSize bounds the size of the VDDK calls made by the context variants of ReadAt and WriteAt, which
+// check for cancellation between them. It is a multiple of every sector size.
+const ioChunkSize = 1024 * 1024
+
+func mapError(err error) error {
+	if errors.Is(err, disklib.ErrOutOfRange) {
 		return io.EOF
-	default:
-		return vddkError
 	}
+	return err
+}
+
+// SectorSize returns the disk's logical sector size, the granularity VDDK reads and writes. Unaligned
+// requests are turned into read/modify/write cycles of whole sectors.
+func (this *Disk) SectorSize() int64 {
+	if this.info.LogicalSectorSize == 0 {
+		return disklib.VIXDISKLIB_SECTOR_SIZE
+	}
+	return int64(this.info.LogicalSectorSize)
+}
+
+func (this *Disk) aligned(len int, off int64) bool {
+	sectorSize := this.SectorSize()
+	return int64(len)%sectorSize == 0 && off%sectorSize == 0
+}
+
+// lockSectors locks the sectors touched by length bytes at off. Reads take shared locks. Writes take
+// exclusive ones, so that a read/modify/write cycle for a misaligned write is atomic with respect to every
+// other read and write of the same sectors, while I/O on other sectors proceeds in parallel. With the block
+// cache whole blocks are locked, so that filling a block cannot race with a write invalidating it.
+func (this *Disk) lockSectors(ctx context.Context, length int, off int64, exclusive bool) (*rangeLock, error) {
+	unit := this.SectorSize()
+	if this.cache != nil {
+		unit = this.cache.blockSize
+	}
+	start := off - off%unit
+	end := off + int64(length)
+	if end%unit != 0 {
+		end += unit - end%unit
+	}
+	return this.locks.lockContext(ctx, start, end, exclusive)
 }
 
-func aligned(len int, off int64) bool {
-	return len%disklib.VIXDISKLIB_SECTOR_SIZE == 0 && off%disklib.VIXDISKLIB_SECTOR_SIZE == 0
+// sectorChunk returns how many of length bytes the next VDDK call transfers. Without cancellation, retries or
+// throttling a single call is made, otherwise calls of at most ioChunkSize, so that ctx is checked between
+// them, a retry resumes at the chunk that failed and throttled I/O is spread evenly.
+func (this *Disk) sectorChunk(ctx context.Context, length int) int {
+	if (ctx.Done() != nil || this.retryPolicy.enabled() || len(this.throttles) > 0) && length > ioChunkSize {
+		return ioChunkSize
+	}
+	return length
 }
 
-func (this DiskConnectHandle) ReadAt(p []byte, off int64) (n int, err error) {
+// throttle waits until every throttle of the disk allows a VDDK call transferring length bytes.
+func (this *Disk) throttle(ctx context.Context, length int) error {
+	for _, throttle := range this.throttles {
+		if err := throttle.wait(ctx, length); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSectors reads len(buf) bytes at off, both multiples of the sector size, and returns the number of bytes
+// read. VDDK addresses the disk in VIXDISKLIB_SECTOR_SIZE units regardless of the disk's sector size.
+func (this *Disk) readSectors(ctx context.Context, buf []byte, off int64) (int, error) {
+	total := 0
+	for total < len(buf) {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		length := this.sectorChunk(ctx, len(buf)-total)
+		startSector := uint64((off + int64(total)) / disklib.VIXDISKLIB_SECTOR_SIZE)
+		chunk := buf[total : total+length]
+		if err := this.throttle(ctx, length); err != nil {
+			return total, err
+		}
+		err := this.withRetry(ctx, func(backend Backend) error {
+			return backend.ReadSectors(startSector, uint64(length/disklib.VIXDISKLIB_SECTOR_SIZE), chunk)
+		})
+		if err != nil {
+			return total, err
+		}
+		total += length
+	}
+	return total, nil
+}
+
+// writeSectors writes buf at off, both multiples of the sector size, and returns the number of bytes written.
+func (this *Disk) writeSectors(ctx context.Context, buf []byte, off int64) (int, error) {
+	total := 0
+	for total < len(buf) {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		length := this.sectorChunk(ctx, len(buf)-total)
+		startSector := uint64((off + int64(total)) / disklib.VIXDISKLIB_SECTOR_SIZE)
+		chunk := buf[total : total+length]
+		if err := this.throttle(ctx, length); err != nil {
+			return total, err
+		}
+		err := this.withRetry(ctx, func(backend Backend) error {
+			return backend.WriteSectors(startSector, uint64(length/disklib.VIXDISKLIB_SECTOR_SIZE), chunk)
+		})
+		if err != nil {
+			return total, err
+		}
+		total += length
+	}
+	return total, nil
+}
+
+func (this *Disk) ReadAt(p []byte, off int64) (n int, err error) {
+	return this.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext is ReadAt with cancellation. A VDDK call cannot be interrupted, so the read is split into
+// calls of at most 1 MiB and ctx is checked before each of them. Once ctx is done, ReadAtContext returns the
+// number of bytes read so far and ctx.Err(), e.g. context.DeadlineExceeded.
+func (this *Disk) ReadAtContext(ctx context.Context, p []byte, off int64) (n int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := this.checkOpen(); err != nil {
+		return 0, err
+	}
+	if err := this.flushOverlapping(ctx, off, len(p)); err != nil {
+		return 0, err
+	}
 	capacity := this.Capacity()
 	if off >= capacity {
 		return 0, io.EOF
@@ -190,154 +593,392 @@ func (this DiskConnectHandle) ReadAt(p []byte, off int64) (n int, err error) {
 		readLen := int32(capacity - off)
 		p = p[0:readLen]
 	}
-	startSector := off / disklib.VIXDISKLIB_SECTOR_SIZE
+	sectorSize := this.SectorSize()
 	var total int = 0
 
-	if !aligned(len(p), off) {
-		// Lock versus read and write of misaligned data so that read/modify/write cycle always gives correct
-		// behavior (read/write is atomic even though misaligned)
-		this.mutex.Lock()
-		defer this.mutex.Unlock()
+	lock, err := this.lockSectors(ctx, len(p), off, false)
+	if err != nil {
+		return 0, err
+	}
+	defer this.locks.unlock(lock)
+	if this.cache != nil {
+		bytesRead, err := this.readCached(ctx, p, off)
+		if err != nil {
+			return bytesRead, mapError(err)
+		}
+		return bytesRead, nil
 	}
 	// Start missing aligned part
-	if off%disklib.VIXDISKLIB_SECTOR_SIZE != 0 {
-		tmpBuf := make([]byte, disklib.VIXDISKLIB_SECTOR_SIZE)
-		err := disklib.Read(this.dli, (uint64)(startSector), 1, tmpBuf)
+	if off%sectorSize != 0 {
+		tmpBuf := make([]byte, sectorSize)
+		_, err := this.readSectors(ctx, tmpBuf, off-off%sectorSize)
 		if err != nil {
 			return 0, mapError(err)
 		}
-		srcOff := int(off % disklib.VIXDISKLIB_SECTOR_SIZE)
-		count := disklib.VIXDISKLIB_SECTOR_SIZE - srcOff
-		if count > len(p) {
-			count = len(p)
-		}
-		srcEnd := srcOff + count
-		tmpSlice := tmpBuf[srcOff:srcEnd]
-		copy(p[:count], tmpSlice)
-		startSector = startSector + 1
-		total = total + count
+		total = copy(p, tmpBuf[off%sectorSize:])
 	}
 	// Middle aligned part
-	numAlignedSectors := (len(p) - total) / disklib.VIXDISKLIB_SECTOR_SIZE
-	if numAlignedSectors > 0 {
-		desOff := total
-		desEnd := total + numAlignedSectors*disklib.VIXDISKLIB_SECTOR_SIZE
-		err := disklib.Read(this.dli, (uint64)(startSector), (uint64)(numAlignedSectors), p[desOff:desEnd])
+	numAlignedBytes := (len(p) - total) / int(sectorSize) * int(sectorSize)
+	if numAlignedBytes > 0 {
+		bytesRead, err := this.readSectors(ctx, p[total:total+numAlignedBytes], off+int64(total))
+		total = total + bytesRead
 		if err != nil {
 			return total, mapError(err)
 		}
-		startSector = startSector + int64(numAlignedSectors)
-		total = total + numAlignedSectors*disklib.VIXDISKLIB_SECTOR_SIZE
 	}
 	// End missing aligned part
 	if (len(p) - total) > 0 {
-		tmpBuf := make([]byte, disklib.VIXDISKLIB_SECTOR_SIZE)
-		err := disklib.Read(this.dli, (uint64)(startSector), 1, tmpBuf)
+		tmpBuf := make([]byte, sectorSize)
+		_, err := this.readSectors(ctx, tmpBuf, off+int64(total))
 		if err != nil {
 			return total, mapError(err)
 		}
-		count := len(p) - total
-		srcEnd := count
-		tmpSlice := tmpBuf[0:srcEnd]
-		copy(p[total:], tmpSlice)
+		total = total + copy(p[total:], tmpBuf)
 	}
 	return total, nil
 }
 
-func (this DiskConnectHandle) WriteAt(p []byte, off int64) (n int, err error) {
+func (this *Disk) WriteAt(p []byte, off int64) (n int, err error) {
+	return this.WriteAtContext(context.Background(), p, off)
+}
+
+// WriteAtContext is WriteAt with cancellation, split into VDDK calls like ReadAtContext. Once ctx is done it
+// returns the number of bytes written so far and ctx.Err().
+func (this *Disk) WriteAtContext(ctx context.Context, p []byte, off int64) (n int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := this.checkWritable(); err != nil {
+		return 0, err
+	}
+	if err := this.flushOverlapping(ctx, off, len(p)); err != nil {
+		return 0, err
+	}
+	return this.writeAt(ctx, p, off)
+}
+
+// writeAt writes p at off, bypassing the write-back buffer.
+func (this *Disk) writeAt(ctx context.Context, p []byte, off int64) (n int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 	capacity := this.Capacity()
 	// Just error if either the beginning or the end of the write extends beyond the end
 	if off > capacity || off+int64(len(p)) > capacity {
 		return 0, io.ErrShortWrite
 	}
 
-	if !aligned(len(p), off) {
-		// Lock versus read and write of misaligned data so that read/modify/write cycle always gives correct
-		// behavior (read/write is atomic even though misaligned)
-		this.mutex.Lock()
-		defer this.mutex.Unlock()
+	lock, err := this.lockSectors(ctx, len(p), off, true)
+	if err != nil {
+		return 0, err
+	}
+	defer this.locks.unlock(lock)
+	if this.cache != nil {
+		// Write through, the blocks are dropped even if the write fails halfway
+		defer this.cache.invalidate(off, len(p))
 	}
-	var total int64 = 0
-	var srcOff int64 = 0 // start index for p to copy from
-	var srcEnd int64 = 0
-	startSector := off / disklib.VIXDISKLIB_SECTOR_SIZE
+	if this.readAhead != nil {
+		defer this.readAhead.invalidate(off, len(p))
+	}
+	sectorSize := this.SectorSize()
+	var total int = 0
 	// Start missing aligned part
-	if off%disklib.VIXDISKLIB_SECTOR_SIZE != 0 {
-		tmpBuf := make([]byte, disklib.VIXDISKLIB_SECTOR_SIZE)
-		err := disklib.Read(this.dli, uint64(startSector), 1, tmpBuf)
+	if off%sectorSize != 0 {
+		sectorOff := off - off%sectorSize
+		tmpBuf := make([]byte, sectorSize)
+		err := this.readSector(ctx, tmpBuf, sectorOff)
 		if err != nil {
 			return 0, mapError(err)
 		}
-		desOff := off % disklib.VIXDISKLIB_SECTOR_SIZE
-		count := disklib.VIXDISKLIB_SECTOR_SIZE - desOff
-		if int64(len(p)) < count {
-			count = int64(len(p))
-		}
-		desEnd := desOff + count
-		srcEnd = srcOff + count
-		copy(tmpBuf[desOff:desEnd], p[srcOff:srcEnd])
-		err = disklib.Write(this.dli, uint64(startSector), 1, tmpBuf)
+		count := copy(tmpBuf[off%sectorSize:], p)
+		_, err = this.writeSectors(ctx, tmpBuf, sectorOff)
 		if err != nil {
 			return 0, mapError(err)
 		}
-		startSector = startSector + 1
-		total = total + count
-		srcOff = srcOff + count
+		total = count
 	}
 	// Middle aligned part, override directly
-	if (int64(len(p))-total)/disklib.VIXDISKLIB_SECTOR_SIZE > 0 {
-		numSector := (int64(len(p)) - total) / disklib.VIXDISKLIB_SECTOR_SIZE
-		srcEnd = srcOff + numSector*disklib.VIXDISKLIB_SECTOR_SIZE
-		err := disklib.Write(this.dli, uint64(startSector), uint64(numSector), p[srcOff:srcEnd])
+	numAlignedBytes := (len(p) - total) / int(sectorSize) * int(sectorSize)
+	if numAlignedBytes > 0 {
+		bytesWritten, err := this.writeSectors(ctx, p[total:total+numAlignedBytes], off+int64(total))
+		total = total + bytesWritten
 		if err != nil {
-			return int(total), mapError(err)
+			return total, mapError(err)
 		}
-		startSector = startSector + numSector
-		total = total + numSector*disklib.VIXDISKLIB_SECTOR_SIZE
-		srcOff = srcEnd
 	}
 	// End missing aligned part
-	if int64(len(p))-total > 0 {
-		count := int64(len(p)) - total
-		srcEnd = srcOff + count
-		tmpBuf := make([]byte, disklib.VIXDISKLIB_SECTOR_SIZE)
-		err := disklib.Read(this.dli, uint64(startSector), 1, tmpBuf)
+	if len(p)-total > 0 {
+		tmpBuf := make([]byte, sectorSize)
+		err := this.readSector(ctx, tmpBuf, off+int64(total))
 		if err != nil {
-			return int(total), mapError(err)
+			return total, mapError(err)
 		}
-		copy(tmpBuf[:count], p[srcOff:srcEnd])
-		err = disklib.Write(this.dli, uint64(startSector), 1, tmpBuf)
+		copy(tmpBuf, p[total:])
+		_, err = this.writeSectors(ctx, tmpBuf, off+int64(total))
 		if err != nil {
-			return int(total), errors.Wrap(err, "Write into disk in part 3 failed part3.")
+			return total, errors.Wrap(err, "Write into disk in part 3 failed part3.")
 		}
 	}
 	return len(p), nil
 }
 
-func (this DiskConnectHandle) Close() error {
-	vErr := disklib.Close(this.dli)
-	if vErr != nil {
-		return errors.New(fmt.Sprintf(vErr.Error()+" with error code: %d", vErr.VixErrorCode()))
+// Close writes buffered data and closes the disk. Only the first call closes it, later calls return the
+// same result without calling VDDK again.
+func (this *Disk) Close() error {
+	this.closeOnce.Do(func() {
+		atomic.StoreInt32(&this.closed, 1)
+		this.closeErr = this.close()
+	})
+	return this.closeErr
+}
+
+func (this *Disk) close() error {
+	// The disk is closed even if buffered writes fail, their error is returned
+	flushErr := this.flushWrites(context.Background())
+	if this.readAhead != nil {
+		this.readAhead.stop()
 	}
+	err := this.session.close()
+	if err != nil {
+		return err
+	}
+
+	return flushErr
+}
 
-	vErr = disklib.Disconnect(this.conn)
-	if vErr != nil {
-		return errors.New(fmt.Sprintf(vErr.Error()+" with error code: %d", vErr.VixErrorCode()))
+// CloseContext is Close with cancellation. If ctx is done first, CloseContext returns ctx.Err() and the
+// handle is closed in the background once the pending VDDK calls return.
+func (this *Disk) CloseContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- this.Close()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	vErr = disklib.EndAccess(this.params)
-	if vErr != nil {
-		return errors.New(fmt.Sprintf(vErr.Error()+" with error code: %d", vErr.VixErrorCode()))
+// seekReadAhead tells the prefetcher that the offset of a DiskReaderWriter moved to off.
+func (this *Disk) seekReadAhead(off int64) {
+	if this.readAhead != nil {
+		this.readAhead.seek(off)
 	}
+}
 
-	return nil
+// flushWrites writes the data buffered by WithWriteBack.
+func (this *Disk) flushWrites(ctx context.Context) error {
+	if this.writeBack == nil {
+		return nil
+	}
+	return this.writeBack.flush(ctx, this)
+}
+
+// flushOverlapping writes the data buffered by WithWriteBack if it overlaps length bytes at off.
+func (this *Disk) flushOverlapping(ctx context.Context, off int64, length int) error {
+	if this.writeBack == nil {
+		return nil
+	}
+	return this.writeBack.flushOverlapping(ctx, this, off, length)
+}
+
+// Sync flushes writes buffered by WithWriteBack and by VDDK to the disk.
+func (this *Disk) Sync() error {
+	if err := this.flushWrites(context.Background()); err != nil {
+		return err
+	}
+	err, _ := this.session.call(Backend.Flush)
+	return err
+}
+
+// ReadMetadata returns the value of the metadata entry key.
+func (this *Disk) ReadMetadata(key string) (string, error) {
+	var val string
+	err, _ := this.session.call(func(backend Backend) error {
+		var err error
+		val, err = backend.ReadMetadata(key)
+		return err
+	})
+	return val, err
 }
 
-func (this DiskConnectHandle) Capacity() int64 {
+// WriteMetadata sets the metadata entry key to val.
+func (this *Disk) WriteMetadata(key string, val string) error {
+	if err := this.checkWritable(); err != nil {
+		return err
+	}
+	err, _ := this.session.call(func(backend Backend) error {
+		return backend.WriteMetadata(key, val)
+	})
+	return err
+}
+
+// GetMetadataKeys returns the keys of all metadata entries.
+func (this *Disk) GetMetadataKeys() ([]string, error) {
+	var keys []string
+	err, _ := this.session.call(func(backend Backend) error {
+		var err error
+		keys, err = backend.GetMetadataKeys()
+		return err
+	})
+	return keys, err
+}
+
+func (this *Disk) Capacity() int64 {
 	return int64(this.info.Capacity) * disklib.VIXDISKLIB_SECTOR_SIZE
 }
 
 // QueryAllocatedBlocks invokes the VDDK function of the same name.
-func (this DiskConnectHandle) QueryAllocatedBlocks(startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, disklib.VddkError) {
-	return disklib.QueryAllocatedBlocks(this.dli, startSector, numSectors, chunkSize)
+func (this *Disk) QueryAllocatedBlocks(startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, disklib.VddkError) {
+	blocks, err := this.queryAllocatedBlocks(context.Background(), startSector, numSectors, chunkSize)
+	if err != nil {
+		if vErr, ok := err.(disklib.VddkError); ok {
+			return nil, vErr
+		}
+		return nil, disklib.NewVddkError(disklib.VIX_E_FAIL, err.Error())
+	}
+	return blocks, nil
+}
+
+func (this *Disk) queryAllocatedBlocks(ctx context.Context, startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, error) {
+	// Buffered writes change the allocation
+	if err := this.flushWrites(ctx); err != nil {
+		return nil, err
+	}
+	if err := this.throttle(ctx, 0); err != nil {
+		return nil, err
+	}
+	var blocks []disklib.VixDiskLibBlock
+	err := this.withRetry(ctx, func(backend Backend) error {
+		var err error
+		blocks, err = backend.QueryAllocatedBlocks(startSector, numSectors, chunkSize)
+		return err
+	})
+	return blocks, err
+}
+
+// QueryAllocatedBlocksContext is QueryAllocatedBlocks with cancellation. The range is queried in windows of
+// at most VIXDISKLIB_MAX_CHUNK_NUMBER chunks and ctx is checked before each of them. Once ctx is done it
+// returns the blocks found so far and ctx.Err().
+func (this *Disk) QueryAllocatedBlocksContext(ctx context.Context, startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, error) {
+	var result []disklib.VixDiskLibBlock
+	if chunkSize == 0 {
+		// Let VDDK reject the request
+		return this.queryAllocatedBlocks(ctx, startSector, numSectors, chunkSize)
+	}
+	end := startSector + numSectors
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		windowSectors := end - startSector
+		if windowSectors > chunkSize*disklib.VIXDISKLIB_MAX_CHUNK_NUMBER {
+			windowSectors = chunkSize * disklib.VIXDISKLIB_MAX_CHUNK_NUMBER
+		}
+		blocks, err := this.queryAllocatedBlocks(ctx, startSector, windowSectors, chunkSize)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, blocks...)
+		startSector += windowSectors
+		if startSector >= end {
+			return result, nil
+		}
+	}
+}
+
+// checkAsync validates an asynchronous request, which VDDK only supports for whole sectors within the disk.
+// rangeErr is returned for requests extending beyond the end of the disk.
+func (this *Disk) checkAsync(p []byte, off int64, rangeErr error) error {
+	if len(p) == 0 || !this.aligned(len(p), off) {
+		return errors.Errorf("Asynchronous I/O requires sector aligned offset and length, got offset %d and length %d", off, len(p))
+	}
+	if off < 0 || off+int64(len(p)) > this.Capacity() {
+		return rangeErr
+	}
+	return nil
+}
+
+// ReadAsync starts reading len(p) bytes at off without blocking. off and len(p) must be multiples of
+// SectorSize, and p must not be accessed until the returned operation is done. Many operations can be
+// in flight on the same handle. Asynchronous operations do not take sector locks, since VDDK may only
+// complete them once the caller waits; they must not overlap a concurrent WriteAt until they are done.
+func (this *Disk) ReadAsync(p []byte, off int64) (*disklib.AsyncOp, error) {
+	if err := this.checkOpen(); err != nil {
+		return nil, err
+	}
+	if err := this.checkAsync(p, off, io.EOF); err != nil {
+		return nil, err
+	}
+	if err := this.flushOverlapping(context.Background(), off, len(p)); err != nil {
+		return nil, err
+	}
+	if err := this.throttle(context.Background(), len(p)); err != nil {
+		return nil, err
+	}
+	var op *disklib.AsyncOp
+	err := this.callAsync(func(backend AsyncBackend) error {
+		var err error
+		op, err = backend.ReadAsync(uint64(off/disklib.VIXDISKLIB_SECTOR_SIZE), uint64(len(p)/disklib.VIXDISKLIB_SECTOR_SIZE), p)
+		return err
+	})
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return op, nil
+}
+
+// WriteAsync starts writing p at off without blocking. off and len(p) must be multiples of SectorSize.
+// p is copied before WriteAsync returns. Like ReadAsync it does not take sector locks.
+func (this *Disk) WriteAsync(p []byte, off int64) (*disklib.AsyncOp, error) {
+	if err := this.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := this.checkAsync(p, off, io.ErrShortWrite); err != nil {
+		return nil, err
+	}
+	if err := this.flushOverlapping(context.Background(), off, len(p)); err != nil {
+		return nil, err
+	}
+	if this.cache != nil {
+		this.cache.invalidate(off, len(p))
+	}
+	if this.readAhead != nil {
+		this.readAhead.invalidate(off, len(p))
+	}
+	if err := this.throttle(context.Background(), len(p)); err != nil {
+		return nil, err
+	}
+	var op *disklib.AsyncOp
+	err := this.callAsync(func(backend AsyncBackend) error {
+		var err error
+		op, err = backend.WriteAsync(uint64(off/disklib.VIXDISKLIB_SECTOR_SIZE), uint64(len(p)/disklib.VIXDISKLIB_SECTOR_SIZE), p)
+		return err
+	})
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return op, nil
+}
+
+// Wait blocks until all asynchronous operations on the handle have completed.
+func (this *Disk) Wait() error {
+	return this.callAsync(AsyncBackend.Wait)
+}
+
+// callAsync calls fn with the backend, which must be an AsyncBackend.
+func (this *Disk) callAsync(fn func(backend AsyncBackend) error) error {
+	err, _ := this.session.call(func(backend Backend) error {
+		asyncBackend, ok := backend.(AsyncBackend)
+		if !ok {
+			return errAsyncNotSupported
+		}
+		return fn(asyncBackend)
+	})
+	return err
 }
@@ -0,0 +1,108 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"context"
+	"sync"
+)
+
+// rangeLocker serializes I/O on overlapping byte ranges of a disk while letting I/O on disjoint ranges run in
+// parallel. Shared locks on the same range may be held together, an exclusive lock excludes every other lock
+// overlapping it. Overlapping requests are granted in arrival order, so a stream of readers cannot starve a
+// read/modify/write cycle.
+type rangeLocker struct {
+	mutex sync.Mutex
+	locks []*rangeLock // granted and waiting locks, in arrival order
+}
+
+type rangeLock struct {
+	start     int64 // first byte
+	end       int64 // byte after the last one
+	exclusive bool
+	granted   bool
+	ready     chan struct{} // closed once the lock is granted
+}
+
+func newRangeLocker() *rangeLocker {
+	return &rangeLocker{}
+}
+
+func (this *rangeLock) conflicts(other *rangeLock) bool {
+	return (this.exclusive || other.exclusive) && this.start < other.end && other.start < this.end
+}
+
+// lock blocks until [start, end) can be locked, shared or exclusive, and returns the lock to pass to unlock.
+func (this *rangeLocker) lock(start int64, end int64, exclusive bool) *rangeLock {
+	lock, _ := this.lockContext(context.Background(), start, end, exclusive)
+	return lock
+}
+
+// lockContext is lock, but gives up and returns ctx.Err() if ctx is done before the lock is granted.
+func (this *rangeLocker) lockContext(ctx context.Context, start int64, end int64, exclusive bool) (*rangeLock, error) {
+	lock := &rangeLock{
+		start:     start,
+		end:       end,
+		exclusive: exclusive,
+		ready:     make(chan struct{}),
+	}
+	this.mutex.Lock()
+	this.locks = append(this.locks, lock)
+	this.grant()
+	this.mutex.Unlock()
+	select {
+	case <-lock.ready:
+		return lock, nil
+	case <-ctx.Done():
+		// Withdraws the request, or releases the lock if it was granted in the meantime
+		this.unlock(lock)
+		return nil, ctx.Err()
+	}
+}
+
+func (this *rangeLocker) unlock(lock *rangeLock) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for i, held := range this.locks {
+		if held == lock {
+			this.locks = append(this.locks[:i], this.locks[i+1:]...)
+			break
+		}
+	}
+	this.grant()
+}
+
+// grant grants every waiting lock that does not conflict with a lock that arrived before it, whether that
+// one is granted yet or not.
+func (this *rangeLocker) grant() {
+	for i, lock := range this.locks {
+		if lock.granted {
+			continue
+		}
+		blocked := false
+		for _, earlier := range this.locks[:i] {
+			if earlier.conflicts(lock) {
+				blocked = true
+				break
+			}
+		}
+		if !blocked {
+			lock.granted = true
+			close(lock.ready)
+		}
+	}
+}
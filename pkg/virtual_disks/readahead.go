@@ -0,0 +1,206 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import "sync"
+
+// readAhead prefetches the data following sequential Read calls of a DiskReaderWriter. Once a Read starts
+// where the previous one ended, up to numChunks chunks after it are read in the background, each into one
+// of numChunks buffers. A Read elsewhere, a seek or a write overlapping the prefetched data drops them.
+type readAhead struct {
+	mutex      sync.Mutex
+	chunkSize  int64
+	numChunks  int
+	nextOffset int64            // where the last Read ended, -1 before the first one
+	chunks     []*prefetchChunk // contiguous, in order of offset
+	free       chan []byte      // buffers of dropped or consumed chunks
+	allocated  int              // number of buffers, at most numChunks
+	inFlight   sync.WaitGroup
+	stopped    bool
+}
+
+type prefetchChunk struct {
+	off  int64
+	buf  []byte
+	n    int // bytes read into buf, valid once done is closed
+	err  error
+	done chan struct{}
+}
+
+func newReadAhead(chunkSize int64, numChunks int) *readAhead {
+	return &readAhead{
+		chunkSize:  chunkSize,
+		numChunks:  numChunks,
+		nextOffset: -1,
+		free:       make(chan []byte, numChunks),
+	}
+}
+
+// read reads len(p) bytes at off for Read, from the prefetched chunks as far as they reach and from the disk
+// for the rest, then prefetches what follows if the read was sequential.
+func (this *readAhead) read(disk *Disk, p []byte, off int64) (int, error) {
+	this.mutex.Lock()
+	sequential := off == this.nextOffset
+	if !sequential {
+		this.dropLocked()
+	}
+	this.mutex.Unlock()
+
+	total := 0
+	for total < len(p) {
+		pos := off + int64(total)
+		this.mutex.Lock()
+		if len(this.chunks) == 0 || pos < this.chunks[0].off || pos >= this.chunks[0].off+int64(len(this.chunks[0].buf)) {
+			this.dropLocked()
+			this.mutex.Unlock()
+			break
+		}
+		chunk := this.chunks[0]
+		this.mutex.Unlock()
+		<-chunk.done
+
+		this.mutex.Lock()
+		if len(this.chunks) == 0 || this.chunks[0] != chunk {
+			// Dropped by a write while waiting
+			this.mutex.Unlock()
+			continue
+		}
+		if chunk.err != nil || pos >= chunk.off+int64(chunk.n) {
+			// Let the read from the disk report the error
+			this.dropLocked()
+			this.mutex.Unlock()
+			break
+		}
+		count := copy(p[total:], chunk.buf[pos-chunk.off:chunk.n])
+		total += count
+		if pos+int64(count) == chunk.off+int64(len(chunk.buf)) {
+			this.chunks = this.chunks[1:]
+			this.free <- chunk.buf
+		}
+		this.mutex.Unlock()
+	}
+
+	var err error
+	// Prefetched data reaching the end of the disk is cut there, like ReadAt does
+	if total < len(p) && (total == 0 || off+int64(total) < disk.Capacity()) {
+		var bytesRead int
+		bytesRead, err = disk.ReadAt(p[total:], off+int64(total))
+		total += bytesRead
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.nextOffset = off + int64(total)
+	if sequential && err == nil {
+		this.scheduleLocked(disk, this.nextOffset)
+	}
+	return total, err
+}
+
+// scheduleLocked starts reading chunks after pos until numChunks are prefetched or no buffer is free. Chunks
+// end at multiples of the chunk size, so that only the first one may be misaligned.
+func (this *readAhead) scheduleLocked(disk *Disk, pos int64) {
+	if len(this.chunks) > 0 {
+		last := this.chunks[len(this.chunks)-1]
+		pos = last.off + int64(len(last.buf))
+	}
+	capacity := disk.Capacity()
+	for !this.stopped && len(this.chunks) < this.numChunks && pos < capacity {
+		buf := this.bufferLocked()
+		if buf == nil {
+			return
+		}
+		end := (pos/this.chunkSize + 1) * this.chunkSize
+		if end > capacity {
+			end = capacity
+		}
+		chunk := &prefetchChunk{
+			off:  pos,
+			buf:  buf[:end-pos],
+			done: make(chan struct{}),
+		}
+		this.chunks = append(this.chunks, chunk)
+		this.inFlight.Add(1)
+		go func() {
+			defer this.inFlight.Done()
+			chunk.n, chunk.err = disk.ReadAt(chunk.buf, chunk.off)
+			close(chunk.done)
+		}()
+		pos = end
+	}
+}
+
+// bufferLocked returns a free buffer, or nil if all numChunks buffers are in use.
+func (this *readAhead) bufferLocked() []byte {
+	select {
+	case buf := <-this.free:
+		return buf[:cap(buf)]
+	default:
+	}
+	if this.allocated < this.numChunks {
+		this.allocated++
+		return make([]byte, this.chunkSize)
+	}
+	return nil
+}
+
+// dropLocked discards the prefetched chunks. The buffers of chunks still being read are freed once the reads
+// are done.
+func (this *readAhead) dropLocked() {
+	for _, chunk := range this.chunks {
+		select {
+		case <-chunk.done:
+			this.free <- chunk.buf
+		default:
+			go func(chunk *prefetchChunk) {
+				<-chunk.done
+				this.free <- chunk.buf
+			}(chunk)
+		}
+	}
+	this.chunks = nil
+}
+
+// seek stops prefetching unless off is where the last Read ended.
+func (this *readAhead) seek(off int64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if off != this.nextOffset {
+		this.dropLocked()
+		this.nextOffset = -1
+	}
+}
+
+// invalidate drops the prefetched chunks if any of them overlaps length bytes at off, which were written.
+func (this *readAhead) invalidate(off int64, length int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, chunk := range this.chunks {
+		if chunk.off < off+int64(length) && off < chunk.off+int64(len(chunk.buf)) {
+			this.dropLocked()
+			return
+		}
+	}
+}
+
+// stop drops the prefetched chunks and waits for the reads in flight, before the disk is closed.
+func (this *readAhead) stop() {
+	this.mutex.Lock()
+	this.stopped = true
+	this.dropLocked()
+	this.mutex.Unlock()
+	this.inFlight.Wait()
+}
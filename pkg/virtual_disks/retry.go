@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vmware/virtual-disks/pkg/disklib"
+)
+
+// RetryPolicy makes a disk retry reads, writes and allocation queries that fail with a transient VDDK error,
+// reopening the disk first if the connection was lost. The zero value disables retries.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a call is made, including the first one. Values below 2 disable
+	// retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It doubles for every further retry, up to MaxBackoff if
+	// that is set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// RetriableErrors lists the VIX error codes that are retried. If nil, the errors disklib.IsTransient
+	// reports are retried. Add disklib.VIX_E_FILE_ALREADY_LOCKED to the transient codes to wait for a lock
+	// that is expected to be released soon.
+	RetriableErrors []uint64
+	// ReconnectErrors lists the VIX error codes after which the disk is reopened from its ConnectParams
+	// before the retry. If nil, DefaultReconnectErrors is used.
+	ReconnectErrors []uint64
+}
+
+// DefaultReconnectErrors are the VIX error codes meaning that the connection to the host or the disk is gone.
+var DefaultReconnectErrors = []uint64{
+	disklib.VIX_E_HOST_NOT_CONNECTED,
+	disklib.VIX_E_HOST_CONNECTION_LOST,
+	disklib.VIX_E_DISK_INVALID_CONNECTION,
+	disklib.VIX_E_NET_HTTP_COULDNT_CONNECT,
+	disklib.VIX_E_NET_HTTP_OPERATION_TIMEDOUT,
+	disklib.VIX_E_NET_HTTP_TRANSFER,
+}
+
+func (this RetryPolicy) enabled() bool {
+	return this.MaxAttempts > 1
+}
+
+func (this RetryPolicy) retriable(err error) bool {
+	if this.RetriableErrors == nil {
+		return disklib.IsTransient(err)
+	}
+	return hasErrorCode(err, this.RetriableErrors)
+}
+
+func (this RetryPolicy) reconnect(err error) bool {
+	if this.ReconnectErrors == nil {
+		return hasErrorCode(err, DefaultReconnectErrors)
+	}
+	return hasErrorCode(err, this.ReconnectErrors)
+}
+
+// backoff returns the delay before the given retry, counting from 1.
+func (this RetryPolicy) backoff(retry int) time.Duration {
+	delay := this.Backoff
+	for i := 1; i < retry; i++ {
+		if this.MaxBackoff > 0 && delay >= this.MaxBackoff {
+			break
+		}
+		delay *= 2
+	}
+	if this.MaxBackoff > 0 && delay > this.MaxBackoff {
+		delay = this.MaxBackoff
+	}
+	return delay
+}
+
+func hasErrorCode(err error, codes []uint64) bool {
+	errCode := disklib.ErrorCode(err)
+	for _, code := range codes {
+		if errCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// diskSession holds the Backend of an open Disk, whose connection may be replaced when the disk is reopened.
+// Calls hold the read lock, reopen and close the write lock, so a backend is never reopened or closed under a
+// running call.
+type diskSession struct {
+	mutex      sync.RWMutex
+	backend    Backend
+	open       bool // false if reopening failed or once closed
+	closed     bool
+	generation int // incremented whenever the disk is reopened
+}
+
+func newDiskSession(backend Backend) *diskSession {
+	return &diskSession{
+		backend: backend,
+		open:    true,
+	}
+}
+
+// call runs fn with the backend and returns its error along with the generation of the connection.
+func (this *diskSession) call(fn func(backend Backend) error) (error, int) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	if this.closed {
+		return ErrClosed, this.generation
+	}
+	if !this.open {
+		return disklib.NewVddkError(disklib.VIX_E_HOST_NOT_CONNECTED, "The disk is not connected, reopening it failed"), this.generation
+	}
+	return fn(this.backend), this.generation
+}
+
+// reopen reopens the backend if it is a Reopener and the connection is still of the given generation. If
+// another call reopened the disk in the meantime, reopen does nothing.
+func (this *diskSession) reopen(generation int) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	reopener, ok := this.backend.(Reopener)
+	if !ok || this.closed || this.generation != generation {
+		return nil
+	}
+	if err := reopener.Reopen(); err != nil {
+		this.open = false
+		return err
+	}
+	this.open = true
+	this.generation++
+	return nil
+}
+
+// close closes the backend. Further calls fail with ErrClosed.
+func (this *diskSession) close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.closed = true
+	this.open = false
+	return this.backend.Close()
+}
+
+// withRetry makes a backend call through fn, retrying it as the disk's RetryPolicy allows. The backend is
+// reopened before retrying after a lost connection. ctx interrupts the backoff between attempts.
+func (this *Disk) withRetry(ctx context.Context, fn func(backend Backend) error) error {
+	for attempt := 1; ; attempt++ {
+		err, generation := this.session.call(fn)
+		if err == nil {
+			return nil
+		}
+		if err == ErrClosed || attempt >= this.retryPolicy.MaxAttempts || !this.retryPolicy.retriable(err) {
+			return err
+		}
+		timer := time.NewTimer(this.retryPolicy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		if this.retryPolicy.reconnect(err) {
+			// A failed reopen leaves the session closed, the next attempt fails and reopens again
+			this.session.reopen(generation)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vmware/virtual-disks/pkg/disklib"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for retry, want := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if retry == 0 {
+			continue
+		}
+		if got := policy.backoff(retry); got != want {
+			t.Errorf("backoff(%d) = %v, expected %v", retry, got, want)
+		}
+	}
+}
+
+func TestRetryPolicyErrors(t *testing.T) {
+	busy := disklib.NewVddkError(disklib.VIX_E_OBJECT_IS_BUSY, "busy")
+	lost := disklib.NewVddkError(disklib.VIX_E_HOST_CONNECTION_LOST, "lost")
+	notFound := disklib.NewVddkError(disklib.VIX_E_FILE_NOT_FOUND, "not found")
+	policy := RetryPolicy{}
+	if !policy.retriable(busy) || !policy.retriable(lost) || policy.retriable(notFound) {
+		t.Error("default retriable errors do not follow disklib.IsTransient")
+	}
+	if policy.reconnect(busy) || !policy.reconnect(lost) {
+		t.Error("only lost connections should reconnect by default")
+	}
+	locked := disklib.NewVddkError(disklib.VIX_E_FILE_ALREADY_LOCKED, "locked")
+	if policy.retriable(locked) {
+		t.Error("a locked file is retried by default")
+	}
+	policy.RetriableErrors = []uint64{disklib.VIX_E_FILE_NOT_FOUND, disklib.VIX_E_FILE_ALREADY_LOCKED}
+	if policy.retriable(busy) || !policy.retriable(notFound) || !policy.retriable(locked) {
+		t.Error("RetriableErrors is not used")
+	}
+}
+
+// testHandle returns a handle whose session never reaches VDDK, calls are made through fake functions.
+func testHandle(policy RetryPolicy) *Disk {
+	handle := NewDiskHandle(disklib.VixDiskLibHandle{}, disklib.VixDiskLibConnection{}, disklib.ConnectParams{}, disklib.VixDiskLibInfo{})
+	handle.retryPolicy = policy
+	return handle.Disk
+}
+
+// failing returns a call failing with the given errors before succeeding, and counts the attempts.
+func failing(attempts *int, errs ...disklib.VddkError) func(Backend) error {
+	return func(Backend) error {
+		*attempts++
+		if *attempts <= len(errs) {
+			return errs[*attempts-1]
+		}
+		return nil
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	busy := disklib.NewVddkError(disklib.VIX_E_OBJECT_IS_BUSY, "busy")
+	notFound := disklib.NewVddkError(disklib.VIX_E_FILE_NOT_FOUND, "not found")
+	handle := testHandle(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+
+	attempts := 0
+	if err := handle.withRetry(context.Background(), failing(&attempts, busy, busy)); err != nil || attempts != 3 {
+		t.Errorf("withRetry returned %v after %d attempts, expected success after 3", err, attempts)
+	}
+	attempts = 0
+	if err := handle.withRetry(context.Background(), failing(&attempts, busy, busy, busy)); err != busy || attempts != 3 {
+		t.Errorf("withRetry returned %v after %d attempts, expected %v after 3", err, attempts, busy)
+	}
+	attempts = 0
+	if err := handle.withRetry(context.Background(), failing(&attempts, notFound)); err != notFound || attempts != 1 {
+		t.Errorf("withRetry returned %v after %d attempts, expected %v after 1", err, attempts, notFound)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handle.retryPolicy.Backoff = time.Hour
+	attempts = 0
+	if err := handle.withRetry(ctx, failing(&attempts, busy)); !errors.Is(err, context.Canceled) || attempts != 1 {
+		t.Errorf("withRetry returned %v after %d attempts, expected %v after 1", err, attempts, context.Canceled)
+	}
+
+	// Retries are disabled by default
+	attempts = 0
+	if err := testHandle(RetryPolicy{}).withRetry(context.Background(), failing(&attempts, busy)); err != busy || attempts != 1 {
+		t.Errorf("withRetry returned %v after %d attempts, expected %v after 1", err, attempts, busy)
+	}
+}
@@ -0,0 +1,358 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/virtual-disks/pkg/disklib"
+)
+
+// copyChunkSize is the size of the reads and writes issued by WriteTo and ReadFrom, a multiple of every sector
+// size.
+const copyChunkSize = 1024 * 1024
+
+// seekChunkSectors is the granularity, in sectors, at which SeekData and SeekHole query allocation.
+const seekChunkSectors = disklib.VIXDISKLIB_MIN_CHUNK_SIZE
+
+// SeekData returns the offset of the first allocated byte at or after off, like lseek with SEEK_DATA. If no
+// data follows off it returns io.EOF. Allocation is tracked in chunks of 64 KiB, so the result may point
+// into a zeroed region; disks that cannot report allocation are treated as fully allocated.
+func (this *Disk) SeekData(off int64) (int64, error) {
+	return this.seekAllocation(off, true)
+}
+
+// SeekHole returns the offset of the first unallocated byte at or after off, like lseek with SEEK_HOLE.
+// The end of the disk counts as a hole, so Capacity is returned if the rest of the disk is allocated.
+func (this *Disk) SeekHole(off int64) (int64, error) {
+	return this.seekAllocation(off, false)
+}
+
+// seekAllocation scans the allocation of the disk from off for the first allocated byte if data is true, or
+// the first unallocated byte otherwise.
+func (this *Disk) seekAllocation(off int64, data bool) (int64, error) {
+	if err := this.checkOpen(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, errors.New("Cannot seek to negative offset")
+	}
+	capacity := this.Capacity()
+	if off >= capacity {
+		return 0, io.EOF
+	}
+	scanner := newAllocationScanner(this, off)
+	for {
+		extent, err := scanner.next()
+		if err == io.EOF {
+			if data {
+				return 0, io.EOF
+			}
+			return capacity, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if extent.data == data {
+			return extent.start, nil
+		}
+	}
+}
+
+// allocationExtent is a range of bytes of the disk that is either allocated or not.
+type allocationExtent struct {
+	start int64
+	end   int64
+	data  bool
+}
+
+// allocationScanner walks the allocated and unallocated extents of a disk in order. It queries the allocation
+// of each window of up to VIXDISKLIB_MAX_CHUNK_NUMBER chunks once, so that callers visiting every extent,
+// like WriteTo, do not query it again per extent.
+type allocationScanner struct {
+	disk        *Disk
+	capacity    int64
+	pos         int64 // start of the next extent
+	chunkEnd    int64 // end of the last whole chunk in sectors
+	nextSector  int64 // start of the next window to query
+	extents     []allocationExtent
+	unsupported bool
+}
+
+func newAllocationScanner(disk *Disk, off int64) *allocationScanner {
+	capacity := disk.Capacity()
+	return &allocationScanner{
+		disk:     disk,
+		capacity: capacity,
+		pos:      off,
+		// VDDK only reports allocation for whole chunks, the partial chunk at the end counts as allocated
+		chunkEnd:   capacity / (seekChunkSectors * disklib.VIXDISKLIB_SECTOR_SIZE) * seekChunkSectors,
+		nextSector: off / disklib.VIXDISKLIB_SECTOR_SIZE / seekChunkSectors * seekChunkSectors,
+	}
+}
+
+// next returns the extent starting at the current position, or io.EOF at the end of the disk. Consecutive
+// extents may be of the same kind where they meet at a window boundary. Disks that cannot report allocation
+// are a single allocated extent.
+func (this *allocationScanner) next() (allocationExtent, error) {
+	if this.pos >= this.capacity {
+		return allocationExtent{}, io.EOF
+	}
+	if len(this.extents) == 0 {
+		if this.unsupported || this.nextSector >= this.chunkEnd {
+			this.extents = append(this.extents, allocationExtent{start: this.pos, end: this.capacity, data: true})
+		} else if err := this.query(); err != nil {
+			return allocationExtent{}, err
+		}
+	}
+	extent := this.extents[0]
+	this.extents = this.extents[1:]
+	this.pos = extent.end
+	return extent, nil
+}
+
+// query fills extents with the allocation of the next window, from the current position to its end.
+func (this *allocationScanner) query() error {
+	numSectors := this.chunkEnd - this.nextSector
+	if numSectors > seekChunkSectors*disklib.VIXDISKLIB_MAX_CHUNK_NUMBER {
+		numSectors = seekChunkSectors * disklib.VIXDISKLIB_MAX_CHUNK_NUMBER
+	}
+	blocks, vErr := this.disk.QueryAllocatedBlocks(disklib.VixDiskLibSectorType(this.nextSector), disklib.VixDiskLibSectorType(numSectors),
+		seekChunkSectors)
+	if vErr != nil {
+		if errors.Is(vErr, disklib.ErrNotSupported) {
+			this.unsupported = true
+			this.extents = append(this.extents, allocationExtent{start: this.pos, end: this.capacity, data: true})
+			return nil
+		}
+		return vErr
+	}
+	this.nextSector += numSectors
+	windowEnd := this.nextSector * disklib.VIXDISKLIB_SECTOR_SIZE
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Offset() < blocks[j].Offset()
+	})
+	pos := this.pos
+	add := func(end int64, data bool) {
+		if end <= pos {
+			return
+		}
+		if last := len(this.extents) - 1; last >= 0 && this.extents[last].data == data {
+			this.extents[last].end = end
+		} else {
+			this.extents = append(this.extents, allocationExtent{start: pos, end: end, data: data})
+		}
+		pos = end
+	}
+	for _, block := range blocks {
+		blockStart := int64(block.Offset()) * disklib.VIXDISKLIB_SECTOR_SIZE
+		add(blockStart, false)
+		add(blockStart+int64(block.Length())*disklib.VIXDISKLIB_SECTOR_SIZE, true)
+	}
+	add(windowEnd, false)
+	return nil
+}
+
+// WriteTo implements io.WriterTo, so that io.Copy from the disk only reads allocated extents, in large sector
+// aligned chunks, starting at the current offset. Unallocated regions are skipped on *os.File destinations,
+// by seeking past the end of the file or punching holes where supported, and written as zeros to other
+// writers. The offset is advanced past the copied data; the returned count includes skipped regions.
+func (this DiskReaderWriter) WriteTo(w io.Writer) (int64, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	dst := newSparseDestination(w)
+	capacity := this.diskHandle.Capacity()
+	buf := make([]byte, copyChunkSize)
+	var total int64
+	scanner := newAllocationScanner(this.diskHandle.Disk, *this.offset)
+	for *this.offset < capacity {
+		extent, err := scanner.next()
+		if err != nil {
+			return total, err
+		}
+		if !extent.data {
+			if err := dst.skip(extent.end - *this.offset); err != nil {
+				return total, err
+			}
+			total += extent.end - *this.offset
+			*this.offset = extent.end
+			continue
+		}
+		for *this.offset < extent.end {
+			length := extent.end - *this.offset
+			if length > copyChunkSize {
+				length = copyChunkSize
+			}
+			bytesRead, err := this.diskHandle.ReadAt(buf[:length], *this.offset)
+			if bytesRead > 0 {
+				bytesWritten, wErr := dst.write(buf[:bytesRead])
+				total += int64(bytesWritten)
+				*this.offset += int64(bytesWritten)
+				if wErr != nil {
+					return total, wErr
+				}
+			}
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	if err := dst.finish(); err != nil {
+		return total, err
+	}
+	this.logger.Debugf("WriteTo copied %d bytes, offset=%d", total, *this.offset)
+	return total, nil
+}
+
+// ReadFrom implements io.ReaderFrom, writing everything read from r at the current offset in large chunks.
+// All-zero chunks are not written where the disk is not allocated yet, so copying a sparse image onto a new
+// disk only allocates its data. The offset is advanced by the number of bytes read.
+func (this DiskReaderWriter) ReadFrom(r io.Reader) (int64, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if err := this.diskHandle.checkWritable(); err != nil {
+		return 0, err
+	}
+	capacity := this.diskHandle.Capacity()
+	buf := make([]byte, copyChunkSize)
+	zeros := make([]byte, copyChunkSize)
+	nextData := int64(-1) // first allocated byte at or after the offset, valid while not behind the offset
+	var total int64
+	for {
+		bytesRead, rErr := io.ReadFull(r, buf)
+		if bytesRead > 0 {
+			chunk := buf[:bytesRead]
+			skip := false
+			if bytes.Equal(chunk, zeros[:bytesRead]) {
+				if nextData < *this.offset {
+					var err error
+					nextData, err = this.diskHandle.SeekData(*this.offset)
+					if err == io.EOF {
+						nextData = capacity
+					} else if err != nil {
+						return total, err
+					}
+				}
+				skip = *this.offset+int64(bytesRead) <= nextData
+			}
+			if skip {
+				total += int64(bytesRead)
+				*this.offset += int64(bytesRead)
+			} else {
+				bytesWritten, err := this.diskHandle.WriteAt(chunk, *this.offset)
+				total += int64(bytesWritten)
+				*this.offset += int64(bytesWritten)
+				if err != nil {
+					return total, err
+				}
+			}
+		}
+		if rErr == io.EOF || rErr == io.ErrUnexpectedEOF {
+			this.logger.Debugf("ReadFrom copied %d bytes, offset=%d", total, *this.offset)
+			return total, nil
+		}
+		if rErr != nil {
+			return total, rErr
+		}
+	}
+}
+
+// sparseDestination writes the output of WriteTo, leaving holes where the destination supports them.
+type sparseDestination struct {
+	w     io.Writer
+	file  *os.File // set for regular files, which support holes
+	pos   int64    // position in file
+	size  int64    // size of file, not counting a hole at the end that finish still has to add
+	zeros []byte
+}
+
+func newSparseDestination(w io.Writer) *sparseDestination {
+	dst := &sparseDestination{w: w}
+	if file, ok := w.(*os.File); ok {
+		info, err := file.Stat()
+		pos, seekErr := file.Seek(0, io.SeekCurrent)
+		if err == nil && seekErr == nil && info.Mode().IsRegular() {
+			dst.file = file
+			dst.pos = pos
+			dst.size = info.Size()
+		}
+	}
+	return dst
+}
+
+func (this *sparseDestination) write(p []byte) (int, error) {
+	n, err := this.w.Write(p)
+	this.pos += int64(n)
+	if this.pos > this.size {
+		this.size = this.pos
+	}
+	return n, err
+}
+
+// skip advances the destination by length bytes that must read back as zeros.
+func (this *sparseDestination) skip(length int64) error {
+	if this.file != nil {
+		// Existing data has to be cleared, beyond the end of the file seeking is enough
+		if overlap := this.size - this.pos; overlap > 0 {
+			if overlap > length {
+				overlap = length
+			}
+			if punchHole(this.file, this.pos, overlap) != nil {
+				if err := this.writeZeros(overlap); err != nil {
+					return err
+				}
+				length -= overlap
+			}
+		}
+		if _, err := this.file.Seek(this.pos+length, io.SeekStart); err != nil {
+			return err
+		}
+		this.pos += length
+		return nil
+	}
+	return this.writeZeros(length)
+}
+
+func (this *sparseDestination) writeZeros(length int64) error {
+	if this.zeros == nil {
+		this.zeros = make([]byte, copyChunkSize)
+	}
+	for length > 0 {
+		chunk := this.zeros
+		if length < int64(len(chunk)) {
+			chunk = chunk[:length]
+		}
+		n, err := this.write(chunk)
+		length -= int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish extends the file over a hole skipped at its end.
+func (this *sparseDestination) finish() error {
+	if this.file != nil && this.pos > this.size {
+		return this.file.Truncate(this.pos)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	fallocKeepSize  = 0x01 // FALLOC_FL_KEEP_SIZE
+	fallocPunchHole = 0x02 // FALLOC_FL_PUNCH_HOLE
+	seekWhenceData  = 3    // SEEK_DATA
+	seekWhenceHole  = 4    // SEEK_HOLE
+)
+
+// punchHole deallocates length bytes at off in file, which then read back as zeros.
+func punchHole(file *os.File, off int64, length int64) error {
+	return syscall.Fallocate(int(file.Fd()), fallocPunchHole|fallocKeepSize, off, length)
+}
+
+// seekFile returns the offset of the first data byte at or after off in file if data is true, or of the first
+// hole byte otherwise, like lseek with SEEK_DATA or SEEK_HOLE. It returns io.EOF if there is no data at or after
+// off and errSeekNotSupported if the file system cannot report holes.
+func seekFile(file *os.File, off int64, data bool) (int64, error) {
+	whence := seekWhenceHole
+	if data {
+		whence = seekWhenceData
+	}
+	pos, err := file.Seek(off, whence)
+	if errors.Is(err, syscall.ENXIO) {
+		return 0, io.EOF
+	}
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EOPNOTSUPP) {
+		return 0, errSeekNotSupported
+	}
+	return pos, err
+}
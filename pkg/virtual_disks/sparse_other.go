@@ -0,0 +1,35 @@
+//go:build !linux
+
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// punchHole is not supported on this platform, callers write zeros instead.
+func punchHole(file *os.File, off int64, length int64) error {
+	return errors.New("Punching holes is not supported on this platform")
+}
+
+// seekFile is not supported on this platform, files are treated as fully allocated.
+func seekFile(file *os.File, off int64, data bool) (int64, error) {
+	return 0, errSeekNotSupported
+}
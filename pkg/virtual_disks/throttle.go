@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Throttle is a token bucket rate limiter for the VDDK calls of one or more disks, see WithThrottle. Every
+// read, write or allocation query takes one operation and the bytes it transfers. The bucket holds up to
+// one second worth of tokens, so bursts are bounded while the average rate stays within the limits. Limits
+// can be changed while disks are using the throttle.
+type Throttle struct {
+	mutex          sync.Mutex
+	bytesPerSecond float64
+	opsPerSecond   float64
+	byteTokens     float64 // may be negative while calls are waiting for their share
+	opTokens       float64
+	last           time.Time
+	waits          int64
+	waitTime       time.Duration
+	now            func() time.Time // the clock, time.Now if nil; only tests set it, see newThrottle
+}
+
+// ThrottleStats reports how often and how long calls were delayed by a Throttle.
+type ThrottleStats struct {
+	Waits    int64
+	WaitTime time.Duration
+}
+
+// NewThrottle returns a throttle allowing bytesPerSecond bytes and opsPerSecond VDDK calls per second. A limit
+// of 0 disables it.
+func NewThrottle(bytesPerSecond int64, opsPerSecond int64) *Throttle {
+	return newThrottle(bytesPerSecond, opsPerSecond, nil)
+}
+
+// newThrottle is NewThrottle with the clock now, nil meaning time.Now. Tests pass a clock they control.
+func newThrottle(bytesPerSecond int64, opsPerSecond int64, now func() time.Time) *Throttle {
+	throttle := &Throttle{now: now}
+	throttle.SetLimits(bytesPerSecond, opsPerSecond)
+	return throttle
+}
+
+var (
+	hostThrottlesMutex sync.Mutex
+	hostThrottles      = make(map[string]*Throttle)
+)
+
+// HostThrottle returns the throttle shared by all disks on serverName, creating it without limits on first
+// use. Pass it to WithThrottle and adjust it with SetLimits to limit the I/O to a host.
+func HostThrottle(serverName string) *Throttle {
+	hostThrottlesMutex.Lock()
+	defer hostThrottlesMutex.Unlock()
+	throttle, ok := hostThrottles[serverName]
+	if !ok {
+		throttle = NewThrottle(0, 0)
+		hostThrottles[serverName] = throttle
+	}
+	return throttle
+}
+
+// SetLimits changes the limits, 0 disabling a limit. Calls already waiting keep their delay.
+func (this *Throttle) SetLimits(bytesPerSecond int64, opsPerSecond int64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.refillLocked()
+	this.byteTokens = newTokens(this.byteTokens, this.bytesPerSecond, float64(bytesPerSecond))
+	this.opTokens = newTokens(this.opTokens, this.opsPerSecond, float64(opsPerSecond))
+	this.bytesPerSecond = float64(bytesPerSecond)
+	this.opsPerSecond = float64(opsPerSecond)
+}
+
+// Limits returns the current limits.
+func (this *Throttle) Limits() (bytesPerSecond int64, opsPerSecond int64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return int64(this.bytesPerSecond), int64(this.opsPerSecond)
+}
+
+// Stats returns the number of calls delayed so far and the total time they waited.
+func (this *Throttle) Stats() ThrottleStats {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return ThrottleStats{
+		Waits:    this.waits,
+		WaitTime: this.waitTime,
+	}
+}
+
+// limitTokens caps tokens at one second worth of rate. Without a rate no tokens are kept.
+func limitTokens(tokens float64, rate float64) float64 {
+	if rate <= 0 {
+		return 0
+	}
+	if tokens > rate {
+		return rate
+	}
+	return tokens
+}
+
+// newTokens returns the tokens after the rate changed from oldRate to rate. A newly enabled limit starts with
+// a full bucket.
+func newTokens(tokens float64, oldRate float64, rate float64) float64 {
+	if oldRate <= 0 {
+		return limitTokens(rate, rate)
+	}
+	return limitTokens(tokens, rate)
+}
+
+func (this *Throttle) refillLocked() {
+	now := time.Now()
+	if this.now != nil {
+		now = this.now()
+	}
+	if !this.last.IsZero() {
+		elapsed := now.Sub(this.last).Seconds()
+		this.byteTokens = limitTokens(this.byteTokens+elapsed*this.bytesPerSecond, this.bytesPerSecond)
+		this.opTokens = limitTokens(this.opTokens+elapsed*this.opsPerSecond, this.opsPerSecond)
+	}
+	this.last = now
+}
+
+// reserve takes the tokens for a call transferring length bytes and returns how long it has to wait for them.
+func (this *Throttle) reserve(length int) time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.refillLocked()
+	var delay float64
+	if this.bytesPerSecond > 0 {
+		this.byteTokens -= float64(length)
+		if this.byteTokens < 0 {
+			delay = -this.byteTokens / this.bytesPerSecond
+		}
+	}
+	if this.opsPerSecond > 0 {
+		this.opTokens--
+		if this.opTokens < 0 && -this.opTokens/this.opsPerSecond > delay {
+			delay = -this.opTokens / this.opsPerSecond
+		}
+	}
+	wait := time.Duration(delay * float64(time.Second))
+	if wait > 0 {
+		this.waits++
+		this.waitTime += wait
+	}
+	return wait
+}
+
+// wait blocks until a call transferring length bytes may run. The tokens are not returned if ctx is done
+// first.
+func (this *Throttle) wait(ctx context.Context, length int) error {
+	delay := this.reserve(length)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"testing"
+	"time"
+)
+
+// testThrottle returns a throttle whose clock only moves when the returned function is called.
+func testThrottle(bytesPerSecond int64, opsPerSecond int64) (*Throttle, func(time.Duration)) {
+	now := time.Unix(0, 0)
+	throttle := newThrottle(bytesPerSecond, opsPerSecond, func() time.Time { return now })
+	return throttle, func(elapsed time.Duration) { now = now.Add(elapsed) }
+}
+
+func TestThrottleBytes(t *testing.T) {
+	throttle, advance := testThrottle(1000, 0)
+	// The bucket starts full
+	if delay := throttle.reserve(1000); delay != 0 {
+		t.Fatalf("first call delayed by %v", delay)
+	}
+	if delay := throttle.reserve(500); delay != 500*time.Millisecond {
+		t.Fatalf("call beyond the burst delayed by %v, expected 500ms", delay)
+	}
+	advance(time.Second)
+	if delay := throttle.reserve(500); delay != 0 {
+		t.Fatalf("call after the bucket refilled delayed by %v", delay)
+	}
+	// Idle time refills at most one second worth of tokens
+	advance(time.Hour)
+	if delay := throttle.reserve(3000); delay != 2*time.Second {
+		t.Fatalf("call after idling delayed by %v, expected 2s", delay)
+	}
+	if stats := throttle.Stats(); stats.Waits != 2 || stats.WaitTime != 2500*time.Millisecond {
+		t.Fatalf("stats are %+v, expected 2 waits for 2.5s", stats)
+	}
+}
+
+func TestThrottleOps(t *testing.T) {
+	throttle, _ := testThrottle(0, 10)
+	for i := 0; i < 10; i++ {
+		if delay := throttle.reserve(1 << 30); delay != 0 {
+			t.Fatalf("call %d delayed by %v", i, delay)
+		}
+	}
+	if delay := throttle.reserve(0); delay != 100*time.Millisecond {
+		t.Fatalf("eleventh call delayed by %v, expected 100ms", delay)
+	}
+}
+
+func TestThrottleSetLimits(t *testing.T) {
+	throttle, advance := testThrottle(0, 0)
+	if delay := throttle.reserve(1 << 30); delay != 0 {
+		t.Fatalf("unlimited call delayed by %v", delay)
+	}
+	// A new limit starts with a full bucket
+	throttle.SetLimits(100, 0)
+	if delay := throttle.reserve(200); delay != time.Second {
+		t.Fatalf("call after enabling the limit delayed by %v, expected 1s", delay)
+	}
+	advance(2 * time.Second)
+	// Lowering the limit caps the tokens at the new burst
+	throttle.SetLimits(10, 0)
+	if delay := throttle.reserve(20); delay != time.Second {
+		t.Fatalf("call after lowering the limit delayed by %v, expected 1s", delay)
+	}
+	if bytesPerSecond, opsPerSecond := throttle.Limits(); bytesPerSecond != 10 || opsPerSecond != 0 {
+		t.Fatalf("limits are %d bytes and %d operations per second", bytesPerSecond, opsPerSecond)
+	}
+	throttle.SetLimits(0, 0)
+	if delay := throttle.reserve(1 << 30); delay != 0 {
+		t.Fatalf("call after removing the limit delayed by %v", delay)
+	}
+}
+
+func TestHostThrottle(t *testing.T) {
+	if HostThrottle("esx1") != HostThrottle("esx1") || HostThrottle("esx1") == HostThrottle("esx2") {
+		t.Fatal("expected one throttle per host")
+	}
+}
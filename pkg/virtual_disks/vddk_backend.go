@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"context"
+
+	"github.com/vmware/virtual-disks/pkg/disklib"
+)
+
+// vddkBackend is the Backend of disks opened with Open, reading and writing through VDDK.
+type vddkBackend struct {
+	dli    disklib.VixDiskLibHandle
+	conn   disklib.VixDiskLibConnection
+	params disklib.ConnectParams
+	open   bool // false while the handles are closed, after a failed Reopen
+}
+
+// openVddkBackend prepares access, connects and opens the disk, checking ctx between the steps. On failure
+// everything done so far is undone.
+func openVddkBackend(ctx context.Context, params disklib.ConnectParams) (*vddkBackend, disklib.VixDiskLibInfo, error) {
+	err := disklib.PrepareForAccess(params)
+	if err != nil {
+		return nil, disklib.VixDiskLibInfo{}, err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		disklib.EndAccess(params)
+		return nil, disklib.VixDiskLibInfo{}, ctxErr
+	}
+	conn, err := disklib.ConnectEx(params)
+	if err != nil {
+		disklib.EndAccess(params)
+		return nil, disklib.VixDiskLibInfo{}, err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		disklib.Disconnect(conn)
+		disklib.EndAccess(params)
+		return nil, disklib.VixDiskLibInfo{}, ctxErr
+	}
+	dli, err := disklib.Open(conn, params)
+	if err != nil {
+		disklib.Disconnect(conn)
+		disklib.EndAccess(params)
+		return nil, disklib.VixDiskLibInfo{}, err
+	}
+	info, err := disklib.GetInfo(dli)
+	if err != nil {
+		disklib.Close(dli)
+		disklib.Disconnect(conn)
+		disklib.EndAccess(params)
+		return nil, disklib.VixDiskLibInfo{}, err
+	}
+	return newVddkBackend(dli, conn, params), info, nil
+}
+
+func newVddkBackend(dli disklib.VixDiskLibHandle, conn disklib.VixDiskLibConnection, params disklib.ConnectParams) *vddkBackend {
+	return &vddkBackend{
+		dli:    dli,
+		conn:   conn,
+		params: params,
+		open:   true,
+	}
+}
+
+// vddkResult turns a VddkError into an error, keeping nil untyped.
+func vddkResult(vErr disklib.VddkError) error {
+	if vErr != nil {
+		return vErr
+	}
+	return nil
+}
+
+func (this *vddkBackend) ReadSectors(startSector uint64, numSectors uint64, buf []byte) error {
+	return vddkResult(disklib.Read(this.dli, startSector, numSectors, buf))
+}
+
+func (this *vddkBackend) WriteSectors(startSector uint64, numSectors uint64, buf []byte) error {
+	return vddkResult(disklib.Write(this.dli, startSector, numSectors, buf))
+}
+
+func (this *vddkBackend) Info() (disklib.VixDiskLibInfo, error) {
+	info, vErr := disklib.GetInfo(this.dli)
+	return info, vddkResult(vErr)
+}
+
+func (this *vddkBackend) QueryAllocatedBlocks(startSector disklib.VixDiskLibSectorType, numSectors disklib.VixDiskLibSectorType, chunkSize disklib.VixDiskLibSectorType) ([]disklib.VixDiskLibBlock, error) {
+	blocks, vErr := disklib.QueryAllocatedBlocks(this.dli, startSector, numSectors, chunkSize)
+	return blocks, vddkResult(vErr)
+}
+
+func (this *vddkBackend) ReadMetadata(key string) (string, error) {
+	val, vErr := disklib.ReadMetadata(this.dli, key)
+	return val, vddkResult(vErr)
+}
+
+func (this *vddkBackend) WriteMetadata(key string, val string) error {
+	return vddkResult(disklib.WriteMetadata(this.dli, key, val))
+}
+
+func (this *vddkBackend) GetMetadataKeys() ([]string, error) {
+	keys, vErr := disklib.GetMetadataKeys(this.dli)
+	return keys, vddkResult(vErr)
+}
+
+func (this *vddkBackend) ReadOnly() bool {
+	return this.params.ReadOnly() || this.params.Flags()&disklib.VIXDISKLIB_FLAG_OPEN_READ_ONLY != 0
+}
+
+func (this *vddkBackend) Flush() error {
+	return vddkResult(disklib.Flush(this.dli))
+}
+
+func (this *vddkBackend) ReadAsync(startSector uint64, numSectors uint64, buf []byte) (*disklib.AsyncOp, error) {
+	op, vErr := disklib.ReadAsync(this.dli, startSector, numSectors, buf)
+	return op, vddkResult(vErr)
+}
+
+func (this *vddkBackend) WriteAsync(startSector uint64, numSectors uint64, buf []byte) (*disklib.AsyncOp, error) {
+	op, vErr := disklib.WriteAsync(this.dli, startSector, numSectors, buf)
+	return op, vddkResult(vErr)
+}
+
+func (this *vddkBackend) Wait() error {
+	return vddkResult(disklib.Wait(this.dli))
+}
+
+// Reopen closes the disk and connection and opens them again from the ConnectParams. PrepareForAccess stays in
+// effect, so it is not repeated.
+func (this *vddkBackend) Reopen() error {
+	if this.open {
+		// The old handles are most likely unusable, errors closing them do not matter
+		disklib.Close(this.dli)
+		disklib.Disconnect(this.conn)
+		this.open = false
+	}
+	conn, vErr := disklib.ConnectEx(this.params)
+	if vErr != nil {
+		return vErr
+	}
+	dli, vErr := disklib.Open(conn, this.params)
+	if vErr != nil {
+		disklib.Disconnect(conn)
+		return vErr
+	}
+	this.dli = dli
+	this.conn = conn
+	this.open = true
+	return nil
+}
+
+// Close closes the disk and the connection and ends the access prepared by Open. Every step is attempted
+// even if an earlier one fails, so that nothing is leaked; the first error is returned.
+func (this *vddkBackend) Close() error {
+	var firstErr disklib.VddkError
+	if this.open {
+		this.open = false
+		firstErr = disklib.Close(this.dli)
+		if vErr := disklib.Disconnect(this.conn); firstErr == nil {
+			firstErr = vErr
+		}
+	}
+	if vErr := disklib.EndAccess(this.params); firstErr == nil {
+		firstErr = vErr
+	}
+	return vddkResult(firstErr)
+}
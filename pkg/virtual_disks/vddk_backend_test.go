@@ -0,0 +1,86 @@
+//go:build vddkstub
+
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vmware/virtual-disks/pkg/disklib"
+)
+
+// A failed step of Close must not keep the later ones from releasing what they own.
+func TestVddkBackendClose(t *testing.T) {
+	libDir := os.Getenv("LIBPATH")
+	if libDir == "" {
+		t.Skip("Skipping testing if environment variables are not set.")
+	}
+	if err := disklib.Init(7, 0, libDir); err != nil {
+		t.Fatal(err)
+	}
+	baseline := disklib.StubOutstanding()
+	if baseline < 0 {
+		t.Skip("Skipping testing as LIBPATH does not point to the stub library.")
+	}
+	t.Cleanup(disklib.StubClearFaults)
+	path := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(path, make([]byte, 16*disklib.VIXDISKLIB_SECTOR_SIZE), 0644); err != nil {
+		t.Fatal(err)
+	}
+	params, err := disklib.BuildConnectParams(disklib.WithPath(path), disklib.WithIdentity("vddk_backend_test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		op string
+		// leaked is the number of stub objects the failing step keeps
+		leaked int64
+	}{
+		{"Close", 1},
+		{"Disconnect", 1},
+		{"EndAccess", 0},
+	}
+	for _, test := range tests {
+		backend, _, err := openVddkBackend(context.Background(), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := disklib.StubOutstanding()
+		disklib.StubInjectFault(test.op, disklib.VIX_E_FAIL, 0, 1)
+		// EndAccess fails as well if it is reached, without covering the first error
+		if test.op != "EndAccess" {
+			disklib.StubInjectFault("EndAccess", disklib.VIX_E_HOST_CONNECTION_LOST, 0, 1)
+		}
+		if err := backend.Close(); !errors.Is(err, disklib.ErrFailed) {
+			t.Errorf("Close with a failing %s returned %v, expected ErrFailed", test.op, err)
+		}
+		if n := disklib.StubOutstanding(); n != before-2+test.leaked {
+			t.Errorf("Close with a failing %s left %d stub objects outstanding, expected %d", test.op, n-before+2, test.leaked)
+		}
+		// The injected faults are used up only if every step was attempted
+		if vErr := disklib.EndAccess(params); vErr != nil {
+			t.Errorf("Close with a failing %s did not reach EndAccess: %v", test.op, vErr)
+		}
+		disklib.StubClearFaults()
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtual_disks
+
+import (
+	"context"
+	"sync"
+)
+
+// writeBack gathers adjacent Write calls of a DiskReaderWriter into large sector aligned writes. Data is kept
+// until bufferSize bytes are pending, or until a seek, a Sync, a Close or I/O overlapping it flushes it. A
+// failed flush is reported by the call that flushed and keeps the pending data, so that a later flush can
+// write it.
+type writeBack struct {
+	mutex      sync.Mutex
+	bufferSize int
+	off        int64  // disk offset of buf
+	buf        []byte // pending data
+}
+
+func newWriteBack(bufferSize int) *writeBack {
+	return &writeBack{
+		bufferSize: bufferSize,
+	}
+}
+
+// write buffers p for offset off, flushing the pending data first if p does not follow it. Once bufferSize
+// bytes are pending, everything up to the last sector boundary is written.
+func (this *writeBack) write(disk *Disk, p []byte, off int64) (int, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	// Writes beyond the end must fail without taking the pending data with them
+	if len(this.buf) > 0 && (off != this.off+int64(len(this.buf)) || off+int64(len(p)) > disk.Capacity()) {
+		if err := this.flushLocked(context.Background(), disk, len(this.buf)); err != nil {
+			return 0, err
+		}
+	}
+	if len(this.buf) == 0 && (len(p) >= this.bufferSize || off+int64(len(p)) > disk.Capacity()) {
+		// Nothing to gather, and writes beyond the end fail right away
+		return disk.writeAt(context.Background(), p, off)
+	}
+	if len(this.buf) == 0 {
+		this.off = off
+	}
+	pending := len(this.buf)
+	this.buf = append(this.buf, p...)
+	if len(this.buf) >= this.bufferSize {
+		sectorSize := disk.SectorSize()
+		end := (this.off + int64(len(this.buf))) / sectorSize * sectorSize
+		if err := this.flushLocked(context.Background(), disk, int(end-this.off)); err != nil {
+			// p was not written, so it must not be written by a later flush either
+			this.buf = this.buf[:pending]
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// flushLocked writes the first length bytes of the pending data and keeps the rest. If the write fails,
+// all of it is kept.
+func (this *writeBack) flushLocked(ctx context.Context, disk *Disk, length int) error {
+	if length <= 0 {
+		return nil
+	}
+	if _, err := disk.writeAt(ctx, this.buf[:length], this.off); err != nil {
+		return err
+	}
+	this.off += int64(length)
+	this.buf = append(this.buf[:0], this.buf[length:]...)
+	return nil
+}
+
+// flush writes all pending data.
+func (this *writeBack) flush(ctx context.Context, disk *Disk) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.flushLocked(ctx, disk, len(this.buf))
+}
+
+// flushOverlapping writes the pending data if it overlaps length bytes at off.
+func (this *writeBack) flushOverlapping(ctx context.Context, disk *Disk, off int64, length int) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if len(this.buf) == 0 || off >= this.off+int64(len(this.buf)) || off+int64(length) <= this.off {
+		return nil
+	}
+	return this.flushLocked(ctx, disk, len(this.buf))
+}
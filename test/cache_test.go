@@ -0,0 +1,57 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+// Small reads and writes through the block cache must see the same data as without it.
+func TestBlockCache(t *testing.T) {
+	diskReaderWriter := openLocalDisk(t, virtual_disks.WithBlockCache(4096, 4))
+	defer diskReaderWriter.Close()
+
+	const size = 64 * 1024
+	model := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(model)
+	if _, err := diskReaderWriter.WriteAt(model, 0); err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < 2000; i++ {
+		off := rnd.Intn(size - 1)
+		buf := make([]byte, 1+rnd.Intn(size-off-1)%3000)
+		if rnd.Intn(4) == 0 {
+			rnd.Read(buf)
+			if _, err := diskReaderWriter.WriteAt(buf, int64(off)); err != nil {
+				t.Fatal(err)
+			}
+			copy(model[off:], buf)
+			continue
+		}
+		if _, err := diskReaderWriter.ReadAt(buf, int64(off)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, model[off:off+len(buf)]) {
+			t.Fatalf("Read of %d bytes at %d returned stale data", len(buf), off)
+		}
+	}
+}
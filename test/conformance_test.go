@@ -0,0 +1,37 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/vmware/virtual-disks/pkg/conformance"
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+// The disk opened through VDDK must pass the same checks as the other backends.
+func TestConformance(t *testing.T) {
+	conformance.Run(t, func(t *testing.T) virtual_disks.DiskReaderWriter {
+		return openLocalDisk(t)
+	})
+}
+
+func TestConformanceCached(t *testing.T) {
+	conformance.Run(t, func(t *testing.T) virtual_disks.DiskReaderWriter {
+		return openLocalDisk(t, virtual_disks.WithBlockCache(8192, 16), virtual_disks.WithReadAhead(16384, 2), virtual_disks.WithWriteBack(8192))
+	})
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/virtual-disks/pkg/disklib"
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+func TestContextIO(t *testing.T) {
+	diskReaderWriter := openLocalDisk(t)
+	defer diskReaderWriter.Close()
+
+	data := bytes.Repeat([]byte("virtual-disks"), 100)
+	if n, err := diskReaderWriter.WriteAtContext(context.Background(), data, 100); err != nil || n != len(data) {
+		t.Fatalf("WriteAtContext returned %d, %v", n, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	buf := make([]byte, len(data))
+	if n, err := diskReaderWriter.ReadAtContext(ctx, buf, 100); err != nil || n != len(data) {
+		t.Fatalf("ReadAtContext returned %d, %v", n, err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatal("ReadAtContext returned different data")
+	}
+	if _, err := diskReaderWriter.QueryAllocatedBlocksContext(ctx, 0, disklib.VIXDISKLIB_MIN_CHUNK_SIZE, disklib.VIXDISKLIB_MIN_CHUNK_SIZE); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+	if n, err := diskReaderWriter.ReadAtContext(expired, buf, 100); !errors.Is(err, context.DeadlineExceeded) || n != 0 {
+		t.Fatalf("ReadAtContext returned %d, %v, expected %v", n, err, context.DeadlineExceeded)
+	}
+	if n, err := diskReaderWriter.WriteAtContext(expired, buf, 100); !errors.Is(err, context.DeadlineExceeded) || n != 0 {
+		t.Fatalf("WriteAtContext returned %d, %v, expected %v", n, err, context.DeadlineExceeded)
+	}
+	if _, err := diskReaderWriter.QueryAllocatedBlocksContext(expired, 0, disklib.VIXDISKLIB_MIN_CHUNK_SIZE, disklib.VIXDISKLIB_MIN_CHUNK_SIZE); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("QueryAllocatedBlocksContext returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestOpenCloseContext(t *testing.T) {
+	path := os.Getenv("LIBPATH")
+	localDisk := os.Getenv("LOCAL_DISK")
+	if path == "" || localDisk == "" {
+		t.Skip("Skipping testing if environment variables are not set.")
+	}
+	res := disklib.Init(7, 0, path)
+	if res != nil {
+		t.Fatalf("Init failed, got error code: %d, error message: %s.", res.VixErrorCode(), res.Error())
+	}
+	params, err := disklib.BuildConnectParams(disklib.WithPath(localDisk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := virtual_disks.OpenContext(cancelled, params, logrus.New()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("OpenContext returned %v, expected %v", err, context.Canceled)
+	}
+
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelTimeout()
+	diskReaderWriter, err := virtual_disks.OpenContext(ctx, params, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := diskReaderWriter.CloseContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/vmware/virtual-disks/pkg/disklib"
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+// Closing a disk twice must succeed, and every copy of it must refuse I/O afterwards.
+func TestCloseTwice(t *testing.T) {
+	diskReaderWriter := openLocalDisk(t, virtual_disks.WithWriteBack(0))
+	if _, err := diskReaderWriter.Write([]byte("buffered")); err != nil {
+		t.Fatal(err)
+	}
+	copied := diskReaderWriter
+	if err := diskReaderWriter.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := copied.Close(); err != nil {
+		t.Fatalf("Second Close failed: %v", err)
+	}
+	buf := make([]byte, disklib.VIXDISKLIB_SECTOR_SIZE)
+	if _, err := copied.ReadAt(buf, 0); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("ReadAt after Close returned %v, expected %v", err, os.ErrClosed)
+	}
+	if _, err := copied.Write(buf); !errors.Is(err, virtual_disks.ErrClosed) {
+		t.Errorf("Write after Close returned %v, expected %v", err, virtual_disks.ErrClosed)
+	}
+	if _, err := copied.Seek(0, io.SeekStart); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Seek after Close returned %v, expected %v", err, os.ErrClosed)
+	}
+	if err := copied.Sync(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Sync after Close returned %v, expected %v", err, os.ErrClosed)
+	}
+}
+
+// Writes to a disk opened read only must fail before reaching VDDK, reads must still work.
+func TestReadOnlyDisk(t *testing.T) {
+	diskReaderWriter := openLocalDiskWith(t, []disklib.ConnectOption{disklib.WithOpenFlags(disklib.VIXDISKLIB_FLAG_OPEN_READ_ONLY)})
+	defer diskReaderWriter.Close()
+
+	disk := diskReaderWriter.Disk()
+	if !disk.ReadOnly() || disk.Flags()&disklib.VIXDISKLIB_FLAG_OPEN_READ_ONLY == 0 {
+		t.Fatalf("Disk opened with flags %#x does not report read only", disk.Flags())
+	}
+	buf := make([]byte, disklib.VIXDISKLIB_SECTOR_SIZE)
+	if _, err := diskReaderWriter.ReadAt(buf, 0); err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	if _, err := diskReaderWriter.WriteAt(buf, 0); !errors.Is(err, virtual_disks.ErrReadOnly) {
+		t.Errorf("WriteAt returned %v, expected %v", err, virtual_disks.ErrReadOnly)
+	}
+	if _, err := diskReaderWriter.Write(buf); !errors.Is(err, virtual_disks.ErrReadOnly) {
+		t.Errorf("Write returned %v, expected %v", err, virtual_disks.ErrReadOnly)
+	}
+	if _, err := diskReaderWriter.WriteAsync(buf, 0); !errors.Is(err, virtual_disks.ErrReadOnly) {
+		t.Errorf("WriteAsync returned %v, expected %v", err, virtual_disks.ErrReadOnly)
+	}
+}
@@ -39,3 +39,20 @@ func TestGetThumbPrintForServer(t *testing.T) {
 			thumbprint, vmwareThumbprint, host, host)
 	}
 }
+
+func TestParseDiskUUID(t *testing.T) {
+	want := "6000c29b-692f-0425-b1ae-0d243b9c41e7"
+	for _, in := range []string{"60 00 c2 9b 69 2f 04 25-b1 ae 0d 24 3b 9c 41 e7", want} {
+		uuid, err := disklib.ParseDiskUUID(in)
+		if err != nil {
+			t.Errorf("ParseDiskUUID(%q) failed, err = %s\n", in, err)
+		} else if uuid.String() != want {
+			t.Errorf("ParseDiskUUID(%q) = %s, expected %s\n", in, uuid, want)
+		}
+	}
+	for _, in := range []string{"", "60 00 c2", "zz 00 c2 9b 69 2f 04 25-b1 ae 0d 24 3b 9c 41 e7"} {
+		if _, err := disklib.ParseDiskUUID(in); err == nil {
+			t.Errorf("ParseDiskUUID(%q) succeeded, expected an error\n", in)
+		}
+	}
+}
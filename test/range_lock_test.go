@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/virtual-disks/pkg/disklib"
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+// openLocalDisk opens the local disk named by LOCAL_DISK with opts, the disk must hold at least 128 sectors
+// and may be overwritten.
+func openLocalDisk(t *testing.T, opts ...virtual_disks.DiskOption) virtual_disks.DiskReaderWriter {
+	return openLocalDiskWith(t, nil, opts...)
+}
+
+// openLocalDiskWith is openLocalDisk with additional connect options, e.g. to open the disk read only.
+func openLocalDiskWith(t *testing.T, connectOpts []disklib.ConnectOption, opts ...virtual_disks.DiskOption) virtual_disks.DiskReaderWriter {
+	path := os.Getenv("LIBPATH")
+	localDisk := os.Getenv("LOCAL_DISK")
+	if path == "" || localDisk == "" {
+		t.Skip("Skipping testing if environment variables are not set.")
+	}
+	res := disklib.Init(7, 0, path)
+	if res != nil {
+		t.Fatalf("Init failed, got error code: %d, error message: %s.", res.VixErrorCode(), res.Error())
+	}
+	params, err := disklib.BuildConnectParams(append([]disklib.ConnectOption{disklib.WithPath(localDisk)}, connectOpts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskReaderWriter, vErr := virtual_disks.Open(params, logrus.New(), opts...)
+	if vErr != nil {
+		t.Fatalf("Open failed, got error code: %d, error message: %s.", vErr.VixErrorCode(), vErr.Error())
+	}
+	return diskReaderWriter
+}
+
+// Misaligned writes by different goroutines to different bytes of the same sectors must not lose each
+// other's updates. Every worker owns a slice of each sector and follows a seeded script, so the final disk
+// content is known whatever the interleaving.
+func TestConcurrentMisalignedWrites(t *testing.T) {
+	diskReaderWriter := openLocalDisk(t)
+	defer diskReaderWriter.Close()
+
+	const numSectors = 64
+	const workers = 8
+	const ownedBytes = disklib.VIXDISKLIB_SECTOR_SIZE / workers
+	model := make([]byte, numSectors*disklib.VIXDISKLIB_SECTOR_SIZE)
+	if _, err := diskReaderWriter.WriteAt(model, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < 200; i++ {
+				sector := rnd.Intn(numSectors)
+				off := sector*disklib.VIXDISKLIB_SECTOR_SIZE + w*ownedBytes + rnd.Intn(ownedBytes/2)
+				data := make([]byte, 1+rnd.Intn(ownedBytes/2))
+				rnd.Read(data)
+				copy(model[off:], data)
+				if _, err := diskReaderWriter.WriteAt(data, int64(off)); err != nil {
+					t.Error(err)
+					return
+				}
+				// Our own bytes must read back unchanged while the others keep writing
+				readBack := make([]byte, len(data))
+				if _, err := diskReaderWriter.ReadAt(readBack, int64(off)); err != nil || !bytes.Equal(readBack, data) {
+					t.Errorf("Read back at %d returned %v, %v, expected %v", off, readBack, err, data)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	disk := make([]byte, len(model))
+	if _, err := diskReaderWriter.ReadAt(disk, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(disk, model) {
+		t.Errorf("Disk content does not match the reference model")
+	}
+}
+
+// An aligned write racing a misaligned write to the same sector must not be undone by the read/modify/write
+// cycle of the misaligned one: the sector ends up either as the aligned write left it, or with the
+// misaligned bytes applied on top.
+func TestAlignedVersusMisalignedWrites(t *testing.T) {
+	diskReaderWriter := openLocalDisk(t)
+	defer diskReaderWriter.Close()
+
+	const firstSector = 64
+	const numSectors = 64
+	const misalignedOff = 100
+	misaligned := bytes.Repeat([]byte{'M'}, 10)
+	aligned := bytes.Repeat([]byte{'A'}, disklib.VIXDISKLIB_SECTOR_SIZE)
+	zero := make([]byte, numSectors*disklib.VIXDISKLIB_SECTOR_SIZE)
+	if _, err := diskReaderWriter.WriteAt(zero, firstSector*disklib.VIXDISKLIB_SECTOR_SIZE); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for sector := int64(firstSector); sector < firstSector+numSectors; sector++ {
+			diskReaderWriter.WriteAt(aligned, sector*disklib.VIXDISKLIB_SECTOR_SIZE)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for sector := int64(firstSector); sector < firstSector+numSectors; sector++ {
+			diskReaderWriter.WriteAt(misaligned, sector*disklib.VIXDISKLIB_SECTOR_SIZE+misalignedOff)
+		}
+	}()
+	wg.Wait()
+
+	alignedLast := aligned
+	misalignedLast := append(append(append([]byte{}, aligned[:misalignedOff]...), misaligned...), aligned[misalignedOff+len(misaligned):]...)
+	for sector := int64(firstSector); sector < firstSector+numSectors; sector++ {
+		buf := make([]byte, disklib.VIXDISKLIB_SECTOR_SIZE)
+		if _, err := diskReaderWriter.ReadAt(buf, sector*disklib.VIXDISKLIB_SECTOR_SIZE); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, alignedLast) && !bytes.Equal(buf, misalignedLast) {
+			t.Errorf("Sector %d lost the aligned write: %q", sector, buf)
+		}
+	}
+}
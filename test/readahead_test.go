@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+// Sequential reads served by the prefetcher must return the disk content, also after seeks and after writes
+// to data that was already prefetched.
+func TestReadAhead(t *testing.T) {
+	diskReaderWriter := openLocalDisk(t, virtual_disks.WithReadAhead(4096, 3))
+	defer diskReaderWriter.Close()
+
+	const size = 64 * 1024
+	model := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(model)
+	if _, err := diskReaderWriter.WriteAt(model, 0); err != nil {
+		t.Fatal(err)
+	}
+	read := func(length int) {
+		offset, err := diskReaderWriter.Seek(0, io.SeekCurrent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(diskReaderWriter, buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, model[offset:offset+int64(length)]) {
+			t.Fatalf("Read of %d bytes at %d returned wrong data", length, offset)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		read(1000)
+	}
+	// Overwrite data from the offset on, the rest of the chunk the last Read was served from is prefetched
+	offset, _ := diskReaderWriter.Seek(0, io.SeekCurrent)
+	update := bytes.Repeat([]byte{0x5a}, 3000)
+	if _, err := diskReaderWriter.WriteAt(update, offset); err != nil {
+		t.Fatal(err)
+	}
+	copy(model[offset:], update)
+	for i := 0; i < 10; i++ {
+		read(1000)
+	}
+	if _, err := diskReaderWriter.Seek(30000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		read(1500)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Copying the disk to a file with io.Copy goes through WriteTo and must reproduce the disk content, also over
+// an existing file whose old content has to be cleared from the holes.
+func TestWriteTo(t *testing.T) {
+	diskReaderWriter := openLocalDisk(t)
+	defer diskReaderWriter.Close()
+
+	capacity, err := diskReaderWriter.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("virtual-disks"), 1000)
+	if _, err := diskReaderWriter.WriteAt(data, 4096); err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]byte, capacity)
+	if _, err := diskReaderWriter.ReadAt(expected, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	dstPath := filepath.Join(t.TempDir(), "copy.img")
+	if err := os.WriteFile(dstPath, bytes.Repeat([]byte{0xff}, int(capacity)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := diskReaderWriter.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		dst, err := os.OpenFile(dstPath, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.Copy(dst, diskReaderWriter)
+		dst.Close()
+		if err != nil || n != capacity {
+			t.Fatalf("Copy returned %d, %v, expected %d bytes", n, err, capacity)
+		}
+		copied, err := os.ReadFile(dstPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(copied, expected) {
+			t.Fatalf("Copy %d differs from the disk content", i)
+		}
+		if err := os.Truncate(dstPath, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := diskReaderWriter.Seek(100, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := io.Copy(&buf, diskReaderWriter); err != nil || n != capacity-100 {
+		t.Fatalf("Copy returned %d, %v, expected %d bytes", n, err, capacity-100)
+	}
+	if !bytes.Equal(buf.Bytes(), expected[100:]) {
+		t.Fatal("Copy from offset 100 differs from the disk content")
+	}
+}
+
+// ReadFrom must write zero chunks where the disk holds data, so the result is the source content either way.
+func TestReadFrom(t *testing.T) {
+	diskReaderWriter := openLocalDisk(t)
+	defer diskReaderWriter.Close()
+
+	capacity, err := diskReaderWriter.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diskReaderWriter.WriteAt(bytes.Repeat([]byte{0xff}, int(capacity)), 0); err != nil {
+		t.Fatal(err)
+	}
+	src := make([]byte, capacity)
+	copy(src[capacity/2:], "virtual-disks")
+	if _, err := diskReaderWriter.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	// Hide the WriterTo of bytes.Reader so that io.Copy uses ReadFrom
+	n, err := io.Copy(diskReaderWriter, struct{ io.Reader }{bytes.NewReader(src)})
+	if err != nil || n != capacity {
+		t.Fatalf("Copy returned %d, %v, expected %d bytes", n, err, capacity)
+	}
+	result := make([]byte, capacity)
+	if _, err := diskReaderWriter.ReadAt(result, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, src) {
+		t.Fatal("Disk content differs from the copied data")
+	}
+}
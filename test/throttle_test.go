@@ -0,0 +1,55 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+// Reads beyond the burst of a throttle must wait, and the wait must show up in its statistics.
+func TestThrottle(t *testing.T) {
+	throttle := virtual_disks.NewThrottle(4*1024*1024, 0)
+	diskReaderWriter := openLocalDisk(t, virtual_disks.WithThrottle(throttle))
+	defer diskReaderWriter.Close()
+
+	// The bucket holds 4 MiB, the next 1 MiB takes a quarter of a second
+	buf := make([]byte, 1024*1024)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := diskReaderWriter.ReadAt(buf, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("5 MiB read in %v, expected at least 250ms", elapsed)
+	}
+	if stats := throttle.Stats(); stats.Waits == 0 || stats.WaitTime < 200*time.Millisecond {
+		t.Errorf("Throttle stats are %+v, expected a wait of about 250ms", stats)
+	}
+
+	throttle.SetLimits(0, 0)
+	start = time.Now()
+	if _, err := diskReaderWriter.ReadAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Read without limits took %v", elapsed)
+	}
+}
@@ -0,0 +1,1077 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+ * Stub implementation of the VixDiskLib subset used by pkg/disklib. Disks are
+ * plain raw image files on the local filesystem; the path passed to
+ * VixDiskLib_Open/Create is the image path. Metadata is kept in memory per
+ * open handle.
+ *
+ * Faults can be injected with VixDiskLibStub_InjectFault, or at load time
+ * through the GVDDK_STUB_FAULTS environment variable, which holds a
+ * comma-separated list of op:code[:skip[:count]] entries, e.g.
+ * "Read:36:2:1" fails the third VixDiskLib_Read with VIX_E_HOST_CONNECTION_LOST.
+ * VixDiskLibStub_Outstanding counts the objects handed out (connections,
+ * handles, infos, block lists, error texts) that were not freed yet.
+ *
+ * The create params of disks made by VixDiskLib_Create and VixDiskLib_Clone
+ * are remembered by path. Opening such a disk reports its adapter type in
+ * VixDiskLib_GetInfo and its hardware version in the virtualHWVersion
+ * metadata entry, like VDDK does; other disks have an LSI Logic adapter and
+ * no metadata.
+ *
+ * The stub behaves like the VDDK major version STUB_VERSION_MAJOR, by default
+ * the one of vixDiskLib.h. From 8 on VixDiskLib_GetInfo returns the sector
+ * sizes, which VixDiskLibStub_SetSectorSizes sets for the disks opened
+ * afterwards, and reads and writes must be aligned to the logical sector size
+ * like on a 4Kn disk. Before 8 the info ends before the sector sizes and the
+ * disks always have 512 byte sectors.
+ *
+ * "make stub" builds lib64/libvixDiskLib.so below this directory, and a 7.0
+ * stub in v7/lib64, which can then be passed to disklib.Init like a VDDK
+ * installation.
+ */
+
+#define _GNU_SOURCE
+#include <errno.h>
+#include <stddef.h>
+#include <fcntl.h>
+#include <pthread.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/stat.h>
+#include <unistd.h>
+
+#include "vixDiskLib.h"
+
+#ifndef STUB_VERSION_MAJOR
+#define STUB_VERSION_MAJOR VIXDISKLIB_VERSION_MAJOR
+#endif
+
+#define STUB_MAX_FAULTS 32
+#define STUB_MAX_METADATA 64
+#define STUB_MAX_CREATED 64
+
+typedef struct {
+   char op[32];
+   VixError err;
+   int skip;
+   int count;
+} StubFault;
+
+typedef struct {
+   char *key;
+   char *val;
+} StubMetadata;
+
+struct VixDiskLibConnectParam {
+   Bool readOnly;
+};
+
+typedef struct {
+   char *path;
+   VixDiskLibCreateParams params;
+} StubCreated;
+
+struct VixDiskLibHandleStruct {
+   int fd;
+   uint32 flags;
+   VixDiskLibSectorType capacity;
+   VixDiskLibAdapterType adapterType;
+   uint32 logicalSectorSize;
+   uint32 physicalSectorSize;
+   char *path;
+   StubMetadata metadata[STUB_MAX_METADATA];
+   int numMetadata;
+};
+
+static pthread_mutex_t stubLock = PTHREAD_MUTEX_INITIALIZER;
+static StubFault stubFaults[STUB_MAX_FAULTS];
+static int stubNumFaults;
+static int stubFaultsLoaded;
+static long stubOutstanding;
+static VixDiskLibGenericLogFunc *stubLog;
+static VixDiskLibGenericLogFunc *stubWarn;
+static VixDiskLibGenericLogFunc *stubPanic;
+static uint32 stubLogicalSectorSize = VIXDISKLIB_SECTOR_SIZE;
+static uint32 stubPhysicalSectorSize = VIXDISKLIB_SECTOR_SIZE;
+static StubCreated stubCreated[STUB_MAX_CREATED];
+static int stubNumCreated;
+
+static void
+StubTrack(long delta)
+{
+   pthread_mutex_lock(&stubLock);
+   stubOutstanding += delta;
+   pthread_mutex_unlock(&stubLock);
+}
+
+static void
+StubLogf(VixDiskLibGenericLogFunc *func, const char *fmt, ...)
+{
+   va_list args;
+
+   if (func == NULL) {
+      return;
+   }
+   va_start(args, fmt);
+   func(fmt, args);
+   va_end(args);
+}
+
+int
+VixDiskLibStub_InjectFault(const char *op, VixError err, int skip, int count)
+{
+   int ret = -1;
+
+   pthread_mutex_lock(&stubLock);
+   if (stubNumFaults < STUB_MAX_FAULTS) {
+      StubFault *f = &stubFaults[stubNumFaults++];
+      snprintf(f->op, sizeof f->op, "%s", op);
+      f->err = err;
+      f->skip = skip;
+      f->count = count;
+      ret = 0;
+   }
+   pthread_mutex_unlock(&stubLock);
+   return ret;
+}
+
+void
+VixDiskLibStub_ClearFaults(void)
+{
+   pthread_mutex_lock(&stubLock);
+   stubNumFaults = 0;
+   pthread_mutex_unlock(&stubLock);
+}
+
+/*
+ * VixDiskLibStub_Log sends "<msg> <n>" to the log (0), warning (1) or panic (2)
+ * handler passed to Init, formatted by the handler like VDDK's own messages.
+ */
+void
+VixDiskLibStub_Log(int level, const char *msg, int n)
+{
+   VixDiskLibGenericLogFunc *handlers[] = { stubLog, stubWarn, stubPanic };
+
+   if (level >= 0 && level < 3) {
+      StubLogf(handlers[level], "%s %d\n", msg, n);
+   }
+}
+
+void
+VixDiskLibStub_SetSectorSizes(uint32 logical, uint32 physical)
+{
+#if STUB_VERSION_MAJOR >= 8
+   pthread_mutex_lock(&stubLock);
+   stubLogicalSectorSize = logical;
+   stubPhysicalSectorSize = physical;
+   pthread_mutex_unlock(&stubLock);
+#else
+   (void)logical;
+   (void)physical;
+#endif
+}
+
+long
+VixDiskLibStub_Outstanding(void)
+{
+   long n;
+
+   pthread_mutex_lock(&stubLock);
+   n = stubOutstanding;
+   pthread_mutex_unlock(&stubLock);
+   return n;
+}
+
+static void
+StubLoadFaults(void)
+{
+   const char *env = getenv("GVDDK_STUB_FAULTS");
+   char *copy, *entry, *save = NULL;
+
+   stubFaultsLoaded = 1;
+   if (env == NULL || *env == '\0') {
+      return;
+   }
+   copy = strdup(env);
+   for (entry = strtok_r(copy, ",", &save); entry != NULL; entry = strtok_r(NULL, ",", &save)) {
+      char op[32];
+      unsigned long long code = 0;
+      int skip = 0, count = -1;
+      if (sscanf(entry, "%31[^:]:%llu:%d:%d", op, &code, &skip, &count) >= 2 &&
+          stubNumFaults < STUB_MAX_FAULTS) {
+         StubFault *f = &stubFaults[stubNumFaults++];
+         snprintf(f->op, sizeof f->op, "%s", op);
+         f->err = code;
+         f->skip = skip;
+         f->count = count;
+      }
+   }
+   free(copy);
+}
+
+/*
+ * StubFault returns the error to inject for op, or VIX_OK. A fault first lets
+ * skip calls through, then fails count calls (forever when count < 0).
+ */
+static VixError
+StubCheckFault(const char *op)
+{
+   VixError err = VIX_OK;
+   int i;
+
+   pthread_mutex_lock(&stubLock);
+   if (!stubFaultsLoaded) {
+      StubLoadFaults();
+   }
+   for (i = 0; i < stubNumFaults; i++) {
+      StubFault *f = &stubFaults[i];
+      if (strcmp(f->op, op) != 0 && strcmp(f->op, "*") != 0) {
+         continue;
+      }
+      if (f->skip > 0) {
+         f->skip--;
+         continue;
+      }
+      if (f->count == 0) {
+         continue;
+      }
+      if (f->count > 0) {
+         f->count--;
+      }
+      err = f->err;
+      break;
+   }
+   pthread_mutex_unlock(&stubLock);
+   return err;
+}
+
+#define STUB_FAULT(op)                      \
+   do {                                     \
+      VixError _err = StubCheckFault(op);   \
+      if (VIX_FAILED(_err)) {               \
+         return _err;                       \
+      }                                     \
+   } while (0)
+
+static VixError
+StubErrno(int err)
+{
+   switch (err) {
+   case ENOENT:
+      return VIX_E_FILE_NOT_FOUND;
+   case EACCES:
+   case EPERM:
+      return VIX_E_FILE_ACCESS_ERROR;
+   case EROFS:
+      return VIX_E_FILE_READ_ONLY;
+   case EEXIST:
+      return VIX_E_FILE_ALREADY_EXISTS;
+   case ENOSPC:
+      return VIX_E_DISK_FULL;
+   default:
+      return VIX_E_FILE_ERROR;
+   }
+}
+
+VixError
+VixDiskLib_Init(uint32 majorVersion, uint32 minorVersion, VixDiskLibGenericLogFunc *log,
+                VixDiskLibGenericLogFunc *warn, VixDiskLibGenericLogFunc *panic, const char *libDir)
+{
+   STUB_FAULT("Init");
+   stubLog = log;
+   stubWarn = warn;
+   stubPanic = panic;
+   StubLogf(stubLog, "VixDiskLib stub %u.%u initialized from %s", majorVersion, minorVersion,
+            libDir == NULL ? "(null)" : libDir);
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_InitEx(uint32 majorVersion, uint32 minorVersion, VixDiskLibGenericLogFunc *log,
+                  VixDiskLibGenericLogFunc *warn, VixDiskLibGenericLogFunc *panic, const char *libDir,
+                  const char *configFile)
+{
+   STUB_FAULT("InitEx");
+   return VixDiskLib_Init(majorVersion, minorVersion, log, warn, panic, libDir);
+}
+
+void
+VixDiskLib_Exit(void)
+{
+   stubLog = NULL;
+   stubWarn = NULL;
+   stubPanic = NULL;
+}
+
+const char *
+VixDiskLib_ListTransportModes(void)
+{
+   return "file:nbd";
+}
+
+VixDiskLibConnectParams *
+VixDiskLib_AllocateConnectParams(void)
+{
+   StubTrack(1);
+   return calloc(1, sizeof(VixDiskLibConnectParams));
+}
+
+void
+VixDiskLib_FreeConnectParams(VixDiskLibConnectParams *connectParams)
+{
+   if (connectParams != NULL) {
+      StubTrack(-1);
+   }
+   free(connectParams);
+}
+
+VixError
+VixDiskLib_Connect(const VixDiskLibConnectParams *connectParams, VixDiskLibConnection *connection)
+{
+   return VixDiskLib_ConnectEx(connectParams, 0, NULL, NULL, connection);
+}
+
+VixError
+VixDiskLib_ConnectEx(const VixDiskLibConnectParams *connectParams, Bool readOnly, const char *snapshotRef,
+                     const char *transportModes, VixDiskLibConnection *connection)
+{
+   STUB_FAULT("ConnectEx");
+   if (connectParams == NULL || connection == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   *connection = calloc(1, sizeof(struct VixDiskLibConnectParam));
+   (*connection)->readOnly = readOnly;
+   StubTrack(1);
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_Disconnect(VixDiskLibConnection connection)
+{
+   STUB_FAULT("Disconnect");
+   if (connection == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   free(connection);
+   StubTrack(-1);
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_PrepareForAccess(const VixDiskLibConnectParams *connectParams, const char *identity)
+{
+   STUB_FAULT("PrepareForAccess");
+   return connectParams == NULL ? VIX_E_INVALID_ARG : VIX_OK;
+}
+
+VixError
+VixDiskLib_EndAccess(const VixDiskLibConnectParams *connectParams, const char *identity)
+{
+   STUB_FAULT("EndAccess");
+   return connectParams == NULL ? VIX_E_INVALID_ARG : VIX_OK;
+}
+
+VixError
+VixDiskLib_Cleanup(const VixDiskLibConnectParams *connectParams, uint32 *numCleanedUp, uint32 *numRemaining)
+{
+   STUB_FAULT("Cleanup");
+   if (numCleanedUp != NULL) {
+      *numCleanedUp = 2;
+   }
+   if (numRemaining != NULL) {
+      *numRemaining = 1;
+   }
+   return VIX_OK;
+}
+
+/* Remembers the create params of the disk at path, forgetting the oldest disk when full */
+static void
+StubRecordCreated(const char *path, const VixDiskLibCreateParams *params)
+{
+   StubCreated *created = NULL;
+   int i;
+
+   pthread_mutex_lock(&stubLock);
+   for (i = 0; i < stubNumCreated; i++) {
+      if (strcmp(stubCreated[i].path, path) == 0) {
+         created = &stubCreated[i];
+         break;
+      }
+   }
+   if (created == NULL) {
+      if (stubNumCreated == STUB_MAX_CREATED) {
+         free(stubCreated[0].path);
+         memmove(stubCreated, stubCreated + 1, (STUB_MAX_CREATED - 1) * sizeof stubCreated[0]);
+         stubNumCreated--;
+      }
+      created = &stubCreated[stubNumCreated++];
+      created->path = strdup(path);
+   }
+   created->params = *params;
+   pthread_mutex_unlock(&stubLock);
+}
+
+static Bool
+StubFindCreated(const char *path, VixDiskLibCreateParams *params)
+{
+   Bool found = 0;
+   int i;
+
+   pthread_mutex_lock(&stubLock);
+   for (i = 0; i < stubNumCreated; i++) {
+      if (strcmp(stubCreated[i].path, path) == 0) {
+         *params = stubCreated[i].params;
+         found = 1;
+         break;
+      }
+   }
+   pthread_mutex_unlock(&stubLock);
+   return found;
+}
+
+VixError
+VixDiskLib_Open(const VixDiskLibConnection connection, const char *path, uint32 flags,
+                VixDiskLibHandle *diskHandle)
+{
+   VixDiskLibCreateParams params;
+   char hwVersion[16];
+   struct stat st;
+   VixDiskLibHandle h;
+   Bool readOnly;
+   int fd;
+
+   STUB_FAULT("Open");
+   if (connection == NULL || path == NULL || diskHandle == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   readOnly = connection->readOnly || (flags & VIXDISKLIB_FLAG_OPEN_READ_ONLY) != 0;
+   fd = open(path, readOnly ? O_RDONLY : O_RDWR);
+   if (fd < 0) {
+      return StubErrno(errno);
+   }
+   if (fstat(fd, &st) != 0) {
+      close(fd);
+      return StubErrno(errno);
+   }
+   h = calloc(1, sizeof *h);
+   h->fd = fd;
+   h->flags = flags | (readOnly ? VIXDISKLIB_FLAG_OPEN_READ_ONLY : 0);
+   h->capacity = st.st_size / VIXDISKLIB_SECTOR_SIZE;
+   pthread_mutex_lock(&stubLock);
+   h->logicalSectorSize = stubLogicalSectorSize;
+   h->physicalSectorSize = stubPhysicalSectorSize;
+   pthread_mutex_unlock(&stubLock);
+   h->path = strdup(path);
+   h->adapterType = VIXDISKLIB_ADAPTER_SCSI_LSILOGIC;
+   if (StubFindCreated(path, &params)) {
+      h->adapterType = params.adapterType;
+      snprintf(hwVersion, sizeof hwVersion, "%u", params.hwVersion);
+      h->metadata[0].key = strdup("virtualHWVersion");
+      h->metadata[0].val = strdup(hwVersion);
+      h->numMetadata = 1;
+   }
+   *diskHandle = h;
+   StubTrack(1);
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_Close(VixDiskLibHandle diskHandle)
+{
+   int i;
+
+   STUB_FAULT("Close");
+   if (diskHandle == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   close(diskHandle->fd);
+   for (i = 0; i < diskHandle->numMetadata; i++) {
+      free(diskHandle->metadata[i].key);
+      free(diskHandle->metadata[i].val);
+   }
+   free(diskHandle->path);
+   free(diskHandle);
+   StubTrack(-1);
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_GetInfo(VixDiskLibHandle diskHandle, VixDiskLibInfo **info)
+{
+   VixDiskLibInfo i = { 0 };
+#if STUB_VERSION_MAJOR >= 8
+   size_t size = sizeof i;
+#else
+   /* VDDK 7 allocates the info without the sector sizes */
+   size_t size = offsetof(VixDiskLibInfo, logicalSectorSize);
+#endif
+
+   STUB_FAULT("GetInfo");
+   if (diskHandle == NULL || info == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   i.capacity = diskHandle->capacity;
+   i.adapterType = diskHandle->adapterType;
+   i.numLinks = 1;
+   i.biosGeo.cylinders = (uint32)(diskHandle->capacity / (255 * 63));
+   i.biosGeo.heads = 255;
+   i.biosGeo.sectors = 63;
+   i.physGeo = i.biosGeo;
+   i.parentFileNameHint = strdup("");
+   i.uuid = strdup("60 00 c2 9b 69 2f 04 25-b1 ae 0d 24 3b 9c 41 e7");
+   i.logicalSectorSize = diskHandle->logicalSectorSize;
+   i.physicalSectorSize = diskHandle->physicalSectorSize;
+   *info = malloc(size);
+   memcpy(*info, &i, size);
+   StubTrack(1);
+   return VIX_OK;
+}
+
+void
+VixDiskLib_FreeInfo(VixDiskLibInfo *info)
+{
+   if (info == NULL) {
+      return;
+   }
+   free(info->parentFileNameHint);
+   free(info->uuid);
+   free(info);
+   StubTrack(-1);
+}
+
+const char *
+VixDiskLib_GetTransportMode(VixDiskLibHandle diskHandle)
+{
+   return "file";
+}
+
+static VixError
+StubCheckRange(VixDiskLibHandle h, VixDiskLibSectorType startSector, VixDiskLibSectorType numSectors)
+{
+   if (h == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   if (startSector > h->capacity || numSectors > h->capacity - startSector) {
+      return VIX_E_DISK_OUTOFRANGE;
+   }
+   if ((startSector * VIXDISKLIB_SECTOR_SIZE) % h->logicalSectorSize != 0 ||
+       (numSectors * VIXDISKLIB_SECTOR_SIZE) % h->logicalSectorSize != 0) {
+      return VIX_E_INVALID_ARG;
+   }
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_Read(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector,
+                VixDiskLibSectorType numSectors, uint8 *readBuffer)
+{
+   size_t len = numSectors * VIXDISKLIB_SECTOR_SIZE;
+   off_t off = startSector * VIXDISKLIB_SECTOR_SIZE;
+   VixError err;
+
+   STUB_FAULT("Read");
+   err = StubCheckRange(diskHandle, startSector, numSectors);
+   if (VIX_FAILED(err)) {
+      return err;
+   }
+   while (len > 0) {
+      ssize_t n = pread(diskHandle->fd, readBuffer, len, off);
+      if (n < 0) {
+         if (errno == EINTR) {
+            continue;
+         }
+         return StubErrno(errno);
+      }
+      if (n == 0) {
+         memset(readBuffer, 0, len);
+         break;
+      }
+      readBuffer += n;
+      off += n;
+      len -= n;
+   }
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_Write(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector,
+                 VixDiskLibSectorType numSectors, const uint8 *writeBuffer)
+{
+   size_t len = numSectors * VIXDISKLIB_SECTOR_SIZE;
+   off_t off = startSector * VIXDISKLIB_SECTOR_SIZE;
+   VixError err;
+
+   STUB_FAULT("Write");
+   err = StubCheckRange(diskHandle, startSector, numSectors);
+   if (VIX_FAILED(err)) {
+      return err;
+   }
+   if (diskHandle->flags & VIXDISKLIB_FLAG_OPEN_READ_ONLY) {
+      return VIX_E_FILE_READ_ONLY;
+   }
+   while (len > 0) {
+      ssize_t n = pwrite(diskHandle->fd, writeBuffer, len, off);
+      if (n < 0) {
+         if (errno == EINTR) {
+            continue;
+         }
+         return StubErrno(errno);
+      }
+      writeBuffer += n;
+      off += n;
+      len -= n;
+   }
+   return VIX_OK;
+}
+
+/*
+ * The asynchronous calls complete synchronously, but still report VIX_ASYNC
+ * and deliver the result through the completion callback like VDDK does.
+ */
+VixError
+VixDiskLib_ReadAsync(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector,
+                     VixDiskLibSectorType numSectors, uint8 *readBuffer,
+                     VixDiskLibCompletionCB callback, void *cbData)
+{
+   VixError err;
+
+   STUB_FAULT("ReadAsync");
+   err = VixDiskLib_Read(diskHandle, startSector, numSectors, readBuffer);
+   callback(cbData, err);
+   return VIX_ASYNC;
+}
+
+VixError
+VixDiskLib_WriteAsync(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector,
+                      VixDiskLibSectorType numSectors, const uint8 *writeBuffer,
+                      VixDiskLibCompletionCB callback, void *cbData)
+{
+   VixError err;
+
+   STUB_FAULT("WriteAsync");
+   err = VixDiskLib_Write(diskHandle, startSector, numSectors, writeBuffer);
+   callback(cbData, err);
+   return VIX_ASYNC;
+}
+
+VixError
+VixDiskLib_Wait(VixDiskLibHandle diskHandle)
+{
+   STUB_FAULT("Wait");
+   return diskHandle == NULL ? VIX_E_INVALID_ARG : VIX_OK;
+}
+
+VixError
+VixDiskLib_Flush(VixDiskLibHandle diskHandle)
+{
+   STUB_FAULT("Flush");
+   if (diskHandle == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   if (fsync(diskHandle->fd) != 0 && errno != EINVAL && errno != EBADF) {
+      return StubErrno(errno);
+   }
+   return VIX_OK;
+}
+
+static StubMetadata *
+StubFindMetadata(VixDiskLibHandle h, const char *key)
+{
+   int i;
+
+   for (i = 0; i < h->numMetadata; i++) {
+      if (strcmp(h->metadata[i].key, key) == 0) {
+         return &h->metadata[i];
+      }
+   }
+   return NULL;
+}
+
+VixError
+VixDiskLib_ReadMetadata(VixDiskLibHandle diskHandle, const char *key, char *buf, size_t bufLen,
+                        size_t *requiredLen)
+{
+   StubMetadata *md;
+   size_t len;
+
+   STUB_FAULT("ReadMetadata");
+   if (diskHandle == NULL || key == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   md = StubFindMetadata(diskHandle, key);
+   if (md == NULL) {
+      return VIX_E_DISK_KEY_NOTFOUND;
+   }
+   len = strlen(md->val) + 1;
+   if (requiredLen != NULL) {
+      *requiredLen = len;
+   }
+   if (buf == NULL || bufLen < len) {
+      return VIX_E_BUFFER_TOOSMALL;
+   }
+   memcpy(buf, md->val, len);
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_WriteMetadata(VixDiskLibHandle diskHandle, const char *key, const char *val)
+{
+   StubMetadata *md;
+
+   STUB_FAULT("WriteMetadata");
+   if (diskHandle == NULL || key == NULL || val == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   md = StubFindMetadata(diskHandle, key);
+   if (md == NULL) {
+      if (diskHandle->numMetadata == STUB_MAX_METADATA) {
+         return VIX_E_OUT_OF_MEMORY;
+      }
+      md = &diskHandle->metadata[diskHandle->numMetadata++];
+      md->key = strdup(key);
+   } else {
+      free(md->val);
+   }
+   md->val = strdup(val);
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_GetMetadataKeys(VixDiskLibHandle diskHandle, char *keysBuffer, size_t bufLen,
+                           size_t *requiredLen)
+{
+   size_t len = 1;
+   int i;
+
+   STUB_FAULT("GetMetadataKeys");
+   if (diskHandle == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   for (i = 0; i < diskHandle->numMetadata; i++) {
+      len += strlen(diskHandle->metadata[i].key) + 1;
+   }
+   if (requiredLen != NULL) {
+      *requiredLen = len;
+   }
+   if (keysBuffer == NULL || bufLen < len) {
+      return VIX_E_BUFFER_TOOSMALL;
+   }
+   for (i = 0; i < diskHandle->numMetadata; i++) {
+      size_t n = strlen(diskHandle->metadata[i].key) + 1;
+      memcpy(keysBuffer, diskHandle->metadata[i].key, n);
+      keysBuffer += n;
+   }
+   *keysBuffer = '\0';
+   return VIX_OK;
+}
+
+static VixError
+StubProgress(VixDiskLibProgressFunc progressFunc, void *progressCallbackData)
+{
+   int pct;
+
+   if (progressFunc == NULL) {
+      return VIX_OK;
+   }
+   for (pct = 0; pct <= 100; pct += 25) {
+      if (!progressFunc(progressCallbackData, pct)) {
+         return VIX_E_CANCELLED;
+      }
+   }
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_Create(const VixDiskLibConnection connection, const char *path,
+                  const VixDiskLibCreateParams *createParams,
+                  VixDiskLibProgressFunc progressFunc, void *progressCallbackData)
+{
+   VixError err;
+   int fd;
+
+   STUB_FAULT("Create");
+   if (connection == NULL || path == NULL || createParams == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   err = StubProgress(progressFunc, progressCallbackData);
+   if (VIX_FAILED(err)) {
+      return err;
+   }
+   fd = open(path, O_RDWR | O_CREAT | O_EXCL, 0644);
+   if (fd < 0) {
+      return StubErrno(errno);
+   }
+   if (ftruncate(fd, createParams->capacity * VIXDISKLIB_SECTOR_SIZE) != 0) {
+      err = StubErrno(errno);
+      close(fd);
+      return err;
+   }
+   close(fd);
+   StubRecordCreated(path, createParams);
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_CreateChild(VixDiskLibHandle diskHandle, const char *childPath, VixDiskLibDiskType diskType,
+                       VixDiskLibProgressFunc progressFunc, void *progressCallbackData)
+{
+   STUB_FAULT("CreateChild");
+   if (diskHandle == NULL || childPath == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   return StubProgress(progressFunc, progressCallbackData);
+}
+
+VixError
+VixDiskLib_Clone(const VixDiskLibConnection dstConnection, const char *dstPath,
+                 const VixDiskLibConnection srcConnection, const char *srcPath,
+                 const VixDiskLibCreateParams *vixCreateParams,
+                 VixDiskLibProgressFunc progressFunc, void *progressCallbackData, Bool overWrite)
+{
+   char buf[64 * 1024];
+   VixError err = VIX_OK;
+   int src, dst;
+   ssize_t n;
+
+   STUB_FAULT("Clone");
+   if (dstConnection == NULL || srcConnection == NULL || dstPath == NULL || srcPath == NULL ||
+       vixCreateParams == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   err = StubProgress(progressFunc, progressCallbackData);
+   if (VIX_FAILED(err)) {
+      return err;
+   }
+   src = open(srcPath, O_RDONLY);
+   if (src < 0) {
+      return StubErrno(errno);
+   }
+   dst = open(dstPath, O_WRONLY | O_CREAT | O_TRUNC | (overWrite ? 0 : O_EXCL), 0644);
+   if (dst < 0) {
+      err = StubErrno(errno);
+      close(src);
+      return err;
+   }
+   while ((n = read(src, buf, sizeof buf)) > 0) {
+      if (write(dst, buf, n) != n) {
+         err = StubErrno(errno);
+         break;
+      }
+   }
+   if (n < 0) {
+      err = StubErrno(errno);
+   }
+   close(src);
+   close(dst);
+   if (VIX_SUCCEEDED(err)) {
+      StubRecordCreated(dstPath, vixCreateParams);
+   }
+   return err;
+}
+
+VixError
+VixDiskLib_Grow(VixDiskLibConnection connection, const char *path, VixDiskLibSectorType capacity,
+                Bool updateGeometry, VixDiskLibProgressFunc progressFunc, void *progressCallbackData)
+{
+   VixError err;
+
+   STUB_FAULT("Grow");
+   if (connection == NULL || path == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   err = StubProgress(progressFunc, progressCallbackData);
+   if (VIX_FAILED(err)) {
+      return err;
+   }
+   if (truncate(path, capacity * VIXDISKLIB_SECTOR_SIZE) != 0) {
+      return StubErrno(errno);
+   }
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_Shrink(VixDiskLibHandle diskHandle, VixDiskLibProgressFunc progressFunc, void *progressCallbackData)
+{
+   STUB_FAULT("Shrink");
+   if (diskHandle == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   return StubProgress(progressFunc, progressCallbackData);
+}
+
+VixError
+VixDiskLib_Defragment(VixDiskLibHandle diskHandle, VixDiskLibProgressFunc progressFunc, void *progressCallbackData)
+{
+   STUB_FAULT("Defragment");
+   if (diskHandle == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   return StubProgress(progressFunc, progressCallbackData);
+}
+
+VixError
+VixDiskLib_Attach(VixDiskLibHandle parent, VixDiskLibHandle child)
+{
+   STUB_FAULT("Attach");
+   return parent == NULL || child == NULL ? VIX_E_INVALID_ARG : VIX_OK;
+}
+
+VixError
+VixDiskLib_Rename(const char *srcFileName, const char *dstFileName)
+{
+   STUB_FAULT("Rename");
+   if (rename(srcFileName, dstFileName) != 0) {
+      return StubErrno(errno);
+   }
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_Unlink(VixDiskLibConnection connection, const char *path)
+{
+   STUB_FAULT("Unlink");
+   if (unlink(path) != 0) {
+      return StubErrno(errno);
+   }
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_SpaceNeededForClone(VixDiskLibHandle diskHandle, VixDiskLibDiskType cloneDiskType, uint64 *spaceNeeded)
+{
+   STUB_FAULT("SpaceNeededForClone");
+   if (diskHandle == NULL || spaceNeeded == NULL) {
+      return VIX_E_INVALID_ARG;
+   }
+   *spaceNeeded = diskHandle->capacity * VIXDISKLIB_SECTOR_SIZE;
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_CheckRepair(const VixDiskLibConnection connection, const char *filename, Bool repair)
+{
+   STUB_FAULT("CheckRepair");
+   return access(filename, F_OK) == 0 ? VIX_OK : VIX_E_FILE_NOT_FOUND;
+}
+
+VixError
+VixDiskLib_QueryAllocatedBlocks(VixDiskLibHandle diskHandle, VixDiskLibSectorType startSector,
+                                VixDiskLibSectorType numSectors, VixDiskLibSectorType chunkSize,
+                                VixDiskLibBlockList **blockList)
+{
+   VixDiskLibBlockList *bl;
+   uint32 max, n = 0;
+   off_t pos, end, data, hole, chunkBytes;
+   VixError err;
+
+   STUB_FAULT("QueryAllocatedBlocks");
+   if (blockList == NULL || chunkSize < VIXDISKLIB_MIN_CHUNK_SIZE || chunkSize > VIXDISKLIB_MAX_CHUNK_SIZE ||
+       startSector % chunkSize != 0 || numSectors % chunkSize != 0) {
+      return VIX_E_INVALID_ARG;
+   }
+   err = StubCheckRange(diskHandle, startSector, numSectors);
+   if (VIX_FAILED(err)) {
+      return err;
+   }
+   max = numSectors / chunkSize + 1;
+   bl = calloc(1, sizeof *bl + max * sizeof(VixDiskLibBlock));
+   chunkBytes = chunkSize * VIXDISKLIB_SECTOR_SIZE;
+   pos = startSector * VIXDISKLIB_SECTOR_SIZE;
+   end = pos + numSectors * VIXDISKLIB_SECTOR_SIZE;
+   while (pos < end) {
+      data = lseek(diskHandle->fd, pos, SEEK_DATA);
+      if (data < 0 || data >= end) {
+         break;
+      }
+      hole = lseek(diskHandle->fd, data, SEEK_HOLE);
+      if (hole < 0 || hole > end) {
+         hole = end;
+      }
+      data -= data % chunkBytes;
+      hole += (chunkBytes - hole % chunkBytes) % chunkBytes;
+      if (hole > end) {
+         hole = end;
+      }
+      if (n > 0 && bl->blocks[n - 1].offset + bl->blocks[n - 1].length >= (VixDiskLibSectorType)(data / VIXDISKLIB_SECTOR_SIZE)) {
+         bl->blocks[n - 1].length = hole / VIXDISKLIB_SECTOR_SIZE - bl->blocks[n - 1].offset;
+      } else {
+         bl->blocks[n].offset = data / VIXDISKLIB_SECTOR_SIZE;
+         bl->blocks[n].length = (hole - data) / VIXDISKLIB_SECTOR_SIZE;
+         n++;
+      }
+      pos = hole;
+   }
+   bl->numBlocks = n;
+   *blockList = bl;
+   StubTrack(1);
+   return VIX_OK;
+}
+
+VixError
+VixDiskLib_FreeBlockList(VixDiskLibBlockList *blockList)
+{
+   if (blockList != NULL) {
+      StubTrack(-1);
+   }
+   free(blockList);
+   return VIX_OK;
+}
+
+char *
+VixDiskLib_GetErrorText(VixError err, const char *locale)
+{
+   const char *text;
+   char *buf;
+
+   switch (VIX_ERROR_CODE(err)) {
+   case VIX_OK:
+      text = "The operation was successful";
+      break;
+   case VIX_E_FILE_NOT_FOUND:
+      text = "A file was not found";
+      break;
+   case VIX_E_FILE_ACCESS_ERROR:
+      text = "Insufficient permissions";
+      break;
+   case VIX_E_HOST_CONNECTION_LOST:
+      text = "The connection to the host was lost";
+      break;
+   case VIX_E_DISK_OUTOFRANGE:
+      text = "The specified sector is out of range";
+      break;
+   case VIX_E_NOT_SUPPORTED:
+      text = "The operation is not supported";
+      break;
+   default:
+      text = "Unknown error";
+      break;
+   }
+   buf = strdup(text);
+   StubTrack(1);
+   return buf;
+}
+
+void
+VixDiskLib_FreeErrorText(char *errMsg)
+{
+   if (errMsg != NULL) {
+      StubTrack(-1);
+   }
+   free(errMsg);
+}
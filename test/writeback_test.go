@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2018-2021 the Go Library for Virtual Disk Development Kit contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/vmware/virtual-disks/pkg/virtual_disks"
+)
+
+// Small writes gathered by the write-back buffer must be visible to reads before and after they are flushed.
+func TestWriteBack(t *testing.T) {
+	diskReaderWriter := openLocalDisk(t, virtual_disks.WithWriteBack(8192))
+	defer diskReaderWriter.Close()
+
+	const size = 64 * 1024
+	model := make([]byte, size)
+	if _, err := diskReaderWriter.WriteAt(model, 0); err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	if _, err := diskReaderWriter.Seek(100, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	offset := 100
+	for offset < size-1000 {
+		buf := make([]byte, 1+rnd.Intn(999))
+		rnd.Read(buf)
+		if n, err := diskReaderWriter.Write(buf); err != nil || n != len(buf) {
+			t.Fatalf("Write returned %d, %v", n, err)
+		}
+		copy(model[offset:], buf)
+		offset += len(buf)
+		if rnd.Intn(10) == 0 {
+			// Reads overlapping buffered data flush it first
+			check := make([]byte, 300)
+			if _, err := diskReaderWriter.ReadAt(check, int64(offset-150)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(check, model[offset-150:offset+150]) {
+				t.Fatalf("ReadAt at %d missed buffered writes", offset-150)
+			}
+		}
+	}
+	if err := diskReaderWriter.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	result := make([]byte, size)
+	if _, err := diskReaderWriter.ReadAt(result, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, model) {
+		t.Fatal("Disk content differs from the written data")
+	}
+}